@@ -1,5 +1,7 @@
 package constant
 
+import "sync"
+
 const Separator = "/"
 
 const LogContent = "\"this is a log\""
@@ -31,3 +33,12 @@ const (
 
 // CallGraph 根据数字区分调用图
 var CallGraph = make(map[int]map[string]map[string]string)
+
+// CallCount 根据数字区分的调用边被观测到的次数
+var CallCount = make(map[int]map[string]map[string]int)
+
+// CallStacks 根据数字区分的完整调用链(从外到内, 换行连接)被观测到的次数
+var CallStacks = make(map[int]map[string]int)
+
+// CallGraphMu 保护CallGraph、CallCount和CallStacks, 服务端会并发地收到调用栈
+var CallGraphMu sync.Mutex
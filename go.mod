@@ -5,11 +5,11 @@ go 1.18
 require (
 	github.com/dataznGao/bingo v0.0.30
 	github.com/tealeg/xlsx v1.0.5
+	golang.org/x/mod v0.7.0
 	golang.org/x/tools v0.4.0
 )
 
 require (
-	golang.org/x/mod v0.7.0 // indirect
 	golang.org/x/sys v0.4.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -1,20 +1,58 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
 	_log "github.com/dataznGao/leo/pkg/log"
 )
 
+var (
+	inputFlag  = flag.String("input", "/Users/misery/GolandProjects/jupiter", "Path of the project to analyse.")
+	outputFlag = flag.String("output", "/Users/misery/GolandProjects/jupiter2", "Path to write the log-enhanced project to.")
+)
+
+const usage = `usage: leo [command] [flags]
+
+commands:
+  log        generate call graph diffs, save them to -diffs and inject logs into -output (default)
+  inject     inject logs into -output from the diffs saved in -diffs, without regenerating call graphs
+  serve-ui   browse the call graphs exported to -exportdir in a local web UI
+  query      query the call graph of -input (or -querygraph), see below
+
+flags:
+`
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), "\n"+callgraph.QueryUsage)
+	}
+	cmd, args := "log", os.Args[1:]
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		cmd, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
 
-	err := _log.Log("/Users/misery/GolandProjects/jupiter",
-		"/Users/misery/GolandProjects/jupiter2")
+	var err error
+	switch cmd {
+	case "log":
+		err = _log.Log(*inputFlag, *outputFlag)
+	case "inject":
+		err = _log.Inject(*inputFlag, *outputFlag)
+	case "serve-ui":
+		err = callgraph.ServeUI(*inputFlag)
+	case "query":
+		err = callgraph.Query(*inputFlag, flag.Args(), os.Stdout)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
 	if err != nil {
-		return
+		log.Fatalf("[leo] ERROR %v", err)
 	}
-
-	//err := _log.generateDiff("/Users/misery/GolandProjects/tidb", "/Users/misery/GolandProjects/tidb/bindinfo",
-	//	"/Users/misery/GolandProjects/tidb2")
-	//if err != nil {
-	//	return
-	//}
 }
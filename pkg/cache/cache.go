@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// version 缓存内容的格式版本, 格式变化时修改, 旧的缓存自然失效
+const version = "8"
+
+var Dir = flag.String("cacheDir", "", "Cache static/dynamic call graphs and diffs in this directory, keyed by the hash of the module's Go files, go.mod/go.sum/go.work, build tags and leo options. Rendered images of serve-ui are cached under img/, add 'refresh=true' to the URL query to re-render")
+
+// Enabled 是否设置了-cacheDir
+func Enabled() bool {
+	return *Dir != ""
+}
+
+// SubDir 返回缓存目录下的子目录, 未开启缓存时返回空字符串
+func SubDir(name string) string {
+	if !Enabled() {
+		return ""
+	}
+	return filepath.Join(*Dir, name)
+}
+
+// Key 将各部分拼成缓存键
+func Key(parts ...string) string {
+	h := sha256.New()
+	io.WriteString(h, version)
+	for _, p := range parts {
+		// 用\x00分隔, 避免不同切分拼出相同的内容
+		io.WriteString(h, "\x00"+p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashTree 计算root下所有go文件以及go.mod、go.sum、go.work的内容哈希, 跳过隐藏目录
+func HashTree(root string) (string, error) {
+	files := make([]string, 0)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, ".go") || name == "go.mod" || name == "go.sum" || name == "go.work" || name == "go.work.sum" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, file := range files {
+		rel, _ := filepath.Rel(root, file)
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, filepath.ToSlash(rel)+"\x00")
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func path(key string) string {
+	return filepath.Join(*Dir, "data", key[:2], key+".json")
+}
+
+// Get 读取key对应的缓存到v中, 未开启缓存、未命中或内容损坏时返回false
+func Get(key string, v interface{}) bool {
+	if !Enabled() {
+		return false
+	}
+	data, err := os.ReadFile(path(key))
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Printf("[leo] WARN 缓存内容损坏, 忽略: %v, err: %v", path(key), err)
+		return false
+	}
+	return true
+}
+
+// Put 将v写入key对应的缓存, 先写临时文件再改名, 中途失败不会留下损坏的缓存
+func Put(key string, v interface{}) error {
+	if !Enabled() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	file := path(key)
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
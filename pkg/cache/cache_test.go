@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashTree(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, code string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/h\n")
+	write("a.go", "package h\n")
+	first, err := HashTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("README.md", "docs do not matter")
+	os.MkdirAll(filepath.Join(dir, ".git"), os.ModePerm)
+	write(".git/x.go", "package ignored\n")
+	if same, _ := HashTree(dir); same != first {
+		t.Errorf("non-Go files or hidden directories changed the hash")
+	}
+	write("a.go", "package h\n\nfunc A() {}\n")
+	if changed, _ := HashTree(dir); changed == first {
+		t.Errorf("editing a Go file did not change the hash")
+	}
+}
+
+func TestGetPut(t *testing.T) {
+	defer func(dir string) { *Dir = dir }(*Dir)
+	*Dir = ""
+	if err := Put(Key("a"), 1); err != nil || Get(Key("a"), new(int)) {
+		t.Fatalf("disabled cache should neither store nor hit")
+	}
+	*Dir = t.TempDir()
+	want := map[string][]int{"x": {1, 2}}
+	if err := Put(Key("a", "b"), want); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]int)
+	if !Get(Key("a", "b"), &got) || len(got["x"]) != 2 {
+		t.Fatalf("cache miss or wrong value: %v", got)
+	}
+	if Get(Key("ab"), &got) {
+		t.Fatalf("keys with different parts must not collide")
+	}
+}
@@ -6,8 +6,13 @@ import (
 	"go/ast"
 	"go/token"
 	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
 )
 
+// CollectorPath 插桩代码调用的收集调用栈的包
+const CollectorPath = "github.com/dataznGao/leo"
+
 // 生成插桩的代码 leo.SendStack()
 func generateCollect(num int) ast.Stmt {
 	var (
@@ -30,18 +35,18 @@ func generateCollect(num int) ast.Stmt {
 	return stmt
 }
 
-func StartCollect(file *ast.File, num int) []byte {
+func StartCollect(file *ast.File, fset *token.FileSet, num int) []byte {
 	// 设置log
 	funs := _ast.GetFuns(file)
-	stmt := generateCollect(num)
 	// 获取匿名函数map
 	anonyFuncMap := _ast.GetAnonyFuns(funs)
 	for _, fun := range funs {
 		// 每个函数都得插桩
 		// 进行注入，当有一个故障日志被成功注入时，就应该import log
 		// 函数粒度注入
-		if setCollectInFun(fun, stmt) {
-			setImportLeo(file)
+		// 每个函数单独生成插桩语句, 打印时各自放在所在函数的位置上
+		if setCollectInFun(fun, generateCollect(num)) {
+			setImportLeo(fset, file)
 		}
 	}
 
@@ -55,12 +60,12 @@ func StartCollect(file *ast.File, num int) []byte {
 			Name: &ast.Ident{Name: name},
 			Type: lit.Type,
 			Body: lit.Body,
-		}, stmt) {
-			setImportLeo(file)
+		}, generateCollect(num)) {
+			setImportLeo(fset, file)
 		}
 
 	}
-	return util.GetFileCode(file)
+	return util.GetFileCode(fset, file)
 }
 
 func setCollectInFun(fun *ast.FuncDecl, stmt ast.Stmt) bool {
@@ -104,7 +109,12 @@ func (v *collectVis) Visit(node ast.Node) ast.Visitor {
 	return v
 }
 
-func setImportLeo(file *ast.File) {
+// setImportLeo 导入leo, 有fset时由astutil按位置放进已有的import声明
+func setImportLeo(fset *token.FileSet, file *ast.File) {
+	if fset != nil {
+		astutil.AddImport(fset, file, CollectorPath)
+		return
+	}
 	logPath := new(ast.BasicLit)
 	logPath.Value = "\"github.com/dataznGao/leo\""
 	logPath.Kind = token.STRING
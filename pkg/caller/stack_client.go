@@ -15,11 +15,12 @@ func SendStack(num int) {
 		panic(err.Error())
 	}
 	//请求值
-	var traceOutput = make([]uintptr, 10)
+	var traceOutput = make([]uintptr, maxStackDepth)
 	callDepth := runtime.Callers(0, traceOutput)
 	traceOutput = traceOutput[:callDepth]
 
 	stack := traceToCallStack(traceOutput)
+	stack.Frames = traceToFrames(traceOutput)
 	req := new(SendStackReq)
 	req.Chain = stack
 	req.Num = num
@@ -34,7 +35,30 @@ func SendStack(num int) {
 
 }
 
+const (
+	// edgeStackDepth 拆成调用边的栈深度
+	edgeStackDepth = 10
+	// maxStackDepth 作为完整调用链记录的栈深度
+	maxStackDepth = 64
+)
+
+// traceToFrames 按从外到内的顺序返回栈上的函数, 去掉runtime的函数以及runtime.Callers、SendStack本身
+func traceToFrames(trace []uintptr) []string {
+	frames := make([]string, 0, len(trace))
+	for i := len(trace) - 1; i >= 2; i-- {
+		name := runtime.FuncForPC(trace[i]).Name()
+		if strings.HasPrefix(name, "runtime.") {
+			continue
+		}
+		frames = append(frames, name)
+	}
+	return frames
+}
+
 func traceToCallStack(trace []uintptr) *CallChain {
+	if len(trace) > edgeStackDepth {
+		trace = trace[:edgeStackDepth]
+	}
 	stack := NewCallStack()
 	pre := ""
 	first := true
@@ -1,6 +1,10 @@
 package caller
 
-import "testing"
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
 
 //go:noinline
 
@@ -21,3 +25,30 @@ func level3() {
 	SendStack(0)
 	level2()
 }
+
+// collectFrames 与SendStack一样从runtime.Callers(0, ...)取栈
+//
+//go:noinline
+func collectFrames() []string {
+	trace := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(0, trace)
+	return traceToFrames(trace[:n])
+}
+
+//go:noinline
+func instrumented() []string {
+	return collectFrames()
+}
+
+func TestTraceToFrames(t *testing.T) {
+	frames := instrumented()
+	if len(frames) < 2 {
+		t.Fatalf("got %v", frames)
+	}
+	if leaf := frames[len(frames)-1]; !strings.HasSuffix(leaf, ".instrumented") {
+		t.Errorf("the function that sent the stack should be the innermost frame, got %v", frames)
+	}
+	if caller := frames[len(frames)-2]; !strings.HasSuffix(caller, ".TestTraceToFrames") {
+		t.Errorf("got %v", frames)
+	}
+}
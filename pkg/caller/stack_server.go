@@ -20,6 +20,8 @@ type SendStackReq struct {
 // CallChain 调用链
 type CallChain struct {
 	Data map[string]string //记录函数调用关系
+	// Frames 完整的调用链, 从外到内
+	Frames []string
 }
 
 func NewCallStack() *CallChain {
@@ -27,7 +29,11 @@ func NewCallStack() *CallChain {
 }
 
 func (mu *StackUtil) SendStack(req *SendStackReq, resq *bool) error {
+	constant.CallGraphMu.Lock()
+	defer constant.CallGraphMu.Unlock()
 	constant.CallGraph[req.Num] = add(constant.CallGraph[req.Num], req.Chain.Data)
+	constant.CallCount[req.Num] = count(constant.CallCount[req.Num], req.Chain.Frames)
+	constant.CallStacks[req.Num] = addStack(constant.CallStacks[req.Num], req.Chain.Frames)
 	*resq = true
 	return nil
 }
@@ -51,6 +57,40 @@ func add(mother map[string]map[string]string, son map[string]string) map[string]
 	return mother
 }
 
+// count 累加调用边被观测到的次数。插桩的函数在入口处上报调用栈, 每次上报只累加最内层的一条边,
+// 即上报的函数被调用的那条边, 外层的边在外层函数自己上报时计数
+func count(mother map[string]map[string]int, frames []string) map[string]map[string]int {
+	if mother == nil {
+		mother = make(map[string]map[string]int)
+	}
+	if len(frames) < 2 {
+		return mother
+	}
+	k := format(frames[len(frames)-2])
+	v := format(frames[len(frames)-1])
+	if _, ok := mother[k]; !ok {
+		mother[k] = make(map[string]int)
+	}
+	mother[k][v]++
+	return mother
+}
+
+// addStack 累加每条完整调用链被观测到的次数, 调用链中的函数用换行连接
+func addStack(mother map[string]int, frames []string) map[string]int {
+	if mother == nil {
+		mother = make(map[string]int)
+	}
+	if len(frames) == 0 {
+		return mother
+	}
+	formatted := make([]string, 0, len(frames))
+	for _, f := range frames {
+		formatted = append(formatted, format(f))
+	}
+	mother[strings.Join(formatted, "\n")]++
+	return mother
+}
+
 func format(bf string) string {
 	split := strings.Split(bf, ".")
 	// 从 xx.(*xx) -> (*xx.xx)
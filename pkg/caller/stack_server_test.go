@@ -5,3 +5,28 @@ import "testing"
 func TestStartServe(t *testing.T) {
 	StartServe()
 }
+
+func TestAddStack(t *testing.T) {
+	frames := []string{"example.com/s.A", "example.com/s.(*T).B"}
+	stacks := addStack(nil, frames)
+	stacks = addStack(stacks, frames)
+	stacks = addStack(stacks, nil)
+	if len(stacks) != 1 {
+		t.Fatalf("got %v stacks, want 1", stacks)
+	}
+	if n := stacks["example.com/s.A\n(*example.com/s.T).B"]; n != 2 {
+		t.Errorf("got %v, want the stack counted twice", stacks)
+	}
+}
+
+func TestCount(t *testing.T) {
+	counts := count(nil, []string{"example.com/s.A", "example.com/s.B", "example.com/s.C"})
+	counts = count(counts, []string{"example.com/s.A", "example.com/s.B"})
+	counts = count(counts, []string{"example.com/s.A"})
+	if n := counts["example.com/s.B"]["example.com/s.C"]; n != 1 {
+		t.Errorf("B->C reported once, got %v", counts)
+	}
+	if n := counts["example.com/s.A"]["example.com/s.B"]; n != 1 {
+		t.Errorf("A->B should be counted only when B reports, got %v", counts)
+	}
+}
@@ -3,20 +3,28 @@ package callgraph
 import (
 	"errors"
 	"fmt"
+	"github.com/dataznGao/leo/pkg/cache"
 	"github.com/dataznGao/leo/util"
 	"github.com/dataznGao/leo/util/task"
-	"go/build"
+	"go/types"
 	"golang.org/x/tools/go/callgraph"
 	"golang.org/x/tools/go/callgraph/cha"
 	"golang.org/x/tools/go/callgraph/rta"
 	"golang.org/x/tools/go/callgraph/static"
+	"golang.org/x/tools/go/callgraph/vta"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/pointer"
@@ -31,8 +39,16 @@ const (
 	CallGraphTypeCha                   = "cha"
 	CallGraphTypeRta                   = "rta"
 	CallGraphTypePointer               = "pointer"
+	CallGraphTypeVta                   = "vta"
+	CallGraphTypeAuto                  = "auto"
 )
 
+// errBudgetExceeded 调用图算法超出时间或内存预算
+var errBudgetExceeded = errors.New("call graph budget exceeded")
+
+// runningBuilds 在预算下运行、还没有结束的build数量, 包括超出预算后被放弃的
+var runningBuilds int32
+
 // ==[ type def/func: analysis   ]===============================================
 type renderOpts struct {
 	cacheDir string
@@ -62,14 +78,132 @@ func mainPackages(pkgs []*ssa.Package) ([]*ssa.Package, error) {
 	return mains, nil
 }
 
+// testMainPackages returns the test main packages synthesized by packages.Load
+// when Tests is set, i.e. the "p.test" package of every package that has tests.
+func testMainPackages(prog *ssa.Program, initial []*packages.Package) []*ssa.Package {
+	var mains []*ssa.Package
+	for _, p := range initial {
+		if p.Name != "main" || !strings.HasSuffix(p.ID, ".test") {
+			continue
+		}
+		if pkg := prog.Package(p.Types); pkg != nil && pkg.Func("main") != nil {
+			mains = append(mains, pkg)
+		}
+	}
+	return mains
+}
+
+// testPackages returns the external test packages ("p_test [p.test]") and the
+// test main packages ("p.test") synthesized by packages.Load, mapped to the
+// package p they test.
+func testPackages(initial []*packages.Package) map[string]string {
+	res := make(map[string]string)
+	for _, p := range initial {
+		if i := strings.Index(p.ID, " ["); i >= 0 && strings.HasSuffix(p.ID, ".test]") {
+			forTest := strings.TrimSuffix(p.ID[i+2:len(p.ID)-1], ".test")
+			if p.PkgPath != forTest {
+				res[p.PkgPath] = forTest
+			}
+			continue
+		}
+		if p.Name == "main" && strings.HasSuffix(p.ID, ".test") {
+			res[p.PkgPath] = strings.TrimSuffix(p.ID, ".test")
+		}
+	}
+	return res
+}
+
+// testFunctions returns the TestXxx, BenchmarkXxx, ExampleXxx and FuzzXxx
+// functions of the test packages, plus their init functions.
+func testFunctions(prog *ssa.Program, initial []*packages.Package) []*ssa.Function {
+	var roots []*ssa.Function
+	for _, p := range initial {
+		hasTest := false
+		for _, f := range p.GoFiles {
+			if strings.HasSuffix(f, "_test.go") {
+				hasTest = true
+				break
+			}
+		}
+		pkg := prog.Package(p.Types)
+		if !hasTest || pkg == nil {
+			continue
+		}
+		if init := pkg.Func("init"); init != nil {
+			roots = append(roots, init)
+		}
+		for _, member := range pkg.Members {
+			if fn, ok := member.(*ssa.Function); ok && isTestFunc(fn) {
+				roots = append(roots, fn)
+			}
+		}
+	}
+	return roots
+}
+
+// isTestName reports whether name looks like a test (or benchmark, according to prefix):
+// the prefix must not be followed by a lower-case letter.
+func isTestName(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// testParams Test、Benchmark、Fuzz函数唯一的参数类型testing.T、testing.B、testing.F
+var testParams = map[string]string{"Test": "T", "Benchmark": "B", "Fuzz": "F"}
+
+// isTestFunc 按go test的规则判断fn是否是测试函数: 包级函数, 名字符合isTestName;
+// TestXxx、BenchmarkXxx、FuzzXxx只有一个*testing.T、*testing.B、*testing.F参数, ExampleXxx没有参数。都没有返回值
+func isTestFunc(fn *ssa.Function) bool {
+	sig := fn.Signature
+	if fn.Parent() != nil || sig.Recv() != nil || sig.Results().Len() != 0 {
+		return false
+	}
+	if isTestName(fn.Name(), "Example") {
+		return sig.Params().Len() == 0
+	}
+	for prefix, param := range testParams {
+		if isTestName(fn.Name(), prefix) {
+			return sig.Params().Len() == 1 && isTestingPtr(sig.Params().At(0).Type(), param)
+		}
+	}
+	return false
+}
+
+// isTestingPtr t是否是*testing.<name>
+func isTestingPtr(t types.Type, name string) bool {
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "testing" && named.Obj().Name() == name
+}
+
 // ==[ type def/func: analysis   ]===============================================
 type analysis struct {
-	opts        *renderOpts
-	prog        *ssa.Program
-	pkgs        []*ssa.Package
-	mainPkg     *ssa.Package
-	callgraph   *callgraph.Graph
-	packageName string
+	opts      *renderOpts
+	initial   []*packages.Package
+	tests     bool
+	prog      *ssa.Program
+	pkgs      []*ssa.Package
+	mainPkg   *ssa.Package
+	callgraph *callgraph.Graph
+	// firstParty 导入路径是否属于项目自身的包
+	firstParty func(path string) bool
+	// ws 项目的工作区, 不为nil时加载包后向其登记外部测试包和测试main包
+	ws *util.Workspace
+	// env 加载包时追加的环境变量, 如多module时的GOWORK
+	env []string
+	// config 加载包时使用的构建配置, 为nil时使用当前环境和-tags
+	config *BuildConfig
+	// skipped -tolerant模式下因为有错误而跳过的包
+	skipped []*SkippedPackage
 }
 
 var Analysis *analysis
@@ -82,10 +216,14 @@ func (a *analysis) DoAnalysis(
 	args []string,
 ) error {
 	cfg := &packages.Config{
-		Mode:       packages.LoadAllSyntax,
-		Tests:      tests,
-		Dir:        dir,
-		BuildFlags: build.Default.BuildTags,
+		Mode:  packages.LoadAllSyntax,
+		Tests: tests,
+		Dir:   dir,
+	}
+	buildFlags, configEnv := a.config.LoadFlags()
+	cfg.BuildFlags = buildFlags
+	if env := append(append([]string(nil), a.env...), configEnv...); len(env) > 0 {
+		cfg.Env = append(os.Environ(), env...)
 	}
 	log.Printf("[leo] INFO 开始加载包, 目录为: %v", dir)
 	initial, err := packages.Load(cfg, args...)
@@ -94,13 +232,41 @@ func (a *analysis) DoAnalysis(
 	}
 
 	if packages.PrintErrors(initial) > 0 {
-		return fmt.Errorf("packages contain errors")
+		if !*tolerantFlag {
+			return fmt.Errorf("packages contain errors")
+		}
+		initial, a.skipped = dropBroken(initial)
+		for _, s := range a.skipped {
+			log.Printf("[leo] WARN 跳过有错误的包: %v", s.Path)
+		}
+		if len(initial) == 0 {
+			return fmt.Errorf("all packages contain errors")
+		}
 	}
 	log.Printf("[leo] INFO 加载包成功")
+	if a.ws != nil {
+		for path, forTest := range testPackages(initial) {
+			a.ws.AddTestPackage(path, forTest)
+		}
+	}
+	a.initial = initial
+	a.tests = tests
 	// ssautil用来读取go源码并解析成相应的SSA中间代码
 	a.prog, a.pkgs = ssautil.AllPackages(initial, 0)
 	a.prog.Build()
 	log.Printf("[leo] INFO 开始生成ssa中间码")
+	graph, mainPkg, err := a.buildCallGraph(algo)
+	if err != nil {
+		return err
+	}
+
+	a.mainPkg = mainPkg
+	a.callgraph = graph
+	return nil
+}
+
+// buildCallGraph 使用指定的算法在已构建的ssa程序上生成调用图
+func (a *analysis) buildCallGraph(algo CallGraphType) (*callgraph.Graph, *ssa.Package, error) {
 	var graph *callgraph.Graph
 	var mainPkg *ssa.Package
 
@@ -109,21 +275,27 @@ func (a *analysis) DoAnalysis(
 		graph = static.CallGraph(a.prog)
 	case CallGraphTypeCha:
 		graph = cha.CallGraph(a.prog)
+	case CallGraphTypeVta:
+		// vta以cha的结果作为初始调用图进行细化
+		log.Printf("[leo] INFO vta开始分析ssa中间码")
+		graph = vta.CallGraph(ssautil.AllFunctions(a.prog), cha.CallGraph(a.prog))
+		log.Printf("[leo] INFO vta分析ssa中间码完毕")
 	case CallGraphTypeRta:
-		mains, err := mainPackages(a.prog.AllPackages())
+		roots, mains, err := a.roots()
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		var roots []*ssa.Function
-		mainPkg = mains[0]
-		for _, main := range mains {
-			roots = append(roots, main.Func("main"))
+		if len(mains) > 0 {
+			mainPkg = mains[0]
 		}
 		graph = rta.Analyze(roots, true).CallGraph
 	case CallGraphTypePointer:
-		mains, err := mainPackages(a.prog.AllPackages())
+		_, mains, err := a.roots()
 		if err != nil {
-			return err
+			return nil, nil, err
+		}
+		if len(mains) == 0 {
+			return nil, nil, fmt.Errorf("pointer analysis needs main packages, found only test functions")
 		}
 		mainPkg = mains[0]
 		config := &pointer.Config{
@@ -133,22 +305,144 @@ func (a *analysis) DoAnalysis(
 		log.Printf("[leo] INFO pointer开始分析ssa中间码")
 		ptares, err := pointer.Analyze(config)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		graph = ptares.CallGraph
 		log.Printf("[leo] INFO pointer分析ssa中间码完毕")
+	case CallGraphTypeAuto:
+		return a.autoCallGraph()
 	default:
-		return fmt.Errorf("invalid call graph type: %s", a.opts.algo)
+		return nil, nil, fmt.Errorf("invalid call graph type: %s", algo)
 	}
+	return graph, mainPkg, nil
+}
 
-	a.mainPkg = mainPkg
-	a.callgraph = graph
-	return nil
+// roots 返回rta/pointer的分析入口。分析测试时以packages.Load合成的测试main包为入口,
+// 没有测试main包时退化为TestXxx等测试函数; 否则使用普通的main包
+func (a *analysis) roots() ([]*ssa.Function, []*ssa.Package, error) {
+	var mains []*ssa.Package
+	if a.tests {
+		mains = testMainPackages(a.prog, a.initial)
+		if len(mains) == 0 {
+			roots := testFunctions(a.prog, a.initial)
+			if len(roots) == 0 {
+				return nil, nil, fmt.Errorf("no test main packages or test functions")
+			}
+			log.Printf("[leo] INFO 未找到测试main包, 以%v个测试函数为入口", len(roots))
+			return roots, nil, nil
+		}
+		log.Printf("[leo] INFO 以%v个测试main包为入口", len(mains))
+	} else {
+		var err error
+		mains, err = mainPackages(a.prog.AllPackages())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	// init也是入口, 测试main包的测试表就是在init中初始化的
+	var roots []*ssa.Function
+	for _, main := range mains {
+		roots = append(roots, main.Func("init"), main.Func("main"))
+	}
+	return roots, mains, nil
+}
+
+// autoCallGraph 根据程序规模选择算法, 小程序用pointer, 中等程序用vta, 大程序用cha,
+// 某个算法超出时间或内存预算(或无法运行)时依次降级到更便宜的算法
+func (a *analysis) autoCallGraph() (*callgraph.Graph, *ssa.Package, error) {
+	funcs := len(ssautil.AllFunctions(a.prog))
+	candidates := make([]CallGraphType, 0)
+	if funcs <= *autoPointerMax {
+		candidates = append(candidates, CallGraphTypePointer)
+	}
+	if funcs <= *autoVtaMax {
+		candidates = append(candidates, CallGraphTypeVta)
+	}
+	// 之前被放弃的build还在运行时不再启动新的, 后台最多只留下一个
+	if n := atomic.LoadInt32(&runningBuilds); n > 0 && len(candidates) > 0 {
+		log.Printf("[leo] WARN 还有%v个超出预算的算法在后台运行, 不再尝试%v", n, candidates)
+		candidates = nil
+	}
+	log.Printf("[leo] INFO auto模式, 函数数量: %v, 候选算法: %v", funcs, candidates)
+	for _, algo := range candidates {
+		algo := algo
+		graph, mainPkg, err := withBudget(*algoTimeout, *algoMemLimit, func() (*callgraph.Graph, *ssa.Package, error) {
+			return a.buildCallGraph(algo)
+		})
+		if err == nil {
+			log.Printf("[leo] INFO auto模式选用算法: %v", algo)
+			return graph, mainPkg, nil
+		}
+		log.Printf("[leo] WARN auto模式下%v算法失败, 降级处理, err: %v", algo, err)
+		// 超出预算的算法无法取消, 仍在后台运行, 会占用下一个算法的时间和内存预算,
+		// 因此同一时间只让一个算法在预算下运行, 超出预算后直接用cha
+		if errors.Is(err, errBudgetExceeded) {
+			break
+		}
+	}
+	// cha是最后的兜底, 不受预算限制
+	log.Printf("[leo] INFO auto模式选用算法: %v", CallGraphTypeCha)
+	return a.buildCallGraph(CallGraphTypeCha)
+}
+
+// withBudget 在时间和内存(MB)预算内执行build, 预算为0表示不限制, 内存按本次执行开始以来堆的增长计算。
+// pointer和vta都无法中途取消, 超出预算时只是放弃等待其结果, 被放弃的build会继续运行并分配内存直到结束,
+// 运行中的数量记在runningBuilds中。build中的panic作为错误返回
+func withBudget(
+	timeout time.Duration,
+	memLimit uint64,
+	build func() (*callgraph.Graph, *ssa.Package, error),
+) (*callgraph.Graph, *ssa.Package, error) {
+	type result struct {
+		graph   *callgraph.Graph
+		mainPkg *ssa.Package
+		err     error
+	}
+	done := make(chan result, 1)
+	atomic.AddInt32(&runningBuilds, 1)
+	go func() {
+		defer atomic.AddInt32(&runningBuilds, -1)
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: fmt.Errorf("panic: %v", r)}
+			}
+		}()
+		graph, mainPkg, err := build()
+		done <- result{graph, mainPkg, err}
+	}()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	base := ms.HeapAlloc
+	for {
+		select {
+		case r := <-done:
+			return r.graph, r.mainPkg, r.err
+		case <-deadline:
+			return nil, nil, fmt.Errorf("%w: time > %v", errBudgetExceeded, timeout)
+		case <-ticker.C:
+			if memLimit == 0 {
+				continue
+			}
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > base && ms.HeapAlloc-base > memLimit<<20 {
+				return nil, nil, fmt.Errorf("%w: heap +%vMB > %vMB", errBudgetExceeded, (ms.HeapAlloc-base)>>20, memLimit)
+			}
+		}
+	}
 }
 
 func (a *analysis) OptsSetup() {
 	a.opts = &renderOpts{
-		cacheDir: *cacheDir,
+		cacheDir: cache.SubDir("img"),
 		focus:    *focusFlag,
 		group:    []string{*groupFlag},
 		ignore:   []string{*ignoreFlag},
@@ -241,75 +535,70 @@ type SyncVertxList struct {
 	mu     sync.Mutex
 }
 
-// Render basically do printOutput() with previously checking
-// focus option and respective package
+// Render 对整个调用图只遍历一遍, 按-renderWorkers分批并发过滤、转换调用边,
+// 只保留与项目包(firstParty)相关的调用边
 func (a *analysis) Render() ([]*Vertx, error) {
-	var err error
-	vertxs := make([]*Vertx, 0)
-	smap := sync.Map{}
-	// 分3个协程并发处理
-	num := 3
-	group := task.NewGroup(num)
-	pack := a.prog.AllPackages()
-	myPack := make([]*ssa.Package, 0)
-	for _, p := range pack {
-		if strings.Contains(p.Pkg.Path(), a.packageName) {
-			myPack = append(myPack, p)
-		}
+	a.callgraph.DeleteSyntheticNodes()
+	filter := newEdgeFilter(a.callgraph, a.firstParty, a.opts)
+	edges := make([]*callgraph.Edge, 0)
+	if err := callgraph.GraphVisitEdges(a.callgraph, func(edge *callgraph.Edge) error {
+		edges = append(edges, edge)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("processing failed: %v", err)
+	}
+
+	num := *renderWorkers
+	if num < 1 {
+		num = 1
 	}
-	n := len(myPack)
-	batchSize := n / num
+	n := len(edges)
+	batchSize := (n + num - 1) / num
 	log.Printf("[leo] INFO 多协程进行渲染, 协程数量: %v, 数据总量: %v, 数据batch size: %v",
 		num, n, batchSize)
-	outputMu := sync.Mutex{}
+	results := make([][]*Vertx, num)
+	group := task.NewGroup(num)
 	for i := 0; i < num; i++ {
-		// 单协程计算任务
-		batch := make([]*ssa.Package, 0)
-		if i == num-1 {
-			batch = myPack[i*batchSize : n]
-		} else {
-			batch = myPack[i*batchSize : (i+1)*batchSize]
-		}
-		log.Printf("[leo] INFO go_routine_id: %v, 处理数量: %v", i+1, len(batch))
-		CalRender := func() {
-			for _, pkg := range batch {
-				dot, err := printOutput(
-					&outputMu,
-					a.prog,
-					a.mainPkg,
-					a.callgraph,
-					pkg.Pkg,
-					a.opts.limit,
-					a.opts.ignore,
-					a.opts.include,
-					a.opts.group,
-					a.opts.nostd,
-					a.opts.nointer,
-				)
-				if err != nil {
-					log.Fatalf("pkg parse err, err: %v", err)
-				}
-				for s := range dot {
-					smap.Store(s.ToString(), s)
+		i := i
+		lo, hi := i*batchSize, (i+1)*batchSize
+		if lo > n {
+			lo = n
+		}
+		if hi > n {
+			hi = n
+		}
+		group.Add(func() {
+			for _, edge := range edges[lo:hi] {
+				if filter.keep(edge) {
+					results[i] = append(results[i], newVertx(a.prog, edge))
 				}
 			}
-		}
-		group.Add(CalRender)
+		})
 	}
 	group.Start()
 	group.Wait()
-	if err != nil {
-		return nil, fmt.Errorf("processing failed: %v", err)
+
+	seen := make(map[string]*Vertx)
+	for _, batch := range results {
+		for _, v := range batch {
+			seen[v.ToString()] = v
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	vertxs := make([]*Vertx, 0, len(keys))
+	for _, k := range keys {
+		vertxs = append(vertxs, seen[k])
 	}
-	smap.Range(func(key, value interface{}) bool {
-		vertxs = append(vertxs, value.(*Vertx))
-		return true
-	})
 	log.Printf("[leo] INFO 共获取到%v条调用边", len(vertxs))
 	return vertxs, nil
 }
 
-func (a *analysis) FindCachedImg() string {
+// FindCachedImg 查找graph在当前focus下已缓存的图片
+func (a *analysis) FindCachedImg(graph string) string {
 	if a.opts.cacheDir == "" || a.opts.refresh {
 		return ""
 	}
@@ -319,7 +608,7 @@ func (a *analysis) FindCachedImg() string {
 		focus = "all"
 	}
 	focusFilePath := focus + "." + *outputFormat
-	absFilePath := filepath.Join(a.opts.cacheDir, focusFilePath)
+	absFilePath := filepath.Join(a.opts.cacheDir, graph, focusFilePath)
 
 	if exists, err := pathExists(absFilePath); err != nil || !exists {
 		log.Println("not cached img:", absFilePath)
@@ -330,7 +619,8 @@ func (a *analysis) FindCachedImg() string {
 	return absFilePath
 }
 
-func (a *analysis) CacheImg(img string) error {
+// CacheImg 缓存graph在当前focus下渲染出的图片
+func (a *analysis) CacheImg(graph, img string) error {
 	if a.opts.cacheDir == "" || img == "" {
 		return nil
 	}
@@ -339,7 +629,7 @@ func (a *analysis) CacheImg(img string) error {
 	if focus == "" {
 		focus = "all"
 	}
-	absCacheDirPrefix := filepath.Join(a.opts.cacheDir, focus)
+	absCacheDirPrefix := filepath.Join(a.opts.cacheDir, graph, focus)
 	absCacheDirPath := strings.TrimRightFunc(absCacheDirPrefix, func(r rune) bool {
 		return r != '\\' && r != '/'
 	})
@@ -393,124 +683,3 @@ func copyFile(src, dst string) (int64, error) {
 	nBytes, err := io.Copy(destination, source)
 	return nBytes, err
 }
-
-func Compare(a, b map[string]map[string]string, inputPath string) []*Diff {
-	packageName := util.GetPackageName(inputPath)
-	diff := make([]*Diff, 0)
-	for caller, m := range a {
-		for callee, descA := range m {
-			callerPath := String2Func(strings.Replace(caller, packageName, inputPath, 1))
-			calleePath := String2Func(strings.Replace(callee, packageName, inputPath, 1))
-			if calleeMapB, ok := b[caller]; ok {
-				if descB, ok := calleeMapB[callee]; ok {
-					if descB != descA {
-						// case1: 描述不同
-						diff = append(diff, &Diff{
-							NodeA: &Node{
-								Caller:      callerPath,
-								Callee:      calleePath,
-								Description: descA,
-							},
-							NodeB: &Node{
-								Caller:      callerPath,
-								Callee:      calleePath,
-								Description: descB,
-							},
-							Detail: &DescDiff,
-						})
-					}
-				} else {
-					// case2: B中不存在对应的callee
-					diff = append(diff, &Diff{
-						NodeA: &Node{
-							Caller:      callerPath,
-							Callee:      calleePath,
-							Description: descA,
-						},
-						Detail: &SideLackDiff,
-					})
-				}
-			} else {
-				// case3: B中不存在对应的caller
-				diff = append(diff, &Diff{
-					NodeA: &Node{
-						Caller:      callerPath,
-						Callee:      calleePath,
-						Description: descA,
-					},
-					Detail: &SideLackDiff,
-				})
-			}
-		}
-	}
-
-	for caller, m := range b {
-		for callee, descB := range m {
-			callerPath := String2Func(strings.Replace(caller, util.GetPackageName(inputPath), inputPath, 1))
-			calleePath := String2Func(strings.Replace(callee, util.GetPackageName(inputPath), inputPath, 1))
-			if calleeMapA, ok := a[caller]; ok {
-				if descA, ok := calleeMapA[callee]; ok {
-					if descB != descA {
-						// case1: 描述不同
-						diff = append(diff, &Diff{
-							NodeA: &Node{
-								Caller:      callerPath,
-								Callee:      calleePath,
-								Description: descA,
-							},
-							NodeB: &Node{
-								Caller:      callerPath,
-								Callee:      calleePath,
-								Description: descB,
-							},
-							Detail: &DescDiff,
-						})
-					}
-				} else {
-					// case2: A中不存在对应的callee
-					diff = append(diff, &Diff{
-						NodeB: &Node{
-							Caller:      callerPath,
-							Callee:      calleePath,
-							Description: descB,
-						},
-						Detail: &SideLackDiff,
-					})
-				}
-			} else {
-				// case3: A中不存在对应的caller
-				diff = append(diff, &Diff{
-					NodeB: &Node{
-						Caller:      callerPath,
-						Callee:      calleePath,
-						Description: descB,
-					},
-					Detail: &SideLackDiff,
-				})
-			}
-		}
-	}
-	return DedupDiff(diff)
-}
-
-func DedupDiff(diff []*Diff) []*Diff {
-	res := make([]*Diff, 0)
-	deMap := make(map[string]*Diff, 0)
-	for _, di := range diff {
-		deMap[di.ToString()] = di
-	}
-	sortMap := make(map[string][]*Diff, 0)
-	for _, d := range deMap {
-		if _, ok := sortMap[d.NodeA.Caller.ToString()]; !ok {
-			sortMap[d.NodeA.Caller.ToString()] = make([]*Diff, 0)
-		}
-		sortMap[d.NodeA.Caller.ToString()] = append(sortMap[d.NodeA.Caller.ToString()], d)
-
-	}
-	for _, diffs := range sortMap {
-		for _, d := range diffs {
-			res = append(res, d)
-		}
-	}
-	return res
-}
@@ -0,0 +1,327 @@
+package callgraph
+
+import (
+	"errors"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+)
+
+// skipIfLoaderBroken golang.org/x/tools v0.4.0在go1.22+上拿不到types.StdSizes, packages.Load会panic
+func skipIfLoaderBroken(t *testing.T) {
+	if _, ok := types.SizesFor("gc", runtime.GOARCH).(*types.StdSizes); !ok {
+		t.Skip("golang.org/x/tools v0.4.0 cannot type-check packages with this Go toolchain")
+	}
+}
+
+// writeModule 在临时目录中生成一个测试用的go module
+func writeModule(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, code := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(code), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var demoModule = map[string]string{
+	"go.mod": "module example.com/demo\n\ngo 1.18\n",
+	"main.go": `package main
+
+type Shape interface{ Area() int }
+
+type Square struct{ n int }
+
+func (s *Square) Area() int { return s.n * s.n }
+
+func total(shapes []Shape) int {
+	sum := 0
+	for _, s := range shapes {
+		sum += s.Area()
+	}
+	return sum
+}
+
+func main() {
+	println(total([]Shape{&Square{2}}))
+}
+`,
+}
+
+func hasEdge(a *analysis, caller, callee string) bool {
+	for fn, node := range a.callgraph.Nodes {
+		if fn == nil || fn.String() != caller {
+			continue
+		}
+		for _, out := range node.Out {
+			if out.Callee.Func.String() == callee {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestDoAnalysisVta(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, demoModule)
+	anal := new(analysis)
+	if err := anal.DoAnalysis(CallGraphTypeVta, dir, false, []string{"./..."}); err != nil {
+		t.Fatal(err)
+	}
+	if !hasEdge(anal, "example.com/demo.total", "(*example.com/demo.Square).Area") {
+		t.Fatalf("vta missed the interface call total -> (*Square).Area")
+	}
+}
+
+func TestDoAnalysisAuto(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, demoModule)
+	anal := new(analysis)
+	if err := anal.DoAnalysis(CallGraphTypeAuto, dir, false, []string{"./..."}); err != nil {
+		t.Fatal(err)
+	}
+	if !hasEdge(anal, "example.com/demo.main", "example.com/demo.total") {
+		t.Fatalf("auto missed the static call main -> total")
+	}
+}
+
+func TestWithBudgetMeasuresGrowth(t *testing.T) {
+	// 执行前已经占用的堆不计入预算
+	held := make([]byte, 64<<20)
+	for i := range held {
+		held[i] = 1
+	}
+	_, _, err := withBudget(0, 16, func() (*callgraph.Graph, *ssa.Package, error) {
+		time.Sleep(1500 * time.Millisecond)
+		return nil, nil, nil
+	})
+	runtime.KeepAlive(held)
+	if err != nil {
+		t.Fatalf("heap held before the build should not count: %v", err)
+	}
+	runtime.GC()
+	_, _, err = withBudget(0, 16, func() (*callgraph.Graph, *ssa.Package, error) {
+		grown := make([]byte, 64<<20)
+		for i := range grown {
+			grown[i] = 1
+		}
+		time.Sleep(1500 * time.Millisecond)
+		runtime.KeepAlive(grown)
+		return nil, nil, nil
+	})
+	if !errors.Is(err, errBudgetExceeded) {
+		t.Fatalf("heap grown by the build should exceed the budget, got %v", err)
+	}
+}
+
+var libModule = map[string]string{
+	"go.mod": "module example.com/lib\n\ngo 1.18\n",
+	"lib.go": `package lib
+
+func Used() int { return helper() }
+
+func helper() int { return 1 }
+
+func Unused() int { return 2 }
+`,
+	"lib_test.go": `package lib
+
+import "testing"
+
+func TestUsed(t *testing.T) {
+	if Used() != 1 {
+		t.Fatal("bad")
+	}
+}
+`,
+}
+
+func TestDoAnalysisTestRooted(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, libModule)
+	for _, algo := range []CallGraphType{CallGraphTypeRta, CallGraphTypePointer} {
+		anal := new(analysis)
+		if err := anal.DoAnalysis(algo, dir, true, []string{"./..."}); err != nil {
+			t.Fatalf("%v: %v", algo, err)
+		}
+		if !hasEdge(anal, "example.com/lib.Used", "example.com/lib.helper") {
+			t.Fatalf("%v missed the edge reachable from TestUsed", algo)
+		}
+		for fn := range anal.callgraph.Nodes {
+			if fn != nil && fn.String() == "example.com/lib.Unused" {
+				t.Fatalf("%v reached Unused, which no test calls", algo)
+			}
+		}
+	}
+}
+
+var siteModule = map[string]string{
+	"go.mod": "module example.com/site\n\ngo 1.18\n",
+	"site.go": `package site
+
+type Runner interface{ Run() }
+
+type job struct{}
+
+func (job) Run() {}
+
+func work() {}
+
+func Start(r Runner) {
+	defer work()
+	go work()
+	r.Run()
+	f := func() { work() }
+	f()
+}
+`,
+	"site_test.go": `package site
+
+import "testing"
+
+func TestStart(t *testing.T) {
+	Start(job{})
+}
+`,
+}
+
+func TestDrawCallSites(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, siteModule)
+	vertxs, err := Draw(true, "example.com/site", dir, "example.com/site", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[CallKind]bool)
+	for _, v := range vertxs {
+		if v.Caller != "example.com/site.Start" {
+			continue
+		}
+		if v.Site == nil || v.Site.Line == 0 || filepath.Base(v.Site.File) != "site.go" {
+			t.Fatalf("edge %v has no call site position", v.ToString())
+		}
+		kinds[v.Site.Kind] = true
+	}
+	for _, kind := range []CallKind{CallDefer, CallGo, CallInterface, CallClosure} {
+		if !kinds[kind] {
+			t.Errorf("missing %v call from Start, got %v", kind, kinds)
+		}
+	}
+}
+
+func TestCompareCarriesSites(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/cmp\n\ngo 1.18\n"})
+	raw := make(EdgeMap)
+	raw.Add("example.com/cmp.A", "example.com/cmp.B", "static function call",
+		&CallSite{File: "a.go", Line: 3, Column: 3, Kind: CallStatic})
+	diffs := Compare(raw, make(EdgeMap), dir, SourceStatic)
+	edges := make([]*Diff, 0)
+	for _, d := range diffs {
+		if d.Kind == EdgeRemovedDiff {
+			edges = append(edges, d)
+		}
+	}
+	if len(edges) != 1 || edges[0].NodeA == nil || len(edges[0].NodeA.Sites) != 1 {
+		t.Fatalf("want one removed edge with its call site, got %v", diffs)
+	}
+	if site := edges[0].NodeA.Sites[0]; site.File != filepath.Join(dir, "a.go") || site.Line != 3 {
+		t.Fatalf("call site not resolved against the input path: %v", site.ToString())
+	}
+}
+
+var callbackModule = map[string]string{
+	"go.mod": "module example.com/cb\n\ngo 1.18\n",
+	"main.go": `package main
+
+import "strings"
+
+func upper(r rune) rune { return r - 32 }
+
+func main() {
+	println(strings.Map(upper, "abc"))
+}
+`,
+}
+
+func TestRenderWorkers(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, callbackModule)
+	defer func(n int) { *renderWorkers = n }(*renderWorkers)
+	var want []string
+	for _, workers := range []int{1, 4} {
+		*renderWorkers = workers
+		anal := new(analysis)
+		if err := anal.DoAnalysis(CallGraphTypeCha, dir, false, []string{"./..."}); err != nil {
+			t.Fatal(err)
+		}
+		anal.OptsSetup()
+		anal.firstParty = func(path string) bool { return strings.HasPrefix(path, "example.com/cb") }
+		vertxs, err := anal.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(vertxs))
+		for _, v := range vertxs {
+			got = append(got, v.Caller+" -> "+v.Callee)
+		}
+		if want == nil {
+			want = got
+		} else if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("%d workers rendered\n%v\nwant\n%v", workers, got, want)
+		}
+	}
+	for _, edge := range []string{"example.com/cb.main -> strings.Map", "strings.Map -> example.com/cb.upper"} {
+		found := false
+		for _, got := range want {
+			found = found || got == edge
+		}
+		if !found {
+			t.Errorf("missing %s in %v", edge, want)
+		}
+	}
+}
+
+func TestWithBudgetRecovers(t *testing.T) {
+	_, _, err := withBudget(0, 0, func() (*callgraph.Graph, *ssa.Package, error) {
+		panic("boom")
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("a panic in the build should be returned as an error, got %v", err)
+	}
+	if errors.Is(err, errBudgetExceeded) {
+		t.Fatalf("a panic is not a budget overrun: %v", err)
+	}
+}
+
+func TestTestPackages(t *testing.T) {
+	initial := []*packages.Package{
+		{ID: "example.com/a", PkgPath: "example.com/a", Name: "a"},
+		{ID: "example.com/a [example.com/a.test]", PkgPath: "example.com/a", Name: "a"},
+		{ID: "example.com/a_test [example.com/a.test]", PkgPath: "example.com/a_test", Name: "a_test"},
+		{ID: "example.com/a.test", PkgPath: "example.com/a.test", Name: "main"},
+		// 名字以_test结尾的普通包
+		{ID: "example.com/load_test", PkgPath: "example.com/load_test", Name: "load_test"},
+	}
+	want := map[string]string{
+		"example.com/a_test": "example.com/a",
+		"example.com/a.test": "example.com/a",
+	}
+	if got := testPackages(initial); !reflect.DeepEqual(got, want) {
+		t.Fatalf("testPackages() = %v, want %v", got, want)
+	}
+}
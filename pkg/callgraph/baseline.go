@@ -0,0 +1,244 @@
+package callgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dataznGao/bingo/core/run-test"
+	"github.com/dataznGao/leo/constant"
+)
+
+// Baseline 未注入故障时把测试运行多次得到的动态调用图基线。
+// 调度、map遍历顺序等会让没有故障时的动态调用图也不完全相同, 这些抖动不应当算作故障带来的差异
+type Baseline struct {
+	Runs int `json:"runs"`
+	// Stable 每次运行都出现的边, Count为第一次运行中被观测到的次数
+	Stable EdgeMap `json:"stable"`
+	// Flaky 只在部分运行中出现的边, Count为出现的次数
+	Flaky EdgeMap `json:"flaky"`
+	// Unsteady 每次都出现但被观测到的次数不同的边, Count为各次运行中的最大次数
+	Unsteady EdgeMap `json:"unsteady"`
+	// FlakyPaths 调用上下文树中只在部分运行中出现的调用链, 键与Paths相同, 值为出现的次数
+	FlakyPaths map[string]int `json:"flakyPaths,omitempty"`
+}
+
+// DynamicBaseline 在插桩后、未注入故障的代码上把testPath中的测试运行runs次, 生成动态调用图基线
+func DynamicBaseline(inputPath, testPath string, num, runs int) (*Baseline, error) {
+	if runs < 2 {
+		return nil, fmt.Errorf("a baseline needs at least 2 runs, got %d", runs)
+	}
+	// 基线的运行不能混进num对应的动态调用图, 结束后恢复
+	defer restoreDynamic(num, snapshotDynamic(num))
+	graphs := make([]EdgeMap, 0, runs)
+	contexts := make([]*ContextTree, 0, runs)
+	for i := 0; i < runs; i++ {
+		g, ctx, err := runDynamic(inputPath, testPath, num)
+		if err != nil {
+			return nil, err
+		}
+		graphs = append(graphs, g)
+		contexts = append(contexts, ctx)
+	}
+	b := NewBaseline(graphs, contexts)
+	log.Printf("[leo] INFO 动态调用图基线: %v次运行, %v条稳定边, %v条抖动边, %v条次数不稳定的边, %v条抖动调用链",
+		runs, b.Stable.size(), b.Flaky.size(), b.Unsteady.size(), len(b.FlakyPaths))
+	return b, nil
+}
+
+// runDynamic 清空num对应的动态数据后运行一次测试, 只返回这次运行中被观测到的调用边(Count为这次运行中的次数)
+// 和调用上下文树
+func runDynamic(inputPath, testPath string, num int) (EdgeMap, *ContextTree, error) {
+	resetDynamic(num)
+	if _, err := run.Test(testPath, inputPath); err != nil {
+		return nil, nil, err
+	}
+	ctx := DynamicContext(num)
+	constant.CallGraphMu.Lock()
+	defer constant.CallGraphMu.Unlock()
+	return FromStringMap(constant.CallGraph[num], constant.CallCount[num]), ctx, nil
+}
+
+// dynamicData num对应的动态调用图、调用次数和调用链
+type dynamicData struct {
+	graph  map[string]map[string]string
+	counts map[string]map[string]int
+	stacks map[string]int
+}
+
+// snapshotDynamic 复制num对应的动态数据
+func snapshotDynamic(num int) *dynamicData {
+	constant.CallGraphMu.Lock()
+	defer constant.CallGraphMu.Unlock()
+	d := &dynamicData{
+		graph:  make(map[string]map[string]string, len(constant.CallGraph[num])),
+		counts: make(map[string]map[string]int, len(constant.CallCount[num])),
+		stacks: make(map[string]int, len(constant.CallStacks[num])),
+	}
+	for caller, m := range constant.CallGraph[num] {
+		d.graph[caller] = make(map[string]string, len(m))
+		for callee, desc := range m {
+			d.graph[caller][callee] = desc
+		}
+	}
+	for caller, m := range constant.CallCount[num] {
+		d.counts[caller] = make(map[string]int, len(m))
+		for callee, n := range m {
+			d.counts[caller][callee] = n
+		}
+	}
+	for stack, n := range constant.CallStacks[num] {
+		d.stacks[stack] = n
+	}
+	return d
+}
+
+// restoreDynamic 把num对应的动态数据恢复为快照d
+func restoreDynamic(num int, d *dynamicData) {
+	constant.CallGraphMu.Lock()
+	defer constant.CallGraphMu.Unlock()
+	constant.CallGraph[num] = d.graph
+	constant.CallCount[num] = d.counts
+	constant.CallStacks[num] = d.stacks
+}
+
+// NewBaseline 由多次运行的动态调用图和调用上下文树生成基线, contexts为nil时不记录抖动的调用链
+func NewBaseline(graphs []EdgeMap, contexts []*ContextTree) *Baseline {
+	b := &Baseline{
+		Runs:       len(graphs),
+		Stable:     make(EdgeMap),
+		Flaky:      make(EdgeMap),
+		Unsteady:   make(EdgeMap),
+		FlakyPaths: make(map[string]int),
+	}
+	seen := make(map[string]int)
+	for _, ctx := range contexts {
+		for key := range ctx.Paths() {
+			seen[key]++
+		}
+	}
+	for key, n := range seen {
+		if n < len(contexts) {
+			b.FlakyPaths[key] = n
+		}
+	}
+	union := make(EdgeMap)
+	for _, g := range graphs {
+		for caller, m := range g {
+			for callee, edge := range m {
+				union.Add(caller, callee, edge.Description, nil)
+			}
+		}
+	}
+	for caller, m := range union {
+		for callee, edge := range m {
+			if edge.Count < len(graphs) {
+				b.Flaky.Add(caller, callee, edge.Description, nil)
+				b.Flaky[caller][callee].Count = edge.Count
+				continue
+			}
+			first := graphs[0][caller][callee]
+			b.Stable.Add(caller, callee, first.Description, nil)
+			b.Stable[caller][callee].Count = first.Count
+			max, steady := first.Count, true
+			for _, g := range graphs[1:] {
+				n := g[caller][callee].Count
+				steady = steady && n == first.Count
+				if n > max {
+					max = n
+				}
+			}
+			if !steady {
+				b.Unsteady.Add(caller, callee, edge.Description, nil)
+				b.Unsteady[caller][callee].Count = max
+			}
+		}
+	}
+	return b
+}
+
+// size 调用边的条数
+func (m EdgeMap) size() int {
+	n := 0
+	for _, callees := range m {
+		n += len(callees)
+	}
+	return n
+}
+
+// FilterDiffs 去掉由抖动引起的动态差异: 涉及抖动边的边差异, 只经由抖动边出现的函数的节点差异,
+// 次数不稳定的边的次数差异, 以及基线中只在部分运行中出现的调用链的调用链差异。
+// 静态差异原样保留, 返回保留的差异和去掉的差异
+func (b *Baseline) FilterDiffs(diffs []*Diff, inputPath string) (kept, removed []*Diff) {
+	ws, err := loadWorkspace(inputPath)
+	if err != nil {
+		log.Printf("[leo] WARN 无法识别%v中的module: %v", inputPath, err)
+	}
+	keys := func(m EdgeMap) map[string]bool {
+		res := make(map[string]bool)
+		for caller, callees := range m {
+			for callee := range callees {
+				res[edgeKey(funcIn(ws, caller), funcIn(ws, callee))] = true
+			}
+		}
+		return res
+	}
+	flaky, unsteady := keys(b.Flaky), keys(b.Unsteady)
+	stableNodes := make(map[string]bool)
+	for fn := range b.Stable.nodes() {
+		stableNodes[funcIn(ws, fn).ToString()] = true
+	}
+	flakyNodes := make(map[string]bool)
+	for fn := range b.Flaky.nodes() {
+		if key := funcIn(ws, fn).ToString(); !stableNodes[key] {
+			flakyNodes[key] = true
+		}
+	}
+
+	kept, removed = make([]*Diff, 0, len(diffs)), make([]*Diff, 0)
+	for _, d := range diffs {
+		noise := false
+		if d.Source == SourceDynamic {
+			if len(d.Path) > 0 {
+				// 调用链差异按整条调用链判断, 最后一条边稳定不代表经由它的调用链稳定
+				_, noise = b.FlakyPaths[strings.Join(d.Path, pathSep)]
+			} else if d.Func != nil {
+				noise = flakyNodes[d.Func.ToString()]
+			} else if node := d.Edge(); node != nil {
+				key := edgeKey(node.Caller, node.Callee)
+				noise = flaky[key] || d.Kind == FrequencyChangedDiff && unsteady[key]
+			}
+		}
+		if noise {
+			removed = append(removed, d)
+		} else {
+			kept = append(kept, d)
+		}
+	}
+	return kept, removed
+}
+
+func edgeKey(caller, callee *Func) string {
+	return caller.ToString() + "|" + callee.ToString()
+}
+
+// ExportBaseline 在-exportdir下写出基线以及被它去掉的差异name.baseline.json, 未设置-exportdir时不写
+func ExportBaseline(name string, b *Baseline, removed []*Diff) error {
+	if *exportDir == "" || b == nil {
+		return nil
+	}
+	if err := os.MkdirAll(*exportDir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(struct {
+		*Baseline
+		Removed []*Diff `json:"removed"`
+	}{b, removed}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(*exportDir, name+".baseline.json"), data, 0666)
+}
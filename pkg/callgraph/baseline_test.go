@@ -0,0 +1,121 @@
+package callgraph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/constant"
+)
+
+func TestBaselineFilterDiffs(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/fl\n\ngo 1.18\n"})
+	run := func(counts map[string]int) EdgeMap {
+		m := make(EdgeMap)
+		for callee, n := range counts {
+			m.Add("example.com/fl.A", "example.com/fl."+callee, "common call", nil)
+			m["example.com/fl.A"]["example.com/fl."+callee].Count = n
+		}
+		return m
+	}
+	b := NewBaseline([]EdgeMap{
+		run(map[string]int{"Stable": 1, "Busy": 2, "Racy": 1}),
+		run(map[string]int{"Stable": 1, "Busy": 5}),
+		run(map[string]int{"Stable": 1, "Busy": 3, "Racy": 1}),
+	}, nil)
+	if b.Stable.size() != 2 || b.Flaky.size() != 1 || b.Unsteady.size() != 1 {
+		t.Fatalf("baseline stable=%v flaky=%v unsteady=%v", b.Stable, b.Flaky, b.Unsteady)
+	}
+	if n := b.Flaky["example.com/fl.A"]["example.com/fl.Racy"].Count; n != 2 {
+		t.Errorf("Racy appeared in %v runs, want 2", n)
+	}
+
+	raw := run(map[string]int{"Stable": 1, "Busy": 2, "Racy": 1})
+	faulty := run(map[string]int{"Busy": 4, "Handler": 1})
+	diffs := Compare(raw, faulty, dir, SourceDynamic)
+	static := &Diff{Kind: EdgeAddedDiff, Source: SourceStatic, NodeB: &Node{
+		Caller: &Func{FilePath: dir, FuncName: "A"}, Callee: &Func{FilePath: dir, FuncName: "Racy"}}}
+	kept, removed := b.FilterDiffs(append(diffs, static), dir)
+	got := make(map[string]bool)
+	for _, d := range kept {
+		got[string(d.Kind)+" "+d.PrintTrace()[1]+"->"+d.PrintTrace()[2]] = true
+	}
+	for _, want := range []string{
+		"edge-removed A->Stable",
+		"edge-added A->Handler",
+		"node-removed Stable->",
+		"node-added Handler->",
+		"edge-added A->Racy",
+	} {
+		if !got[want] {
+			t.Errorf("real diff %q was filtered, kept %v", want, got)
+		}
+	}
+	// Racy的增删和Busy的次数变化都是抖动
+	if len(removed) != 3 {
+		t.Errorf("removed %v diffs, want 3: %v", len(removed), removed)
+	}
+}
+
+func TestBaselineFilterPaths(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/fp\n\ngo 1.18\n"})
+	tree := func(paths ...string) *ContextTree {
+		ctx := NewContextTree()
+		for _, p := range paths {
+			ctx.Add(strings.Split(p, ","), 1)
+		}
+		return ctx
+	}
+	// C->D每次都出现, 但经由A->C的调用链只在一次运行中出现
+	runs := []*ContextTree{
+		tree("example.com/fp.A,example.com/fp.B,example.com/fp.D", "example.com/fp.A,example.com/fp.C,example.com/fp.D"),
+		tree("example.com/fp.A,example.com/fp.B,example.com/fp.D", "example.com/fp.A,example.com/fp.X,example.com/fp.C,example.com/fp.D"),
+	}
+	b := NewBaseline([]EdgeMap{{}, {}}, runs)
+	if n, ok := b.FlakyPaths["example.com/fp.A -> example.com/fp.C -> example.com/fp.D"]; !ok || n != 1 {
+		t.Fatalf("flaky paths = %v", b.FlakyPaths)
+	}
+	if _, ok := b.FlakyPaths["example.com/fp.A -> example.com/fp.B -> example.com/fp.D"]; ok {
+		t.Fatalf("a path seen in every run is not flaky: %v", b.FlakyPaths)
+	}
+
+	raw := tree("example.com/fp.A,example.com/fp.B,example.com/fp.D")
+	faulty := tree("example.com/fp.A,example.com/fp.B,example.com/fp.D", "example.com/fp.A,example.com/fp.C,example.com/fp.D",
+		"example.com/fp.A,example.com/fp.B,example.com/fp.E")
+	kept, removed := b.FilterDiffs(CompareContexts(raw, faulty, dir), dir)
+	keys := func(diffs []*Diff) []string {
+		res := make([]string, 0, len(diffs))
+		for _, d := range diffs {
+			res = append(res, d.Key())
+		}
+		return res
+	}
+	if got, want := keys(kept), []string{"path-added|example.com/fp.A -> example.com/fp.B -> example.com/fp.E"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+	if got, want := keys(removed), []string{"path-added|example.com/fp.A -> example.com/fp.C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("removed %v, want %v", got, want)
+	}
+}
+
+func TestSnapshotDynamic(t *testing.T) {
+	const num = 7
+	defer resetDynamic(num)
+	constant.CallGraphMu.Lock()
+	constant.CallGraph[num] = map[string]map[string]string{"a.A": {"a.B": "common call"}}
+	constant.CallCount[num] = map[string]map[string]int{"a.A": {"a.B": 3}}
+	constant.CallStacks[num] = map[string]int{"a.A\na.B": 3}
+	constant.CallGraphMu.Unlock()
+
+	snap := snapshotDynamic(num)
+	resetDynamic(num)
+	constant.CallGraphMu.Lock()
+	constant.CallCount[num] = map[string]map[string]int{"a.A": {"a.C": 5}}
+	constant.CallGraphMu.Unlock()
+	restoreDynamic(num, snap)
+
+	g := FromStringMap(constant.CallGraph[num], constant.CallCount[num])
+	if len(g) != 1 || g["a.A"]["a.B"] == nil || g["a.A"]["a.B"].Count != 3 || constant.CallStacks[num]["a.A\na.B"] != 3 {
+		t.Errorf("baseline runs should not leak into the dynamic data: %v", g)
+	}
+}
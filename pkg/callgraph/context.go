@@ -0,0 +1,188 @@
+package callgraph
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dataznGao/leo/constant"
+)
+
+// ContextNode 调用上下文树中的一个节点, 从根到它的路径就是一条调用链
+type ContextNode struct {
+	Func string `json:"func"`
+	// Count 经过该节点的调用链被观测到的次数
+	Count int `json:"count"`
+	// Recursive 调用链在这里递归回到了该函数, 递归部分被折叠到这个节点上
+	Recursive bool                    `json:"recursive,omitempty"`
+	Children  map[string]*ContextNode `json:"children,omitempty"`
+}
+
+// ContextTree 调用上下文树(calling context tree): 把完整的调用链按前缀合并,
+// 与调用边不同, 它保留了函数是经由哪条调用链被调用的
+type ContextTree struct {
+	Root *ContextNode `json:"root"`
+}
+
+func NewContextTree() *ContextTree {
+	return &ContextTree{Root: &ContextNode{}}
+}
+
+// Add 加入一条从外到内的调用链。链上再次出现已在当前路径上的函数时视为递归,
+// 回到该函数的节点继续, 这样递归调用不会让树无限加深
+func (t *ContextTree) Add(frames []string, count int) {
+	node := t.Root
+	node.Count += count
+	path := []*ContextNode{node}
+	for _, fn := range frames {
+		if i := indexOfFunc(path, fn); i >= 0 {
+			node = path[i]
+			node.Recursive = true
+			path = path[:i+1]
+			continue
+		}
+		if node.Children == nil {
+			node.Children = make(map[string]*ContextNode)
+		}
+		child, ok := node.Children[fn]
+		if !ok {
+			child = &ContextNode{Func: fn}
+			node.Children[fn] = child
+		}
+		child.Count += count
+		node = child
+		path = append(path, child)
+	}
+}
+
+func indexOfFunc(path []*ContextNode, fn string) int {
+	for i, n := range path {
+		if n.Func == fn {
+			return i
+		}
+	}
+	return -1
+}
+
+// Paths 树中所有从根出发的调用链(不包括根本身), 键为用" -> "连接的函数
+func (t *ContextTree) Paths() map[string]*ContextNode {
+	res := make(map[string]*ContextNode)
+	var walk func(prefix string, node *ContextNode)
+	walk = func(prefix string, node *ContextNode) {
+		for fn, child := range node.Children {
+			key := fn
+			if prefix != "" {
+				key = prefix + pathSep + fn
+			}
+			res[key] = child
+			walk(key, child)
+		}
+	}
+	if t != nil && t.Root != nil {
+		walk("", t.Root)
+	}
+	return res
+}
+
+// Merge 把o中的调用链合并进t, 次数累加
+func (t *ContextTree) Merge(o *ContextTree) {
+	if o == nil || o.Root == nil {
+		return
+	}
+	var merge func(dst, src *ContextNode)
+	merge = func(dst, src *ContextNode) {
+		dst.Count += src.Count
+		dst.Recursive = dst.Recursive || src.Recursive
+		for fn, child := range src.Children {
+			if dst.Children == nil {
+				dst.Children = make(map[string]*ContextNode)
+			}
+			next, ok := dst.Children[fn]
+			if !ok {
+				next = &ContextNode{Func: fn}
+				dst.Children[fn] = next
+			}
+			merge(next, child)
+		}
+	}
+	merge(t.Root, o.Root)
+}
+
+const pathSep = " -> "
+
+// DynamicContext 动态插桩收集到的调用上下文树
+func DynamicContext(num int) *ContextTree {
+	constant.CallGraphMu.Lock()
+	defer constant.CallGraphMu.Unlock()
+	t := NewContextTree()
+	for stack, n := range constant.CallStacks[num] {
+		t.Add(strings.Split(stack, "\n"), n)
+	}
+	return t
+}
+
+// CompareContexts 比对原始与故障的调用上下文树, 返回变化的调用链。
+// 一条调用链消失时它的所有延伸也都消失, 只报告分叉处: 调用链本身在另一棵树中不存在, 而去掉最后一个函数的前缀存在
+func CompareContexts(a, b *ContextTree, inputPath string) []*Diff {
+	ws, err := loadWorkspace(inputPath)
+	if err != nil {
+		log.Printf("[leo] WARN 无法识别%v中的module: %v", inputPath, err)
+	}
+	pathsA, pathsB := a.Paths(), b.Paths()
+	diffs := make([]*Diff, 0)
+	diverged := func(kind DiffKind, from, to map[string]*ContextNode) {
+		keys := make([]string, 0)
+		for key := range from {
+			if _, ok := to[key]; ok {
+				continue
+			}
+			if i := strings.LastIndex(key, pathSep); i >= 0 {
+				if _, ok := to[key[:i]]; !ok {
+					continue
+				}
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			path := strings.Split(key, pathSep)
+			d := &Diff{Kind: kind, Source: SourceDynamic, Path: path}
+			if len(path) == 1 {
+				d.Func = funcIn(ws, path[0])
+			} else {
+				node := newNode(funcIn(ws, path[len(path)-2]), funcIn(ws, path[len(path)-1]),
+					&Edge{Description: "context call", Count: from[key].Count}, inputPath)
+				if kind == PathRemovedDiff {
+					d.NodeA = node
+				} else {
+					d.NodeB = node
+				}
+			}
+			diffs = append(diffs, d)
+		}
+	}
+	diverged(PathRemovedDiff, pathsA, pathsB)
+	diverged(PathAddedDiff, pathsB, pathsA)
+	return diffs
+}
+
+// ExportContexts 在-exportdir下写出原始、故障的调用上下文树name.context.json, 未设置-exportdir时不写
+func ExportContexts(name string, raw, faulty *ContextTree) error {
+	if *exportDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(*exportDir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(struct {
+		Raw    *ContextTree `json:"raw"`
+		Faulty *ContextTree `json:"faulty"`
+	}{raw, faulty}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(*exportDir, name+".context.json"), data, 0666)
+}
@@ -0,0 +1,89 @@
+package callgraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContextTreeRecursion(t *testing.T) {
+	tree := NewContextTree()
+	tree.Add([]string{"example.com/c.A", "example.com/c.B", "example.com/c.A", "example.com/c.B", "example.com/c.C"}, 2)
+
+	paths := tree.Paths()
+	want := []string{"example.com/c.A", "example.com/c.A -> example.com/c.B", "example.com/c.A -> example.com/c.B -> example.com/c.C"}
+	if len(paths) != len(want) {
+		t.Fatalf("got paths %v, want %v", paths, want)
+	}
+	for _, key := range want {
+		if paths[key] == nil {
+			t.Fatalf("missing path %v in %v", key, paths)
+		}
+	}
+	if a := paths[want[0]]; !a.Recursive || a.Count != 2 {
+		t.Errorf("A = %+v, want recursive with count 2", a)
+	}
+	if c := paths[want[2]]; c.Recursive || c.Count != 2 {
+		t.Errorf("C = %+v, want count 2", c)
+	}
+}
+
+func TestCompareContexts(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/c\n\ngo 1.18\n"})
+	raw, faulty := NewContextTree(), NewContextTree()
+	// 故障让A先调用C再调用B, 调用边完全相同, 只有调用链变了
+	raw.Add([]string{"example.com/c.A", "example.com/c.B", "example.com/c.C"}, 1)
+	raw.Add([]string{"example.com/c.A", "example.com/c.C", "example.com/c.B"}, 1)
+	faulty.Add([]string{"example.com/c.A", "example.com/c.C", "example.com/c.B"}, 1)
+	faulty.Add([]string{"example.com/c.A", "example.com/c.C", "example.com/c.B", "example.com/c.C"}, 3)
+
+	got := make([]string, 0)
+	for _, d := range CompareContexts(raw, faulty, dir) {
+		if d.Source != SourceDynamic {
+			t.Errorf("%v has source %v", d.Key(), d.Source)
+		}
+		got = append(got, d.Key())
+	}
+	want := []string{
+		"path-removed|example.com/c.A -> example.com/c.B",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	raw.Add([]string{"example.com/c.A", "example.com/c.D"}, 1)
+	faulty.Add([]string{"example.com/c.A", "example.com/c.C", "example.com/c.D"}, 4)
+	diffs := CompareContexts(raw, faulty, dir)
+	got = got[:0]
+	for _, d := range diffs {
+		got = append(got, d.Key())
+	}
+	want = []string{
+		"path-removed|example.com/c.A -> example.com/c.B",
+		"path-removed|example.com/c.A -> example.com/c.D",
+		"path-added|example.com/c.A -> example.com/c.C -> example.com/c.D",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	added := diffs[2].NodeB
+	if added == nil || added.Caller.FuncName != "C" || added.Callee.FuncName != "D" || added.Count != 4 {
+		t.Errorf("path-added edge = %+v, want C->D observed 4 times", added)
+	}
+	if diffs[1].NodeA == nil || diffs[1].NodeA.Callee.FuncName != "D" {
+		t.Errorf("path-removed edge = %+v, want A->D", diffs[1].NodeA)
+	}
+}
+
+func TestContextTreeMerge(t *testing.T) {
+	a, b := NewContextTree(), NewContextTree()
+	a.Add([]string{"example.com/c.A", "example.com/c.B"}, 1)
+	b.Add([]string{"example.com/c.A", "example.com/c.B"}, 2)
+	b.Add([]string{"example.com/c.A", "example.com/c.C"}, 1)
+	a.Merge(b)
+	a.Merge(nil)
+	paths := a.Paths()
+	if len(paths) != 3 || paths["example.com/c.A"].Count != 4 || paths["example.com/c.A -> example.com/c.B"].Count != 3 ||
+		paths["example.com/c.A -> example.com/c.C"].Count != 1 {
+		t.Errorf("merged paths = %v", paths)
+	}
+}
@@ -10,6 +10,13 @@ import (
 	"go/build"
 	"golang.org/x/tools/go/buildutil"
 	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -22,13 +29,27 @@ var (
 	nointerFlag   = flag.Bool("nointer", false, "Omit calls to unexported functions.")
 	testFlag      = flag.Bool("tests", false, "Include test code.")
 	graphvizFlag  = flag.Bool("graphviz", false, "Use Graphviz's dot program to render images.")
-	httpFlag      = flag.String("http", ":7878", "HTTP service address.")
+	httpFlag      = flag.String("http", "127.0.0.1:7878", "HTTP service address of serve-ui. It serves the project's source code, listen on other interfaces only on trusted networks.")
 	skipBrowser   = flag.Bool("skipbrowser", false, "Skip opening browser.")
 	outputFile    = flag.String("file", "", "output filename - omit to use server mode")
 	outputFormat  = flag.String("format", "svg", "output file format [svg | png | jpg | ...]")
-	cacheDir      = flag.String("cacheDir", "", "Enable caching to avoid unnecessary re-rendering, you can force rendering by adding 'refresh=true' to the URL query or emptying the cache directory")
-	callgraphAlgo = flag.String("algo", CallGraphTypePointer, fmt.Sprintf("The algorithm used to construct the call graph. Possible values inlcude: %q, %q, %q, %q",
-		CallGraphTypeStatic, CallGraphTypeCha, CallGraphTypeRta, CallGraphTypePointer))
+	exportDir     = flag.String("exportdir", "", "Export the raw, faulty and diff call graphs of every test directory into this directory.")
+	exportFormat  = flag.String("exportformat", "graphml,json,csv", "Export formats [graphml | json | csv] (separated by comma)")
+	dotDir        = flag.String("dotdir", "", "Write the raw, faulty and diff call graphs of every test directory as DOT (and -format images with -graphviz) into this directory.")
+	callgraphAlgo = flag.String("algo", CallGraphTypePointer, fmt.Sprintf("The algorithm used to construct the call graph. Possible values inlcude: %q, %q, %q, %q, %q, %q",
+		CallGraphTypeStatic, CallGraphTypeCha, CallGraphTypeRta, CallGraphTypePointer, CallGraphTypeVta, CallGraphTypeAuto))
+	autoPointerMax = flag.Int("autoPointerMax", 30000, "In auto mode, use pointer analysis only for programs with at most this many functions.")
+	autoVtaMax     = flag.Int("autoVtaMax", 300000, "In auto mode, use VTA only for programs with at most this many functions, otherwise CHA.")
+	algoTimeout    = flag.Duration("algoTimeout", 10*time.Minute, "In auto mode, time budget of pointer/VTA before falling back to a cheaper algorithm (0 means unlimited).")
+	algoMemLimit   = flag.Uint64("algoMemLimit", 8192, "In auto mode, heap budget in MB of pointer/VTA before falling back to a cheaper algorithm (0 means unlimited).")
+	renderWorkers  = flag.Int("renderWorkers", runtime.NumCPU(), "Number of goroutines filtering call graph edges while rendering.")
+	queryGraph     = flag.String("querygraph", "", "Run leo query against this exported JSON call graph instead of analysing -input.")
+	queryPkg       = flag.String("querypkg", "", "Restrict leo query to functions in packages with given prefixes (separated by comma)")
+	queryMaxLen    = flag.Int("maxlen", 10, "Maximum number of calls on a path listed by leo query paths (0 means unlimited).")
+	queryMaxPaths  = flag.Int("maxpaths", 100, "Maximum number of paths listed by leo query paths (0 means unlimited).")
+	tolerantFlag   = flag.Bool("tolerant", false, "Skip packages with type errors (and the packages importing them) instead of failing, and report them in skipped.raw.json/skipped.faulty.json under -exportdir.")
+	baselineRuns   = flag.Int("baselineRuns", 0, "Run the unmodified tests this many times (at least 2) to find flaky dynamic edges, and drop the dynamic diffs they cause (0 disables the baseline).")
+	matrixFlag     = flag.String("matrix", "", "Analyse every build configuration and merge the call graphs, annotating each edge with the configurations it appears in. Configurations are separated by ';', each is [GOOS/GOARCH][,tag...] added to -tags, e.g. 'linux/amd64;windows/amd64;darwin/arm64,integration'")
 
 	debugFlag   = flag.Bool("debug", false, "Enable verbose logger.")
 	versionFlag = flag.Bool("version", false, "Show version and exit.")
@@ -44,6 +65,29 @@ func init() {
 	flag.StringVar(&rankdir, "rankdir", "LR", "Direction of graph layout [LR | RL | TB | BT]")
 }
 
+// OptionsKey 影响调用图结果的选项, 作为缓存键的一部分
+func OptionsKey() string {
+	return strings.Join([]string{
+		"algo=" + *callgraphAlgo,
+		"tags=" + strings.Join(build.Default.BuildTags, ","),
+		"goos=" + build.Default.GOOS,
+		"goarch=" + build.Default.GOARCH,
+		"matrix=" + *matrixFlag,
+		"tolerant=" + strconv.FormatBool(*tolerantFlag),
+		"baselineRuns=" + strconv.Itoa(*baselineRuns),
+		"focus=" + *focusFlag,
+		"group=" + *groupFlag,
+		"limit=" + *limitFlag,
+		"ignore=" + *ignoreFlag,
+		"include=" + *includeFlag,
+		"nointer=" + strconv.FormatBool(*nointerFlag),
+		"autoPointerMax=" + strconv.Itoa(*autoPointerMax),
+		"autoVtaMax=" + strconv.Itoa(*autoVtaMax),
+		"algoTimeout=" + algoTimeout.String(),
+		"algoMemLimit=" + strconv.FormatUint(*algoMemLimit, 10),
+	}, ";")
+}
+
 func logf(f string, a ...interface{}) {
 	if *debugFlag {
 		log.Printf(f, a...)
@@ -51,56 +95,162 @@ func logf(f string, a ...interface{}) {
 }
 
 func Draw(isTest bool, testPath, inputPath, packageName string, nostd bool) ([]*Vertx, error) {
-	args := []string{testPath}
+	anal := &analysis{firstParty: func(path string) bool {
+		return strings.Contains(path, packageName)
+	}}
+	return anal.draw(isTest, inputPath, []string{testPath}, nostd)
+}
 
-	anal := new(analysis)
+// draw 在dir下加载args对应的包, 生成调用图并渲染
+func (a *analysis) draw(isTest bool, dir string, args []string, nostd bool) ([]*Vertx, error) {
 	log.Printf("[leo] INFO 开始进行调用图分析")
-	if err := anal.DoAnalysis(CallGraphType(*callgraphAlgo), inputPath, isTest, args); err != nil {
+	if err := a.DoAnalysis(CallGraphType(*callgraphAlgo), dir, isTest, args); err != nil {
 		return nil, err
 	}
-	anal.OptsSetup()
-	anal.opts.nostd = nostd
-	anal.packageName = packageName
+	a.OptsSetup()
+	a.opts.nostd = nostd
 
 	// 将图转成可读的格式
 	log.Printf("[leo] INFO 开始渲染分析图")
-	output, _ := anal.Render()
+	output, err := a.Render()
+	if err != nil {
+		return nil, err
+	}
 	log.Printf("[leo] INFO 分析图渲染完毕")
 	return output, nil
 }
 
-func Anal(inputPath, testPath string) (map[string]map[string]string, error) {
-	packageName := util.GetPackageName(inputPath)
-	exchange := util.CompareAndExchange(testPath, packageName, inputPath)
-	vertxs, err := Draw(true, exchange, inputPath, packageName, true)
+// workspaces 每个项目根目录只识别一次其中的module
+var workspaces sync.Map
+
+func loadWorkspace(inputPath string) (*util.Workspace, error) {
+	if ws, ok := workspaces.Load(inputPath); ok {
+		return ws.(*util.Workspace), nil
+	}
+	ws, err := util.LoadWorkspace(inputPath)
 	if err != nil {
 		return nil, err
 	}
-	nodes := make(map[string]interface{})
-	for _, vertx := range vertxs {
-		caller := vertx.Caller
-		callee := vertx.Callee
-		nodes[caller] = ""
-		nodes[callee] = ""
+	workspaces.Store(inputPath, ws)
+	return ws, nil
+}
+
+// TestPackages inputPath的工作区中已登记的外部测试包和测试main包, 随缓存保存
+func TestPackages(inputPath string) map[string]string {
+	ws, err := loadWorkspace(inputPath)
+	if err != nil {
+		return nil
+	}
+	return ws.TestPackages()
+}
+
+// AddTestPackages 命中缓存没有加载包时, 重新登记缓存中保存的测试包
+func AddTestPackages(inputPath string, pkgs map[string]string) {
+	ws, err := loadWorkspace(inputPath)
+	if err != nil {
+		return
+	}
+	for path, forTest := range pkgs {
+		ws.AddTestPackage(path, forTest)
+	}
+}
+
+// workspaceAnalysis 返回以inputPath下所有module为项目包的analysis,
+// 多module时加载用的go.work放在临时目录中, 分析完后调用cleanup删除
+func workspaceAnalysis(inputPath string) (anal *analysis, ws *util.Workspace, cleanup func(), err error) {
+	ws, err = loadWorkspace(inputPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tmpDir, err := os.MkdirTemp("", "leo-work")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cleanup = func() { os.RemoveAll(tmpDir) }
+	env, err := ws.GoWorkEnv(tmpDir)
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+	return &analysis{firstParty: ws.Contains, ws: ws, env: env}, ws, cleanup, nil
+}
+
+func Anal(inputPath, testPath string) (EdgeMap, error) {
+	configs, err := matrixConfigs()
+	if err != nil {
+		return nil, err
+	}
+	graphs := make([]EdgeMap, 0, len(configs))
+	for _, config := range configs {
+		g, err := analConfig(inputPath, testPath, config)
+		if err != nil {
+			return nil, err
+		}
+		graphs = append(graphs, g)
+	}
+	return mergeBuilds(configs, graphs), nil
+}
+
+// analConfig 在构建配置config下生成testPath的调用图
+func analConfig(inputPath, testPath string, config *BuildConfig) (EdgeMap, error) {
+	anal, ws, cleanup, err := workspaceAnalysis(inputPath)
+	if err != nil {
+		return nil, err
 	}
-	res := make(map[string]map[string]string, len(nodes))
-	for i := range nodes {
-		res[i] = make(map[string]string)
+	defer cleanup()
+	anal.config = config
+	// 用相对于根目录的目录模式加载, 工作区模式下按导入路径查找可能要访问模块代理
+	rel, err := filepath.Rel(ws.Root, testPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("%s is not in %s", testPath, inputPath)
 	}
+	vertxs, err := anal.draw(true, inputPath, []string{"./" + filepath.ToSlash(rel)}, true)
+	if err != nil {
+		return nil, err
+	}
+	res := make(EdgeMap)
 	for _, vertx := range vertxs {
-		caller := vertx.Caller
-		callee := vertx.Callee
-		res[caller][callee] = vertx.Description
+		res.Add(vertx.Caller, vertx.Callee, vertx.Description, relSite(inputPath, vertx.Site))
 	}
 	return res, nil
 }
 
-// DynamicAnal 动态调用图分析
-func DynamicAnal(inputPath, testPath string, num int) (map[string]map[string]string, error) {
+// relSite 项目内的调用点改为相对于项目根目录的路径, 以便与原项目对应
+func relSite(inputPath string, site *CallSite) *CallSite {
+	if site == nil {
+		return nil
+	}
+	res := *site
+	if rel, err := filepath.Rel(inputPath, site.File); err == nil && !strings.HasPrefix(rel, "..") {
+		res.File = rel
+	}
+	return &res
+}
+
+// BaselineRuns -baselineRuns, 小于2时不生成动态调用图基线
+func BaselineRuns() int {
+	return *baselineRuns
+}
+
+// DynamicAnal 动态调用图分析, failed为是否有测试失败。只包含这次运行的测试观测到的调用,
+// 之前的测试文件夹收集到的调用图、次数和调用链会被清空
+func DynamicAnal(inputPath, testPath string, num int) (graph EdgeMap, failed bool, err error) {
+	resetDynamic(num)
 	// 因为动态都插桩完毕了，只要测试一遍即可
-	_, err := run.Test(testPath, inputPath)
+	output, err := run.Test(testPath, inputPath)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return constant.CallGraph[num], nil
+	constant.CallGraphMu.Lock()
+	defer constant.CallGraphMu.Unlock()
+	return FromStringMap(constant.CallGraph[num], constant.CallCount[num]), testsFailed(output), nil
+}
+
+// resetDynamic 清空num对应的动态调用图、调用次数和调用链
+func resetDynamic(num int) {
+	constant.CallGraphMu.Lock()
+	defer constant.CallGraphMu.Unlock()
+	delete(constant.CallGraph, num)
+	delete(constant.CallCount, num)
+	delete(constant.CallStacks, num)
 }
@@ -1,6 +1,7 @@
 package callgraph
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"runtime/pprof"
@@ -56,3 +57,28 @@ func Exists(path string) bool {
 	}
 	return true
 }
+
+func TestOptionsKey(t *testing.T) {
+	for _, name := range []string{"limit", "ignore", "include", "nointer", "focus", "group",
+		"autoPointerMax", "autoVtaMax", "algoTimeout", "algoMemLimit", "algo", "baselineRuns"} {
+		f := flag.Lookup(name)
+		old := f.Value.String()
+		before := OptionsKey()
+		value := "7"
+		switch name {
+		case "nointer":
+			value = "true"
+		case "algoTimeout":
+			value = "7s"
+		case "limit", "ignore", "include", "focus", "group", "algo":
+			value = "example.com/x"
+		}
+		if err := f.Value.Set(value); err != nil {
+			t.Fatal(err)
+		}
+		if OptionsKey() == before {
+			t.Errorf("-%v does not change the options key", name)
+		}
+		f.Value.Set(old)
+	}
+}
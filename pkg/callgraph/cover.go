@@ -0,0 +1,167 @@
+package callgraph
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Calls 每个函数直接调用的函数, 只保留计算覆盖需要的调用关系, 函数名与EdgeMap中相同
+type Calls map[string][]string
+
+// Merge 把调用图g中的调用关系加入c
+func (c Calls) Merge(g EdgeMap) {
+	for caller, callees := range g {
+		seen := make(map[string]bool, len(c[caller]))
+		for _, callee := range c[caller] {
+			seen[callee] = true
+		}
+		for callee := range callees {
+			if !seen[callee] {
+				c[caller] = append(c[caller], callee)
+			}
+		}
+		sort.Strings(c[caller])
+	}
+}
+
+// CoverSite 最小覆盖中选出的一个注入点, 以及它覆盖的差异
+type CoverSite struct {
+	Site *Diff `json:"site"`
+	// Covers 被该注入点覆盖的差异的Key, 包括它自己
+	Covers []string `json:"covers"`
+}
+
+// Cover 从sites中选出尽量少的注入点, 使targets中的每个差异都被覆盖。
+// 在caller->callee的调用之后打日志, 能看到经由callee的整个调用过程, 因此一个注入点覆盖:
+// 它自己; 它下面depth层调用以内的边差异, callee直接发出的调用为第1层, depth为0时不覆盖下面的调用;
+// 调用链中经过它这条边的调用链差异。
+// 贪心地每次选覆盖未覆盖差异最多的注入点, 个数相同时选得分高的
+func Cover(sites, targets []*Diff, calls Calls, inputPath string, depth int) []*CoverSite {
+	ws, err := loadWorkspace(inputPath)
+	if err != nil {
+		log.Printf("[leo] WARN 无法识别%v中的module: %v", inputPath, err)
+	}
+	// 调用关系中的函数名换成与差异中相同的形式
+	adj := make(map[string][]string, len(calls))
+	for caller, callees := range calls {
+		key := funcIn(ws, caller).ToString()
+		for _, callee := range callees {
+			adj[key] = append(adj[key], funcIn(ws, callee).ToString())
+		}
+	}
+	// reach 在depth层调用以内发出调用的函数: from自己发出的调用是第1层
+	reach := func(from string) map[string]bool {
+		res := make(map[string]bool)
+		if depth < 1 {
+			return res
+		}
+		res[from] = true
+		frontier := []string{from}
+		for i := 1; i < depth && len(frontier) > 0; i++ {
+			next := make([]string, 0)
+			for _, fn := range frontier {
+				for _, callee := range adj[fn] {
+					if !res[callee] {
+						res[callee] = true
+						next = append(next, callee)
+					}
+				}
+			}
+			frontier = next
+		}
+		return res
+	}
+	onPath := func(d *Diff, caller, callee string) bool {
+		for i := 0; i+1 < len(d.Path); i++ {
+			if funcIn(ws, d.Path[i]).ToString() == caller && funcIn(ws, d.Path[i+1]).ToString() == callee {
+				return true
+			}
+		}
+		return false
+	}
+
+	covers := make([]map[string]bool, len(sites))
+	for i, s := range sites {
+		covers[i] = make(map[string]bool)
+		node := s.Edge()
+		if node == nil {
+			continue
+		}
+		caller, callee := node.Caller.ToString(), node.Callee.ToString()
+		below := reach(callee)
+		for _, d := range targets {
+			switch {
+			case d.Key() == s.Key(), onPath(d, caller, callee):
+				covers[i][d.Key()] = true
+			case d.Edge() != nil && below[d.Edge().Caller.ToString()]:
+				covers[i][d.Key()] = true
+			}
+		}
+	}
+
+	uncovered := make(map[string]bool, len(targets))
+	for _, d := range targets {
+		uncovered[d.Key()] = true
+	}
+	res := make([]*CoverSite, 0)
+	chosen := make([]bool, len(sites))
+	for len(uncovered) > 0 {
+		best, bestN := -1, 0
+		for i, s := range sites {
+			if chosen[i] {
+				continue
+			}
+			n := 0
+			for key := range covers[i] {
+				if uncovered[key] {
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			if n > bestN || n == bestN && betterSite(s, sites[best]) {
+				best, bestN = i, n
+			}
+		}
+		// 剩下的差异没有注入点能覆盖
+		if best < 0 {
+			break
+		}
+		chosen[best] = true
+		keys := make([]string, 0, len(covers[best]))
+		for key := range covers[best] {
+			keys = append(keys, key)
+			delete(uncovered, key)
+		}
+		sort.Strings(keys)
+		res = append(res, &CoverSite{Site: sites[best], Covers: keys})
+	}
+	return res
+}
+
+// betterSite 覆盖的差异一样多时a是否比b更适合作为注入点
+func betterSite(a, b *Diff) bool {
+	if sa, sb := a.Score(), b.Score(); sa != sb {
+		return sa > sb
+	}
+	return a.Key() < b.Key()
+}
+
+// ExportCover 在-exportdir下写出最小覆盖cover.json, 未设置-exportdir时不写
+func ExportCover(sites []*CoverSite) error {
+	if *exportDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(*exportDir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(*exportDir, "cover.json"), data, 0666)
+}
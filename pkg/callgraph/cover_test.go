@@ -0,0 +1,55 @@
+package callgraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCover(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/v\n\ngo 1.18\n"})
+	raw := make(EdgeMap)
+	raw.Add("example.com/v.A", "example.com/v.B", "static function call", nil)
+	raw.Add("example.com/v.B", "example.com/v.C", "static function call", nil)
+	raw.Add("example.com/v.C", "example.com/v.D", "static function call", nil)
+	raw.Add("example.com/v.X", "example.com/v.Y", "static function call", nil)
+	sites := make([]*Diff, 0)
+	for _, d := range Compare(raw, make(EdgeMap), dir, SourceStatic) {
+		if d.Kind == EdgeRemovedDiff {
+			sites = append(sites, d)
+		}
+	}
+	calls := make(Calls)
+	calls.Merge(raw)
+
+	chosen := func(cover []*CoverSite) []string {
+		res := make([]string, 0, len(cover))
+		for _, c := range cover {
+			res = append(res, c.Site.Edge().Caller.FuncName+"->"+c.Site.Edge().Callee.FuncName)
+		}
+		return res
+	}
+	cover := Cover(sites, sites, calls, dir, 3)
+	if got, want := chosen(cover), []string{"A->B", "X->Y"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("depth 3 chose %v, want %v", got, want)
+	}
+	if len(cover[0].Covers) != 3 {
+		t.Errorf("A->B covers %v, want the whole chain", cover[0].Covers)
+	}
+	if got, want := chosen(Cover(sites, sites, calls, dir, 2)), []string{"A->B", "X->Y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("depth 2 chose %v, want %v", got, want)
+	}
+	// depth 1只覆盖callee直接发出的调用
+	if cover := Cover(sites, sites, calls, dir, 1); len(cover[0].Covers) != 2 {
+		t.Errorf("depth 1: %v covers %v, want itself and the call below", chosen(cover)[0], cover[0].Covers)
+	}
+	// depth 0只覆盖自己
+	if got, want := chosen(Cover(sites, sites, calls, dir, 0)), []string{"A->B", "B->C", "C->D", "X->Y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("depth 0 chose %v, want %v", got, want)
+	}
+
+	// 没有调用关系时, 经过注入点所在边的调用链仍然被覆盖
+	path := &Diff{Kind: PathRemovedDiff, Source: SourceDynamic, Path: []string{"example.com/v.A", "example.com/v.B", "example.com/v.Z"}}
+	if got, want := chosen(Cover(sites, []*Diff{path}, nil, dir, 3)), []string{"A->B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("path cover chose %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,229 @@
+package callgraph
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dataznGao/leo/util"
+)
+
+// DiffKind 原始调用图(A)与故障调用图(B)之间差异的种类
+type DiffKind string
+
+const (
+	// EdgeRemovedDiff 边只在A中, NodeA为该边
+	EdgeRemovedDiff DiffKind = "edge-removed"
+	// EdgeAddedDiff 边只在B中, NodeB为该边
+	EdgeAddedDiff DiffKind = "edge-added"
+	// DescChangedDiff 两边都有, 描述不同
+	DescChangedDiff DiffKind = "desc-changed"
+	// FrequencyChangedDiff 两边都有, 调用点个数(静态)或被观测到的次数(动态)不同
+	FrequencyChangedDiff DiffKind = "frequency-changed"
+	// NodeRemovedDiff 函数只在A中, Func为该函数
+	NodeRemovedDiff DiffKind = "node-removed"
+	// NodeAddedDiff 函数只在B中, Func为该函数
+	NodeAddedDiff DiffKind = "node-added"
+	// PathRemovedDiff 动态调用链只在A中, Path为该调用链, NodeA为它的最后一条边(调用链只有一个函数时Func为该函数)
+	PathRemovedDiff DiffKind = "path-removed"
+	// PathAddedDiff 动态调用链只在B中, Path为该调用链, NodeB为它的最后一条边(调用链只有一个函数时Func为该函数)
+	PathAddedDiff DiffKind = "path-added"
+)
+
+// DiffKinds 所有差异种类
+var DiffKinds = []DiffKind{EdgeRemovedDiff, EdgeAddedDiff, DescChangedDiff, FrequencyChangedDiff,
+	NodeRemovedDiff, NodeAddedDiff, PathRemovedDiff, PathAddedDiff}
+
+// DiffSource 差异来自静态调用图、动态调用图还是二者都有
+type DiffSource string
+
+const (
+	SourceStatic  DiffSource = "static"
+	SourceDynamic DiffSource = "dynamic"
+	SourceBoth    DiffSource = "both"
+)
+
+// Diff 原始调用图与故障调用图之间的一处差异
+type Diff struct {
+	Kind   DiffKind   `json:"kind"`
+	Source DiffSource `json:"source"`
+	// NodeA 原始调用图中的边, 边差异中只在B中时为nil
+	NodeA *Node `json:"a,omitempty"`
+	// NodeB 故障调用图中的边, 边差异中只在A中时为nil
+	NodeB *Node `json:"b,omitempty"`
+	// Func 节点差异对应的函数
+	Func *Func `json:"func,omitempty"`
+	// Path 调用链差异对应的调用链, 从外到内
+	Path []string `json:"path,omitempty"`
+	// Impact 差异受故障影响的程度, 由AnnotateImpact记录
+	Impact *Impact `json:"impact,omitempty"`
+}
+
+// Key 差异的唯一标识: 种类和对应的调用链、边或函数, 不包括来源, 用于去重以及合并静态、动态的差异
+func (d *Diff) Key() string {
+	if len(d.Path) > 0 {
+		return string(d.Kind) + "|" + strings.Join(d.Path, pathSep)
+	}
+	if d.Func != nil {
+		return string(d.Kind) + "|" + d.Func.ToString()
+	}
+	node := d.NodeA
+	if node == nil {
+		node = d.NodeB
+	}
+	if node == nil {
+		return string(d.Kind)
+	}
+	return string(d.Kind) + "|" + node.Caller.ToString() + "|" + node.Callee.ToString()
+}
+
+// Edge 边差异对应的边, 优先取原始调用图中的; 节点差异返回nil
+func (d *Diff) Edge() *Node {
+	if d.NodeA != nil {
+		return d.NodeA
+	}
+	return d.NodeB
+}
+
+func (d *Diff) PrintTrace() []string {
+	node := d.Edge()
+	if node == nil {
+		return []string{d.Func.FilePath, d.Func.FuncName, ""}
+	}
+	return []string{node.Caller.FilePath, node.Caller.FuncName, node.Callee.FuncName}
+}
+
+// funcIn 解析函数名, 并把项目包的导入路径换成它在工作区中的目录
+func funcIn(ws *util.Workspace, name string) *Func {
+	f := String2Func(name)
+	if ws == nil {
+		return f
+	}
+	if dir := ws.Dir(f.FilePath); dir != "" {
+		f.FilePath = dir
+	}
+	return f
+}
+
+func newNode(caller, callee *Func, edge *Edge, inputPath string) *Node {
+	return &Node{
+		Caller:      caller,
+		Callee:      callee,
+		Description: edge.Description,
+		Sites:       sitesIn(edge, inputPath),
+		Count:       edge.Count,
+	}
+}
+
+// Compare 比对原始调用图a与故障调用图b, source为两张图的来源
+func Compare(a, b EdgeMap, inputPath string, source DiffSource) []*Diff {
+	ws, err := loadWorkspace(inputPath)
+	if err != nil {
+		log.Printf("[leo] WARN 无法识别%v中的module: %v", inputPath, err)
+	}
+	diffs := make([]*Diff, 0)
+	add := func(kind DiffKind, caller, callee string, edgeA, edgeB *Edge) {
+		d := &Diff{Kind: kind, Source: source}
+		callerPath, calleePath := funcIn(ws, caller), funcIn(ws, callee)
+		if edgeA != nil {
+			d.NodeA = newNode(callerPath, calleePath, edgeA, inputPath)
+		}
+		if edgeB != nil {
+			d.NodeB = newNode(callerPath, calleePath, edgeB, inputPath)
+		}
+		diffs = append(diffs, d)
+	}
+	for caller, m := range a {
+		for callee, edgeA := range m {
+			edgeB := b[caller][callee]
+			switch {
+			case edgeB == nil:
+				add(EdgeRemovedDiff, caller, callee, edgeA, nil)
+			case edgeA.Description != edgeB.Description:
+				add(DescChangedDiff, caller, callee, edgeA, edgeB)
+			}
+			if edgeB != nil && edgeA.Count != edgeB.Count {
+				add(FrequencyChangedDiff, caller, callee, edgeA, edgeB)
+			}
+		}
+	}
+	for caller, m := range b {
+		for callee, edgeB := range m {
+			if a[caller][callee] == nil {
+				add(EdgeAddedDiff, caller, callee, nil, edgeB)
+			}
+		}
+	}
+	nodesA, nodesB := a.nodes(), b.nodes()
+	for fn := range nodesA {
+		if !nodesB[fn] {
+			diffs = append(diffs, &Diff{Kind: NodeRemovedDiff, Source: source, Func: funcIn(ws, fn)})
+		}
+	}
+	for fn := range nodesB {
+		if !nodesA[fn] {
+			diffs = append(diffs, &Diff{Kind: NodeAddedDiff, Source: source, Func: funcIn(ws, fn)})
+		}
+	}
+	return MergeDiffs(diffs)
+}
+
+// MergeDiffs 按Key去重, 同一处差异同时来自静态和动态调用图时来源记为both, 影响累加, 结果按Key排序
+func MergeDiffs(diffs []*Diff) []*Diff {
+	byKey := make(map[string]*Diff, len(diffs))
+	for _, d := range diffs {
+		key := d.Key()
+		old, ok := byKey[key]
+		if !ok {
+			byKey[key] = d
+			continue
+		}
+		merged := *old
+		merged.Impact = old.Impact.merge(d.Impact)
+		if old.Source != d.Source {
+			merged.Source = SourceBoth
+			// 静态边带有调用点, 注入时更精确
+			if d.Source == SourceStatic {
+				merged.NodeA, merged.NodeB = d.NodeA, d.NodeB
+			}
+		}
+		byKey[key] = &merged
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	res := make([]*Diff, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, byKey[key])
+	}
+	return res
+}
+
+// DiffsFrom 来自source(包括同时来自静态和动态调用图)的差异
+func DiffsFrom(diffs []*Diff, source DiffSource) []*Diff {
+	res := make([]*Diff, 0, len(diffs))
+	for _, d := range diffs {
+		if d.Source == source || d.Source == SourceBoth {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// CountDiffs 按种类统计差异个数, 用于日志输出
+func CountDiffs(diffs []*Diff) string {
+	counts := make(map[DiffKind]int)
+	for _, d := range diffs {
+		counts[d.Kind]++
+	}
+	parts := make([]string, 0, len(counts))
+	for _, kind := range DiffKinds {
+		if counts[kind] > 0 {
+			parts = append(parts, string(kind)+"="+strconv.Itoa(counts[kind]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
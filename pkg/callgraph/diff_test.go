@@ -0,0 +1,75 @@
+package callgraph
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompareKinds(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/d\n\ngo 1.18\n"})
+	raw, faulty := make(EdgeMap), make(EdgeMap)
+	raw.Add("example.com/d.A", "example.com/d.B", "static function call", nil)
+	raw.Add("example.com/d.A", "example.com/d.C", "static function call", nil)
+	raw.Add("example.com/d.A", "example.com/d.Gone", "static function call", nil)
+	faulty.Add("example.com/d.A", "example.com/d.B", "dynamic function call", nil)
+	faulty.Add("example.com/d.A", "example.com/d.C", "static function call", nil)
+	faulty.Add("example.com/d.A", "example.com/d.C", "static function call", nil)
+	faulty.Add("example.com/d.A", "example.com/d.New", "static function call", nil)
+
+	got := make(map[string]DiffKind)
+	for _, d := range Compare(raw, faulty, dir, SourceStatic) {
+		if d.Source != SourceStatic {
+			t.Errorf("%v has source %v", d.Key(), d.Source)
+		}
+		if d.Func != nil {
+			got[d.Func.FuncName] = d.Kind
+		} else {
+			got["A->"+d.Edge().Callee.FuncName+" "+string(d.Kind)] = d.Kind
+		}
+	}
+	want := map[string]DiffKind{
+		"A->B " + string(DescChangedDiff):      DescChangedDiff,
+		"A->C " + string(FrequencyChangedDiff): FrequencyChangedDiff,
+		"A->Gone " + string(EdgeRemovedDiff):   EdgeRemovedDiff,
+		"A->New " + string(EdgeAddedDiff):      EdgeAddedDiff,
+		"Gone":                                 NodeRemovedDiff,
+		"New":                                  NodeAddedDiff,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare kinds = %v, want %v", got, want)
+	}
+}
+
+func TestMergeDiffs(t *testing.T) {
+	edge := func(source DiffSource, sites int) *Diff {
+		node := &Node{Caller: &Func{FilePath: "/p", FuncName: "A"}, Callee: &Func{FilePath: "/p", FuncName: "B"}}
+		for i := 0; i < sites; i++ {
+			node.Sites = append(node.Sites, &CallSite{File: "/p/a.go", Line: i + 1})
+		}
+		return &Diff{Kind: EdgeRemovedDiff, Source: source, NodeA: node}
+	}
+	node := &Diff{Kind: NodeRemovedDiff, Source: SourceDynamic, Func: &Func{FilePath: "/p", FuncName: "B"}}
+	merged := MergeDiffs([]*Diff{edge(SourceDynamic, 0), node, edge(SourceStatic, 1), edge(SourceStatic, 1)})
+	if len(merged) != 2 {
+		t.Fatalf("merged = %v, want the edge and the node", merged)
+	}
+	if d := merged[0]; d.Kind != EdgeRemovedDiff || d.Source != SourceBoth || len(d.NodeA.Sites) != 1 {
+		t.Errorf("edge seen statically and dynamically should be both and keep static sites: %+v", d)
+	}
+	if d := merged[1]; d.Kind != NodeRemovedDiff || d.Source != SourceDynamic {
+		t.Errorf("node diff should stay dynamic: %+v", d)
+	}
+
+	data, err := json.Marshal(merged[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"kind":"node-removed","source":"dynamic","func":{"path":"/p","func":"B"}}`; string(data) != want {
+		t.Errorf("json = %s, want %s", data, want)
+	}
+	var back Diff
+	if err := json.Unmarshal(data, &back); err != nil || back.Key() != merged[1].Key() {
+		t.Errorf("json round trip lost the diff: %+v, %v", back, err)
+	}
+}
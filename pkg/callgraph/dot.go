@@ -1,8 +1,14 @@
 package callgraph
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
 	"strings"
+	"text/template"
 )
 
 var (
@@ -56,6 +62,60 @@ const tmplGraph = `digraph gocallvis {
 }
 `
 
+//==[ type def/func: dotGraph   ]===============================================
+type dotGraph struct {
+	Title   string
+	Cluster *dotCluster
+	Edges   []*dotEdge
+	Options map[string]string
+}
+
+func newDotGraph(title string) *dotGraph {
+	return &dotGraph{
+		Title:   title,
+		Cluster: NewDotCluster("focus"),
+		Options: map[string]string{
+			"minlen":    fmt.Sprint(minlen),
+			"nodesep":   fmt.Sprint(nodesep),
+			"nodeshape": nodeshape,
+			"nodestyle": nodestyle,
+			"rankdir":   rankdir,
+		},
+	}
+}
+
+func (g *dotGraph) WriteDot(w io.Writer) error {
+	t := template.New("dot")
+	for _, s := range []string{tmplCluster, tmplNode, tmplEdge, tmplGraph} {
+		if _, err := t.Parse(s); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, g); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// runDotToImage 调用本地graphviz的dot程序, 将dot文件转成format格式的图片
+func runDotToImage(dotFile, format string) (string, error) {
+	img := strings.TrimSuffix(dotFile, ".dot") + "." + format
+	dot, err := os.ReadFile(dotFile)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("dot", fmt.Sprintf("-T%s", format), "-o", img)
+	cmd.Stdin = bytes.NewReader(dot)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("command '%v': %v\n%v", cmd, err, stderr.String())
+	}
+	return img, nil
+}
+
 //==[ type def/func: dotCluster ]===============================================
 type dotCluster struct {
 	ID       string
@@ -99,6 +159,7 @@ func (p dotAttrs) List() []string {
 	for k, v := range p {
 		l = append(l, fmt.Sprintf("%s=%q", k, v))
 	}
+	sort.Strings(l)
 	return l
 }
 
@@ -114,8 +175,13 @@ type Vertx struct {
 	Caller      string
 	Callee      string
 	Description string
+	Site        *CallSite
 }
 
 func (v *Vertx) ToString() string {
-	return v.Caller + " - " + v.Description + " - " + v.Callee
+	res := v.Caller + " - " + v.Description + " - " + v.Callee
+	if v.Site != nil {
+		res += " - " + v.Site.ToString()
+	}
+	return res
 }
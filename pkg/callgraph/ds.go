@@ -1,11 +1,184 @@
 package callgraph
 
-import "strings"
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
 
 type Node struct {
-	Caller      *Func
-	Callee      *Func
-	Description string
+	Caller      *Func  `json:"caller"`
+	Callee      *Func  `json:"callee"`
+	Description string `json:"description"`
+	// Sites 静态边在源码中的调用点, 动态边为空
+	Sites []*CallSite `json:"sites,omitempty"`
+	// Count 同Edge.Count
+	Count int `json:"count,omitempty"`
+}
+
+// CallKind 调用点的调用方式
+type CallKind string
+
+const (
+	CallStatic    CallKind = "static"
+	CallInterface CallKind = "interface"
+	CallClosure   CallKind = "closure"
+	CallGo        CallKind = "go"
+	CallDefer     CallKind = "defer"
+)
+
+// CallSite 调用点在源码中的位置以及调用方式
+type CallSite struct {
+	File   string   `json:"file"`
+	Line   int      `json:"line"`
+	Column int      `json:"column"`
+	Kind   CallKind `json:"kind"`
+}
+
+func (s *CallSite) ToString() string {
+	return fmt.Sprintf("%s:%d:%d:%s", s.File, s.Line, s.Column, s.Kind)
+}
+
+// Edge 调用图中的一条caller->callee边, 同一对函数之间的多个调用点合并在一起
+type Edge struct {
+	Description string      `json:"description"`
+	Sites       []*CallSite `json:"sites,omitempty"`
+	// Count 静态边为调用点个数, 动态边为运行时被观测到的次数
+	Count int `json:"count"`
+	// Configs 按-matrix分析时边出现在哪些构建配置中, 只有一个配置时为空
+	Configs []string `json:"configs,omitempty"`
+}
+
+// EdgeMap 调用图, caller -> callee -> 边
+type EdgeMap map[string]map[string]*Edge
+
+// Add 添加一条边, 边已存在时只合并调用点
+func (m EdgeMap) Add(caller, callee, description string, site *CallSite) {
+	if _, ok := m[caller]; !ok {
+		m[caller] = make(map[string]*Edge)
+	}
+	edge, ok := m[caller][callee]
+	if !ok {
+		edge = &Edge{Description: description}
+		m[caller][callee] = edge
+	}
+	if site == nil {
+		edge.Count++
+		return
+	}
+	for _, s := range edge.Sites {
+		if *s == *site {
+			return
+		}
+	}
+	edge.Sites = append(edge.Sites, site)
+	edge.Count++
+}
+
+// StripPositions 去掉调用点的行号和列号, 只保留文件和调用方式, 边的Count不变。
+// 用于调用点的位置与原项目对不上的调用图, 如插桩、故障注入后的项目
+func (m EdgeMap) StripPositions() {
+	for _, callees := range m {
+		for _, edge := range callees {
+			sites := edge.Sites[:0]
+			for _, s := range edge.Sites {
+				site := &CallSite{File: s.File, Kind: s.Kind}
+				dup := false
+				for _, t := range sites {
+					dup = dup || *t == *site
+				}
+				if !dup {
+					sites = append(sites, site)
+				}
+			}
+			edge.Sites = sites
+		}
+	}
+}
+
+// DropCallees 去掉被调用者在包pkg中的边
+func (m EdgeMap) DropCallees(pkg string) {
+	for caller, callees := range m {
+		for callee := range callees {
+			if String2Func(callee).FilePath == pkg {
+				delete(callees, callee)
+			}
+		}
+		if len(callees) == 0 {
+			delete(m, caller)
+		}
+	}
+}
+
+// UnionGraphs 合并多张调用图: 边出现在任意一张图中即保留, 调用点和构建配置取并集, Count取各图中的最大值
+func UnionGraphs(graphs ...EdgeMap) EdgeMap {
+	res := make(EdgeMap)
+	for _, g := range graphs {
+		for caller, m := range g {
+			for callee, edge := range m {
+				if _, ok := res[caller]; !ok {
+					res[caller] = make(map[string]*Edge)
+				}
+				merged, ok := res[caller][callee]
+				if !ok {
+					merged = &Edge{Description: edge.Description}
+					res[caller][callee] = merged
+				}
+				for _, site := range edge.Sites {
+					dup := false
+					for _, s := range merged.Sites {
+						dup = dup || *s == *site
+					}
+					if !dup {
+						merged.Sites = append(merged.Sites, site)
+					}
+				}
+				for _, c := range edge.Configs {
+					dup := false
+					for _, d := range merged.Configs {
+						dup = dup || d == c
+					}
+					if !dup {
+						merged.Configs = append(merged.Configs, c)
+					}
+				}
+				if edge.Count > merged.Count {
+					merged.Count = edge.Count
+				}
+			}
+		}
+	}
+	return res
+}
+
+// FromStringMap 将动态调用图(caller -> callee -> 描述)转成EdgeMap, counts为每条边被观测到的次数, 可以为nil
+func FromStringMap(graph map[string]map[string]string, counts map[string]map[string]int) EdgeMap {
+	res := make(EdgeMap, len(graph))
+	for caller, m := range graph {
+		for callee, desc := range m {
+			res.Add(caller, callee, desc, nil)
+			if n, ok := counts[caller][callee]; ok {
+				res[caller][callee].Count = n
+			}
+		}
+	}
+	return res
+}
+
+// sitesIn 将相对于被分析项目根目录的调用点转成inputPath下的绝对路径
+func sitesIn(edge *Edge, inputPath string) []*CallSite {
+	if edge == nil || len(edge.Sites) == 0 {
+		return nil
+	}
+	res := make([]*CallSite, 0, len(edge.Sites))
+	for _, s := range edge.Sites {
+		site := *s
+		if !filepath.IsAbs(site.File) {
+			site.File = filepath.Join(inputPath, site.File)
+		}
+		res = append(res, &site)
+	}
+	return res
 }
 
 func String2Func(caller string) *Func {
@@ -63,10 +236,10 @@ func String2Func(caller string) *Func {
 
 // Func (*/Users/misery/GolandProjects/jupiter/pkg/core/sentinel.etcdv3DataSource).Initialize
 type Func struct {
-	FilePath   string
-	StructName string
-	FuncName   string
-	IsPointer  bool
+	FilePath   string `json:"path"`
+	StructName string `json:"struct,omitempty"`
+	FuncName   string `json:"func"`
+	IsPointer  bool   `json:"pointer,omitempty"`
 }
 
 func (n *Node) ToString() string {
@@ -78,45 +251,11 @@ func (f *Func) ToString() string {
 	if f.StructName != "" {
 		if f.IsPointer {
 			res += "(*" + f.FilePath + "." + f.StructName + ")." + f.FuncName
+		} else {
+			res += "(" + f.FilePath + "." + f.StructName + ")." + f.FuncName
 		}
-		res += "(" + f.FilePath + "." + f.StructName + ")." + f.FuncName
 	} else {
 		res += f.FilePath + "." + f.FuncName
 	}
 	return res
 }
-
-type Diff struct {
-	NodeA  *Node
-	NodeB  *Node
-	Detail *Detail
-}
-
-func (d *Diff) ToString() string {
-	var de Detail
-	if d.Detail == nil {
-		de = 0
-	} else {
-		de = *d.Detail
-	}
-	if d.NodeA == nil && d.NodeB != nil {
-		return "|" + d.NodeB.ToString() + "|" + string(rune(de))
-	} else if d.NodeA != nil && d.NodeB == nil {
-		return d.NodeA.ToString() + "|" + "|" + string(rune(de))
-	} else if d.NodeA == nil && d.NodeB == nil {
-		return ""
-	}
-	return d.NodeA.ToString() + "|" + d.NodeB.ToString() + "|" + string(rune(de))
-}
-
-func (d *Diff) PrintTrace() []string {
-	return []string{d.NodeA.Caller.FilePath, d.NodeA.Caller.FuncName, d.NodeA.Callee.FuncName}
-}
-
-type Detail int
-
-// Diff 定义图的不同
-var (
-	DescDiff     Detail = 1
-	SideLackDiff Detail = 2
-)
@@ -5,3 +5,39 @@ import "testing"
 func TestString2Func(t *testing.T) {
 	String2Func("github.com/douyu/jupiter/pkg/flag.(*FlagSet).Register -> ")
 }
+
+func TestStripPositionsAndDropCallees(t *testing.T) {
+	g := make(EdgeMap)
+	g.Add("example.com/demo.Run", "example.com/demo.work", "static function call", &CallSite{File: "a.go", Line: 3, Column: 2, Kind: CallStatic})
+	g.Add("example.com/demo.Run", "example.com/demo.work", "static function call", &CallSite{File: "a.go", Line: 9, Column: 2, Kind: CallStatic})
+	g.Add("example.com/demo.Run", "github.com/dataznGao/leo.SendStack", "static function call", &CallSite{File: "a.go", Line: 2, Kind: CallStatic})
+	g.Add("example.com/demo.init", "github.com/dataznGao/leo.SendStack", "static function call", &CallSite{File: "a.go", Line: 1, Kind: CallStatic})
+
+	g.StripPositions()
+	edge := g["example.com/demo.Run"]["example.com/demo.work"]
+	if len(edge.Sites) != 1 || *edge.Sites[0] != (CallSite{File: "a.go", Kind: CallStatic}) || edge.Count != 2 {
+		t.Errorf("positions should be stripped and sites merged without changing the count: %+v %v", edge.Sites, edge.Count)
+	}
+	g.DropCallees("github.com/dataznGao/leo")
+	if len(g) != 1 || len(g["example.com/demo.Run"]) != 1 {
+		t.Errorf("edges into the collector should be dropped: %v", g)
+	}
+}
+
+func TestUnionGraphs(t *testing.T) {
+	a, b := make(EdgeMap), make(EdgeMap)
+	a.Add("a.A", "a.B", "static function call", &CallSite{File: "a.go", Kind: CallStatic})
+	b.Add("a.A", "a.B", "static function call", &CallSite{File: "a.go", Kind: CallStatic})
+	b.Add("a.A", "a.B", "static function call", &CallSite{File: "b.go", Kind: CallStatic})
+	b.Add("a.A", "a.C", "static function call", nil)
+	u := UnionGraphs(a, b, nil)
+	if e := u["a.A"]["a.B"]; e == nil || len(e.Sites) != 2 || e.Count != 2 {
+		t.Errorf("A->B = %+v, want both sites and count 2", e)
+	}
+	if u["a.A"]["a.C"] == nil {
+		t.Errorf("union lost A->C: %v", u)
+	}
+	if len(a["a.A"]) != 1 {
+		t.Errorf("union changed its input: %v", a)
+	}
+}
@@ -0,0 +1,370 @@
+package callgraph
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/dataznGao/leo/util"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ExportNode 导出的函数节点
+type ExportNode struct {
+	ID       string     `json:"id"`
+	Package  string     `json:"package"`
+	Receiver string     `json:"receiver,omitempty"`
+	Pointer  bool       `json:"pointer,omitempty"`
+	Func     string     `json:"func"`
+	Status   EdgeStatus `json:"status,omitempty"`
+}
+
+// ExportEdge 导出的调用边
+type ExportEdge struct {
+	Source      string      `json:"source"`
+	Target      string      `json:"target"`
+	Description string      `json:"description"`
+	Kind        string      `json:"kind,omitempty"`
+	Position    string      `json:"position,omitempty"`
+	Sites       []*CallSite `json:"sites,omitempty"`
+	Count       int         `json:"count"`
+	Configs     []string    `json:"configs,omitempty"`
+	Status      EdgeStatus  `json:"status,omitempty"`
+}
+
+// ExportGraph node-link格式的调用图, 可以直接被networkx、d3等读取
+type ExportGraph struct {
+	Directed   bool              `json:"directed"`
+	Multigraph bool              `json:"multigraph"`
+	Graph      map[string]string `json:"graph"`
+	Nodes      []*ExportNode     `json:"nodes"`
+	Links      []*ExportEdge     `json:"links"`
+}
+
+func newExportGraph(name, kind string) *ExportGraph {
+	return &ExportGraph{
+		Directed: true,
+		Graph:    map[string]string{"name": name, "kind": kind},
+		Nodes:    make([]*ExportNode, 0),
+		Links:    make([]*ExportEdge, 0),
+	}
+}
+
+// NewExportGraph 导出单个调用图
+func NewExportGraph(name, kind string, g EdgeMap) *ExportGraph {
+	res := newExportGraph(name, kind)
+	nodes := make(map[string]bool)
+	for _, e := range sortedEdges(g) {
+		res.addEdge(nodes, e[0], e[1], g[e[0]][e[1]], "")
+	}
+	return res
+}
+
+// NewDiffExportGraph 导出原始调用图与故障调用图的并集, 每条边和节点都带上diffs中记录的变化状态
+func NewDiffExportGraph(name string, raw, faulty EdgeMap, diffs []*Diff, inputPath string) *ExportGraph {
+	res := newExportGraph(name, "diff")
+	status := diffEdges(raw, faulty, diffs, inputPath)
+	nodes := make(map[string]bool)
+	union := unionEdges(raw, faulty, status)
+	for _, e := range sortedEdges(union) {
+		res.addEdge(nodes, e[0], e[1], union[e[0]][e[1]], status[e[0]][e[1]])
+	}
+	nodeStatus := diffNodes(raw, faulty, diffs, inputPath)
+	for _, n := range res.Nodes {
+		n.Status = nodeStatus[n.ID]
+	}
+	return res
+}
+
+// nodes 返回调用图中出现过的所有函数
+func (m EdgeMap) nodes() map[string]bool {
+	res := make(map[string]bool)
+	for caller, callees := range m {
+		res[caller] = true
+		for callee := range callees {
+			res[callee] = true
+		}
+	}
+	return res
+}
+
+func (g *ExportGraph) addEdge(nodes map[string]bool, caller, callee string, edge *Edge, status EdgeStatus) {
+	for _, id := range []string{caller, callee} {
+		if nodes[id] {
+			continue
+		}
+		nodes[id] = true
+		fun := String2Func(id)
+		g.Nodes = append(g.Nodes, &ExportNode{
+			ID:       id,
+			Package:  fun.FilePath,
+			Receiver: fun.StructName,
+			Pointer:  fun.IsPointer,
+			Func:     fun.FuncName,
+		})
+	}
+	kinds := make([]string, 0)
+	for _, site := range edge.Sites {
+		if !util.Contains(string(site.Kind), kinds) {
+			kinds = append(kinds, string(site.Kind))
+		}
+	}
+	position := ""
+	if len(edge.Sites) > 0 {
+		site := edge.Sites[0]
+		position = fmt.Sprintf("%s:%d:%d", site.File, site.Line, site.Column)
+	}
+	g.Links = append(g.Links, &ExportEdge{
+		Source:      caller,
+		Target:      callee,
+		Description: edge.Description,
+		Kind:        strings.Join(kinds, ","),
+		Position:    position,
+		Sites:       edge.Sites,
+		Count:       edge.Count,
+		Configs:     edge.Configs,
+		Status:      status,
+	})
+}
+
+// WriteJSON 以node-link JSON格式写出
+func (g *ExportGraph) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+var graphMLKeys = []graphMLKey{
+	{"n_package", "node", "package", "string"},
+	{"n_receiver", "node", "receiver", "string"},
+	{"n_pointer", "node", "pointer", "boolean"},
+	{"n_func", "node", "func", "string"},
+	{"n_status", "node", "status", "string"},
+	{"e_description", "edge", "description", "string"},
+	{"e_kind", "edge", "kind", "string"},
+	{"e_position", "edge", "position", "string"},
+	{"e_count", "edge", "count", "int"},
+	{"e_configs", "edge", "configs", "string"},
+	{"e_status", "edge", "status", "string"},
+}
+
+// WriteGraphML 以GraphML格式写出, 可以被Gephi、yEd等读取
+func (g *ExportGraph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{
+			ID:          g.Graph["name"],
+			EdgeDefault: "directed",
+		},
+	}
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: n.ID,
+			Data: []graphMLData{
+				{"n_package", n.Package},
+				{"n_receiver", n.Receiver},
+				{"n_pointer", strconv.FormatBool(n.Pointer)},
+				{"n_func", n.Func},
+				{"n_status", string(n.Status)},
+			},
+		})
+	}
+	for i, e := range g.Links {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     "e" + strconv.Itoa(i),
+			Source: e.Source,
+			Target: e.Target,
+			Data: []graphMLData{
+				{"e_description", e.Description},
+				{"e_kind", e.Kind},
+				{"e_position", e.Position},
+				{"e_count", strconv.Itoa(e.Count)},
+				{"e_configs", strings.Join(e.Configs, ";")},
+				{"e_status", string(e.Status)},
+			},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+// WriteCSV 以neo4j-admin import的格式分别写出节点和边
+func (g *ExportGraph) WriteCSV(nodes, edges io.Writer) error {
+	nw := csv.NewWriter(nodes)
+	nw.Write([]string{"id:ID", "package", "receiver", "pointer:boolean", "func", "status", ":LABEL"})
+	for _, n := range g.Nodes {
+		nw.Write([]string{n.ID, n.Package, n.Receiver, strconv.FormatBool(n.Pointer), n.Func, string(n.Status), "Function"})
+	}
+	nw.Flush()
+	if err := nw.Error(); err != nil {
+		return err
+	}
+	ew := csv.NewWriter(edges)
+	ew.Write([]string{":START_ID", ":END_ID", ":TYPE", "description", "kind", "position", "count:int", "configs:string[]", "status"})
+	for _, e := range g.Links {
+		ew.Write([]string{e.Source, e.Target, "CALLS", e.Description, e.Kind, e.Position, strconv.Itoa(e.Count), strings.Join(e.Configs, ";"), string(e.Status)})
+	}
+	ew.Flush()
+	return ew.Error()
+}
+
+// Write 按format(graphml、json、csv)将调用图写到base开头的文件中
+func (g *ExportGraph) Write(base, format string) error {
+	create := func(path string, write func(io.Writer) error) error {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	switch format {
+	case "graphml":
+		return create(base+".graphml", g.WriteGraphML)
+	case "json":
+		return create(base+".json", g.WriteJSON)
+	case "csv":
+		return create(base+".nodes.csv", func(nodes io.Writer) error {
+			return create(base+".edges.csv", func(edges io.Writer) error {
+				return g.WriteCSV(nodes, edges)
+			})
+		})
+	}
+	return fmt.Errorf("invalid export format: %s", format)
+}
+
+// ExportFiles 在-exportdir下导出原始调用图、故障调用图以及二者的差异图, 未设置-exportdir时不导出。
+// diffs为两张图比对出的差异, inputPath为比对时的项目路径
+func ExportFiles(name string, raw, faulty EdgeMap, diffs []*Diff, inputPath string) error {
+	if *exportDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(*exportDir, os.ModePerm); err != nil {
+		return err
+	}
+	graphs := map[string]*ExportGraph{
+		"raw":    NewExportGraph(name, "raw", raw),
+		"faulty": NewExportGraph(name, "faulty", faulty),
+		"diff":   NewDiffExportGraph(name, raw, faulty, diffs, inputPath),
+	}
+	for suffix, g := range graphs {
+		base := filepath.Join(*exportDir, name+"."+suffix)
+		for _, format := range strings.Split(*exportFormat, ",") {
+			format = strings.TrimSpace(format)
+			if format == "" {
+				continue
+			}
+			if err := g.Write(base, format); err != nil {
+				return err
+			}
+		}
+		log.Printf("[leo] INFO 调用图已导出: %v.{%v}", base, *exportFormat)
+	}
+	return nil
+}
+
+// logSitesFile -exportdir下记录实际注入日志的差异的文件
+const logSitesFile = "sites.json"
+
+// ExportLogSites 在-exportdir下写出实际注入日志的差异(经过可注入、-cover以及-top、-perfunc的筛选),
+// 供serve-ui展示, 未设置-exportdir时不写
+func ExportLogSites(diffs []*Diff) error {
+	if *exportDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(*exportDir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(diffs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(*exportDir, logSitesFile), data, 0666)
+}
+
+// ReadExportGraph 读取WriteJSON导出的调用图
+func ReadExportGraph(path string) (*ExportGraph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g := new(ExportGraph)
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, fmt.Errorf("read %s: %v", path, err)
+	}
+	return g, nil
+}
+
+// EdgeMap 将导出的调用图还原成EdgeMap, 同时返回每条边的变化状态
+func (g *ExportGraph) EdgeMap() (EdgeMap, map[string]map[string]EdgeStatus) {
+	edges := make(EdgeMap)
+	status := make(map[string]map[string]EdgeStatus)
+	for _, e := range g.Links {
+		if _, ok := edges[e.Source]; !ok {
+			edges[e.Source] = make(map[string]*Edge)
+			status[e.Source] = make(map[string]EdgeStatus)
+		}
+		edges[e.Source][e.Target] = &Edge{
+			Description: e.Description,
+			Sites:       e.Sites,
+			Count:       e.Count,
+			Configs:     e.Configs,
+		}
+		status[e.Source][e.Target] = e.Status
+	}
+	return edges, status
+}
@@ -0,0 +1,63 @@
+package callgraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestNewDiffExportGraph(t *testing.T) {
+	raw := make(EdgeMap)
+	raw.Add("example.com/a.Main", "(*example.com/a.T).Gone", "static method call",
+		&CallSite{File: "a/main.go", Line: 7, Column: 2, Kind: CallDefer})
+	faulty := make(EdgeMap)
+	faulty.Add("example.com/a.Main", "example.com/a.New", "static function call", nil)
+
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/a\n\ngo 1.18\n"})
+	g := NewDiffExportGraph("a.static", raw, faulty, Compare(raw, faulty, dir, SourceStatic), dir)
+	var buf bytes.Buffer
+	if err := g.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ExportGraph
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[string]EdgeStatus)
+	for _, e := range decoded.Links {
+		status[e.Target] = e.Status
+		if e.Target == "(*example.com/a.T).Gone" && (e.Kind != "defer" || e.Position != "a/main.go:7:2" || e.Count != 1) {
+			t.Errorf("edge attributes lost: %+v", e)
+		}
+	}
+	if status["(*example.com/a.T).Gone"] != EdgeRemoved || status["example.com/a.New"] != EdgeAdded {
+		t.Errorf("wrong diff status: %v", status)
+	}
+	for _, n := range decoded.Nodes {
+		if n.ID == "(*example.com/a.T).Gone" && (n.Package != "example.com/a" || n.Receiver != "T" || !n.Pointer || n.Status != EdgeRemoved) {
+			t.Errorf("node attributes lost: %+v", n)
+		}
+	}
+
+	buf.Reset()
+	if err := g.WriteGraphML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 2 {
+		t.Errorf("graphml has %d nodes and %d edges", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+
+	var nodes, edges bytes.Buffer
+	if err := g.WriteCSV(&nodes, &edges); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(edges.String(), ":START_ID,:END_ID,:TYPE") || strings.Count(nodes.String(), "\n") != 4 {
+		t.Errorf("unexpected csv:\n%s\n%s", nodes.String(), edges.String())
+	}
+}
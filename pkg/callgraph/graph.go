@@ -0,0 +1,320 @@
+package callgraph
+
+import (
+	"sort"
+	"strings"
+)
+
+// Graph 可查询的调用图, 节点为函数的完整名称, 如example.com/a.F、(*example.com/a.T).M
+type Graph struct {
+	out   EdgeMap
+	in    EdgeMap
+	nodes []string
+	// tests 按go test的规则识别出的测试函数, 没有函数签名(如从导出的JSON读入)时为nil
+	tests map[string]bool
+}
+
+// NewGraph 由EdgeMap构建调用图, 之后对m的修改不会影响Graph
+func NewGraph(m EdgeMap) *Graph {
+	g := &Graph{
+		out: make(EdgeMap),
+		in:  make(EdgeMap),
+	}
+	add := func(m EdgeMap, a, b string, edge *Edge) {
+		if _, ok := m[a]; !ok {
+			m[a] = make(map[string]*Edge)
+		}
+		m[a][b] = edge
+	}
+	for caller, callees := range m {
+		for callee, edge := range callees {
+			add(g.out, caller, callee, edge)
+			add(g.in, callee, caller, edge)
+		}
+	}
+	for node := range m.nodes() {
+		g.nodes = append(g.nodes, node)
+	}
+	sort.Strings(g.nodes)
+	return g
+}
+
+// Nodes 按名称排序的所有函数
+func (g *Graph) Nodes() []string {
+	return g.nodes
+}
+
+// Edges 返回调用图的EdgeMap
+func (g *Graph) Edges() EdgeMap {
+	return g.out
+}
+
+// Edge 返回caller到callee的调用边, 不存在时返回nil
+func (g *Graph) Edge(caller, callee string) *Edge {
+	return g.out[caller][callee]
+}
+
+// Has 调用图中是否有fn
+func (g *Graph) Has(fn string) bool {
+	i := sort.SearchStrings(g.nodes, fn)
+	return i < len(g.nodes) && g.nodes[i] == fn
+}
+
+// Resolve 按名称查找函数: 完整名称直接命中, 否则返回以name结尾的函数, 如a.F、T).M、F
+func (g *Graph) Resolve(name string) []string {
+	if g.Has(name) {
+		return []string{name}
+	}
+	res := make([]string, 0)
+	for _, node := range g.nodes {
+		if !strings.HasSuffix(node, name) {
+			continue
+		}
+		if i := len(node) - len(name); i > 0 && strings.ContainsRune("/.(*", rune(node[i-1])) {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+func sortedKeys(m map[string]*Edge) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Callers fn的直接调用者
+func (g *Graph) Callers(fn string) []string {
+	return sortedKeys(g.in[fn])
+}
+
+// Callees fn直接调用的函数
+func (g *Graph) Callees(fn string) []string {
+	return sortedKeys(g.out[fn])
+}
+
+// TransitiveCallers 直接或间接调用fn的所有函数, 不包括fn本身(除非fn在环上)
+func (g *Graph) TransitiveCallers(fn string) []string {
+	return g.closure(g.in, fn)
+}
+
+// TransitiveCallees fn直接或间接调用的所有函数, 不包括fn本身(除非fn在环上)
+func (g *Graph) TransitiveCallees(fn string) []string {
+	return g.closure(g.out, fn)
+}
+
+func (g *Graph) closure(adj EdgeMap, fn string) []string {
+	seen := make(map[string]bool)
+	queue := []string{fn}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for next := range adj[cur] {
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	res := make([]string, 0, len(seen))
+	for node := range seen {
+		res = append(res, node)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Reachable from能否经由调用到达to, from==to时总是可达
+func (g *Graph) Reachable(from, to string) bool {
+	return from == to || g.ShortestPath(from, to) != nil
+}
+
+// ShortestPath from到to边数最少的调用链(包括两端), 不可达时返回nil
+func (g *Graph) ShortestPath(from, to string) []string {
+	if from == to {
+		return []string{from}
+	}
+	prev := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		// 按名称顺序扩展, 保证结果稳定
+		for _, next := range g.Callees(cur) {
+			if _, ok := prev[next]; ok {
+				continue
+			}
+			prev[next] = cur
+			if next == to {
+				path := []string{to}
+				for n := cur; n != ""; n = prev[n] {
+					path = append([]string{n}, path...)
+				}
+				return path
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// AllPaths from到to的所有简单调用链(包括两端), 每条链最多maxLen条边, 最多返回maxPaths条, 小于等于0表示不限制
+func (g *Graph) AllPaths(from, to string, maxLen, maxPaths int) [][]string {
+	if from == to {
+		return [][]string{{from}}
+	}
+	res := make([][]string, 0)
+	onPath := map[string]bool{from: true}
+	path := []string{from}
+	// dfs 返回true表示已经找够maxPaths条
+	var dfs func(cur string) bool
+	dfs = func(cur string) bool {
+		if maxLen > 0 && len(path) > maxLen {
+			return false
+		}
+		for _, next := range g.Callees(cur) {
+			if onPath[next] {
+				continue
+			}
+			if next == to {
+				res = append(res, append(append([]string(nil), path...), to))
+				if maxPaths > 0 && len(res) >= maxPaths {
+					return true
+				}
+				continue
+			}
+			onPath[next] = true
+			path = append(path, next)
+			stop := dfs(next)
+			path = path[:len(path)-1]
+			delete(onPath, next)
+			if stop {
+				return true
+			}
+		}
+		return false
+	}
+	dfs(from)
+	return res
+}
+
+// SCCs 调用图的强连通分量(Tarjan算法), 只返回有环的分量: 多于一个函数, 或者函数直接递归调用自己
+func (g *Graph) SCCs() [][]string {
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := make([]string, 0)
+	res := make([][]string, 0)
+	next := 0
+	var strongConnect func(v string)
+	strongConnect = func(v string) {
+		index[v] = next
+		low[v] = next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range g.Callees(v) {
+			if _, ok := index[w]; !ok {
+				strongConnect(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		scc := make([]string, 0)
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			scc = append(scc, w)
+			if w == v {
+				break
+			}
+		}
+		if len(scc) > 1 || g.out[v][v] != nil {
+			sort.Strings(scc)
+			res = append(res, scc)
+		}
+	}
+	for _, v := range g.nodes {
+		if _, ok := index[v]; !ok {
+			strongConnect(v)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i][0] < res[j][0]
+	})
+	return res
+}
+
+// Subgraph 只保留两端都满足keep的调用边
+func (g *Graph) Subgraph(keep func(fn string) bool) *Graph {
+	m := make(EdgeMap)
+	for caller, callees := range g.out {
+		if !keep(caller) {
+			continue
+		}
+		for callee, edge := range callees {
+			if !keep(callee) {
+				continue
+			}
+			if _, ok := m[caller]; !ok {
+				m[caller] = make(map[string]*Edge)
+			}
+			m[caller][callee] = edge
+		}
+	}
+	sub := NewGraph(m)
+	sub.tests = g.tests
+	return sub
+}
+
+// PackageSubgraph 只保留两端都在以prefixes之一开头的包中的调用边
+func (g *Graph) PackageSubgraph(prefixes ...string) *Graph {
+	return g.Subgraph(func(fn string) bool {
+		pkg := String2Func(fn).FilePath
+		for _, p := range prefixes {
+			if strings.HasPrefix(pkg, p) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Tests 直接或间接调用fn的测试函数(TestXxx、BenchmarkXxx、ExampleXxx、FuzzXxx)
+func (g *Graph) Tests(fn string) []string {
+	res := make([]string, 0)
+	for _, caller := range g.TransitiveCallers(fn) {
+		if g.isTest(caller) {
+			res = append(res, caller)
+		}
+	}
+	return res
+}
+
+// isTest fn是否是测试函数。有函数签名时按go test的规则判断; 否则只能按名字判断, 要求是包级函数,
+// 前缀后面不是小写字母
+func (g *Graph) isTest(fn string) bool {
+	if g.tests != nil {
+		return g.tests[fn]
+	}
+	f := String2Func(fn)
+	if f.StructName != "" || strings.Contains(f.FuncName, "$") {
+		return false
+	}
+	for _, prefix := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+		if isTestName(f.FuncName, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package callgraph
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+func queryGraphFixture() EdgeMap {
+	m := make(EdgeMap)
+	for _, e := range [][2]string{
+		{"example.com/a.TestA", "example.com/a.A"},
+		// 不是测试函数: 方法、前缀后是小写字母、闭包
+		{"(*example.com/a.suite).TestX", "example.com/a.A"},
+		{"example.com/a.Testdata", "example.com/a.A"},
+		{"example.com/a.TestA$1", "example.com/a.A"},
+		{"example.com/a.A", "example.com/a.B"},
+		{"example.com/a.A", "(*example.com/b.T).C"},
+		{"example.com/a.B", "(*example.com/b.T).C"},
+		{"(*example.com/b.T).C", "example.com/b.D"},
+		{"example.com/b.D", "(*example.com/b.T).C"},
+		{"example.com/b.E", "example.com/b.E"},
+	} {
+		m.Add(e[0], e[1], "static function call", nil)
+	}
+	return m
+}
+
+func TestGraphQueries(t *testing.T) {
+	g := NewGraph(queryGraphFixture())
+	check := func(name string, got, want interface{}) {
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	check("Callers", g.Callers("(*example.com/b.T).C"), []string{"example.com/a.A", "example.com/a.B", "example.com/b.D"})
+	check("Callees", g.Callees("example.com/a.A"), []string{"(*example.com/b.T).C", "example.com/a.B"})
+	check("TransitiveCallers", g.TransitiveCallers("example.com/a.B"), []string{"(*example.com/a.suite).TestX", "example.com/a.A", "example.com/a.TestA", "example.com/a.TestA$1", "example.com/a.Testdata"})
+	check("TransitiveCallees", g.TransitiveCallees("example.com/a.B"), []string{"(*example.com/b.T).C", "example.com/b.D"})
+	check("Reachable", g.Reachable("example.com/a.TestA", "example.com/b.D"), true)
+	check("Unreachable", g.Reachable("example.com/b.D", "example.com/a.A"), false)
+	check("ShortestPath", g.ShortestPath("example.com/a.TestA", "example.com/b.D"),
+		[]string{"example.com/a.TestA", "example.com/a.A", "(*example.com/b.T).C", "example.com/b.D"})
+	check("AllPaths", len(g.AllPaths("example.com/a.A", "example.com/b.D", 0, 0)), 2)
+	check("AllPaths maxLen", len(g.AllPaths("example.com/a.A", "example.com/b.D", 2, 0)), 1)
+	check("AllPaths maxPaths", len(g.AllPaths("example.com/a.A", "example.com/b.D", 0, 1)), 1)
+	check("SCCs", g.SCCs(), [][]string{{"(*example.com/b.T).C", "example.com/b.D"}, {"example.com/b.E"}})
+	check("Tests", g.Tests("example.com/b.D"), []string{"example.com/a.TestA"})
+	check("Resolve", g.Resolve("T).C"), []string{"(*example.com/b.T).C"})
+	check("Resolve short", g.Resolve("C"), []string{"(*example.com/b.T).C"})
+	check("PackageSubgraph", g.PackageSubgraph("example.com/b").Nodes(),
+		[]string{"(*example.com/b.T).C", "example.com/b.D", "example.com/b.E"})
+}
+
+func TestQueryExportedGraph(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "g")
+	if err := NewExportGraph("g", "raw", queryGraphFixture()).Write(file, "json"); err != nil {
+		t.Fatal(err)
+	}
+	defer func(graph string) { *queryGraph = graph }(*queryGraph)
+	*queryGraph = file + ".json"
+	var buf bytes.Buffer
+	if err := Query("", []string{"tests", "b.D"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "example.com/a.TestA" {
+		t.Errorf("query tests b.D = %q", got)
+	}
+	if err := Query("", []string{"path", "a.TestA"}, &buf); err == nil {
+		t.Errorf("query path with one function should fail")
+	}
+}
+
+const testFuncSrc = `package a
+
+import "testing"
+
+type suite struct{}
+
+func (s *suite) TestMethod(t *testing.T) {}
+
+func TestOK(t *testing.T) {}
+
+func Test(t *testing.T) {}
+
+func TestÄ(t *testing.T) {}
+
+func Testdata(t *testing.T) {}
+
+func TestInt(n int) {}
+
+func TestMain(m *testing.M) {}
+
+func BenchmarkOK(b *testing.B) {}
+
+func BenchmarkT(t *testing.T) {}
+
+func FuzzOK(f *testing.F) {}
+
+func ExampleOK() {}
+
+func ExampleArgs(t *testing.T) {}
+
+func TestResult(t *testing.T) error { return nil }
+`
+
+func TestIsTestFunc(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "a_test.go", testFuncSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 用只有T、B、F、M的testing代替标准库, x/tools v0.4.0的ssa处理不了新版本标准库中的类型别名
+	stub, err := parser.ParseFile(fset, "testing.go", "package testing\n\ntype T struct{}\ntype B struct{}\ntype F struct{}\ntype M struct{}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testingPkg, err := new(types.Config).Check("testing", fset, []*ast.File{stub}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &types.Config{Importer: importerFunc(func(string) (*types.Package, error) { return testingPkg, nil })}
+	pkg, _, err := ssautil.BuildPackage(conf, fset, types.NewPackage("example.com/a", "a"), []*ast.File{f}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, member := range pkg.Members {
+		if fn, ok := member.(*ssa.Function); ok && isTestFunc(fn) {
+			got = append(got, fn.Name())
+		}
+	}
+	if m := pkg.Prog.LookupMethod(types.NewPointer(pkg.Type("suite").Type()), nil, "TestMethod"); m != nil && isTestFunc(m) {
+		got = append(got, "TestMethod")
+	}
+	sort.Strings(got)
+	want := []string{"BenchmarkOK", "ExampleOK", "FuzzOK", "Test", "TestOK", "TestÄ"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("test functions = %v, want %v", got, want)
+	}
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
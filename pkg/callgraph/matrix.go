@@ -0,0 +1,146 @@
+package callgraph
+
+import (
+	"fmt"
+	"go/build"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// BuildConfig 一组构建配置: 目标平台以及额外的构建标签, GOOS、GOARCH为空时使用当前环境
+type BuildConfig struct {
+	GOOS   string
+	GOARCH string
+	Tags   []string
+}
+
+// ParseMatrix 解析-matrix, 配置之间用分号分隔, 每个配置为[GOOS/GOARCH][,tag...],
+// 如"linux/amd64;darwin/arm64;windows/amd64,integration;,e2e"
+func ParseMatrix(s string) ([]*BuildConfig, error) {
+	res := make([]*BuildConfig, 0)
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ",")
+		config := new(BuildConfig)
+		if platform := strings.TrimSpace(parts[0]); platform != "" {
+			i := strings.Index(platform, "/")
+			if i <= 0 || i == len(platform)-1 || strings.Count(platform, "/") != 1 {
+				return nil, fmt.Errorf("invalid build config %q: platform must be GOOS/GOARCH", item)
+			}
+			config.GOOS, config.GOARCH = platform[:i], platform[i+1:]
+		}
+		for _, tag := range parts[1:] {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				config.Tags = append(config.Tags, tag)
+			}
+		}
+		sort.Strings(config.Tags)
+		if name := config.Name(); !seen[name] {
+			seen[name] = true
+			res = append(res, config)
+		}
+	}
+	return res, nil
+}
+
+// matrixConfigs 返回-matrix中的构建配置, 没有设置时返回只有当前环境的一个配置
+func matrixConfigs() ([]*BuildConfig, error) {
+	configs, err := ParseMatrix(*matrixFlag)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return []*BuildConfig{nil}, nil
+	}
+	return configs, nil
+}
+
+// MatrixConfigs 返回-matrix中的构建配置, 没有设置时返回只有当前环境的一个nil配置
+func MatrixConfigs() ([]*BuildConfig, error) {
+	return matrixConfigs()
+}
+
+// LoadFlags 在该配置下用go/packages加载包时的BuildFlags和追加的环境变量, 包括-tags
+func (c *BuildConfig) LoadFlags() (buildFlags, env []string) {
+	if tags := c.buildTags(); len(tags) > 0 {
+		buildFlags = []string{"-tags=" + strings.Join(tags, ",")}
+	}
+	return buildFlags, c.env()
+}
+
+// Name 配置的名称, 与-matrix中的写法一致, 如linux/amd64,integration
+func (c *BuildConfig) Name() string {
+	name := ""
+	if c.GOOS != "" {
+		name = c.GOOS + "/" + c.GOARCH
+	}
+	if len(c.Tags) > 0 {
+		name += "," + strings.Join(c.Tags, ",")
+	}
+	return name
+}
+
+// buildTags 在-tags的基础上加上配置的标签
+func (c *BuildConfig) buildTags() []string {
+	tags := append([]string(nil), build.Default.BuildTags...)
+	if c != nil {
+		tags = append(tags, c.Tags...)
+	}
+	return tags
+}
+
+// env 加载包时需要的GOOS、GOARCH; 交叉编译时关闭cgo, 否则需要目标平台的C工具链
+func (c *BuildConfig) env() []string {
+	if c == nil || c.GOOS == "" {
+		return nil
+	}
+	env := []string{"GOOS=" + c.GOOS, "GOARCH=" + c.GOARCH}
+	if c.GOOS != runtime.GOOS || c.GOARCH != runtime.GOARCH {
+		env = append(env, "CGO_ENABLED=0")
+	}
+	return env
+}
+
+// MergeConfigs 合并各构建配置下的调用图, 每条边的Configs记录它出现在哪些配置中(按names的顺序)
+func MergeConfigs(names []string, graphs []EdgeMap) EdgeMap {
+	res := make(EdgeMap)
+	for i, g := range graphs {
+		for caller, m := range g {
+			for callee, edge := range m {
+				if _, ok := res[caller]; !ok {
+					res[caller] = make(map[string]*Edge)
+				}
+				merged, ok := res[caller][callee]
+				if !ok {
+					merged = &Edge{Description: edge.Description}
+					res[caller][callee] = merged
+				}
+				for _, site := range edge.Sites {
+					res.Add(caller, callee, edge.Description, site)
+				}
+				if len(edge.Sites) == 0 && edge.Count > merged.Count {
+					merged.Count = edge.Count
+				}
+				merged.Configs = append(merged.Configs, names[i])
+			}
+		}
+	}
+	return res
+}
+
+// mergeBuilds 没有设置-matrix时直接返回默认配置的调用图, 否则按配置合并
+func mergeBuilds(configs []*BuildConfig, graphs []EdgeMap) EdgeMap {
+	if len(configs) == 1 && configs[0] == nil {
+		return graphs[0]
+	}
+	names := make([]string, 0, len(configs))
+	for _, c := range configs {
+		names = append(names, c.Name())
+	}
+	return MergeConfigs(names, graphs)
+}
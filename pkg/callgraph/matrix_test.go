@@ -0,0 +1,108 @@
+package callgraph
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseMatrix(t *testing.T) {
+	configs, err := ParseMatrix(" linux/amd64 ; windows/amd64,e2e,integration;;,e2e; linux/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, c := range configs {
+		names = append(names, c.Name())
+	}
+	if want := []string{"linux/amd64", "windows/amd64,e2e,integration", ",e2e"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("configs = %q, want %q", names, want)
+	}
+	if env := configs[1].env(); !reflect.DeepEqual(env, []string{"GOOS=windows", "GOARCH=amd64", "CGO_ENABLED=0"}) {
+		t.Errorf("cross-compiling env = %v", env)
+	}
+	for _, bad := range []string{"linux", "linux/", "/amd64", "linux/amd64/v2"} {
+		if _, err := ParseMatrix(bad); err == nil {
+			t.Errorf("ParseMatrix(%q) should fail", bad)
+		}
+	}
+}
+
+func TestMergeConfigs(t *testing.T) {
+	site := func(line int) *CallSite { return &CallSite{File: "a.go", Line: line, Kind: CallStatic} }
+	linux, windows := make(EdgeMap), make(EdgeMap)
+	linux.Add("a.F", "a.G", "static function call", site(1))
+	linux.Add("a.F", "a.Linux", "static function call", site(2))
+	windows.Add("a.F", "a.G", "static function call", site(1))
+	windows.Add("a.F", "a.Windows", "static function call", site(3))
+	merged := MergeConfigs([]string{"linux/amd64", "windows/amd64"}, []EdgeMap{linux, windows})
+	for callee, want := range map[string][]string{
+		"a.G":       {"linux/amd64", "windows/amd64"},
+		"a.Linux":   {"linux/amd64"},
+		"a.Windows": {"windows/amd64"},
+	} {
+		if got := merged["a.F"][callee].Configs; !reflect.DeepEqual(got, want) {
+			t.Errorf("configs of a.F -> %v = %v, want %v", callee, got, want)
+		}
+	}
+	if g := merged["a.F"]["a.G"]; len(g.Sites) != 1 || g.Count != 1 {
+		t.Errorf("the same call site was merged twice: %+v", g)
+	}
+}
+
+var platformModule = map[string]string{
+	"go.mod": "module example.com/platform\n\ngo 1.18\n",
+	"p.go": `package platform
+
+func Run() { run() }
+`,
+	"p_linux.go": `package platform
+
+func run() { linuxOnly() }
+
+func linuxOnly() {}
+`,
+	"p_windows.go": `package platform
+
+func run() { windowsOnly() }
+
+func windowsOnly() {}
+`,
+	"p_test.go": `package platform
+
+import "testing"
+
+func TestRun(t *testing.T) { Run() }
+`,
+}
+
+func TestProgramMatrix(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, platformModule)
+	defer func(algo, matrix string) { *callgraphAlgo, *matrixFlag = algo, matrix }(*callgraphAlgo, *matrixFlag)
+	*callgraphAlgo = CallGraphTypeRta
+	*matrixFlag = "linux/amd64;windows/amd64"
+	prog, err := LoadProgram(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := prog.Anal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for callee, want := range map[string][]string{
+		"example.com/platform.linuxOnly":   {"linux/amd64"},
+		"example.com/platform.windowsOnly": {"windows/amd64"},
+	} {
+		edge := g["example.com/platform.run"][callee]
+		if edge == nil || !reflect.DeepEqual(edge.Configs, want) {
+			t.Errorf("run -> %v = %+v, want configs %v", callee, edge, want)
+		}
+	}
+	if edge := g["example.com/platform.Run"]["example.com/platform.run"]; edge == nil || len(edge.Configs) != 2 {
+		t.Errorf("Run -> run should appear in both configurations: %+v", edge)
+	}
+	if _, err := prog.Anal(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("a directory without tests should fail")
+	}
+}
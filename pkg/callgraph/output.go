@@ -1,10 +1,7 @@
 package callgraph
 
 import (
-	"fmt"
 	"go/build"
-	"go/types"
-	"path/filepath"
 	"strings"
 	"sync"
 
@@ -16,213 +13,193 @@ func isSynthetic(edge *callgraph.Edge) bool {
 	return edge.Caller.Func.Pkg == nil || edge.Callee.Func.Synthetic != ""
 }
 
+// stdCache 包路径是否属于标准库, build.Import代价很高, 每个包只查一次
+var stdCache sync.Map
+
 func inStd(node *callgraph.Node) bool {
-	pkg, _ := build.Import(node.Func.Pkg.Pkg.Path(), "", 0)
-	return pkg.Goroot
+	path := node.Func.Pkg.Pkg.Path()
+	if std, ok := stdCache.Load(path); ok {
+		return std.(bool)
+	}
+	pkg, _ := build.Import(path, "", 0)
+	std := pkg != nil && pkg.Goroot
+	stdCache.Store(path, std)
+	return std
 }
 
-func printOutput(
-	mu sync.Locker,
-	prog *ssa.Program,
-	mainPkg *ssa.Package,
-	cg *callgraph.Graph,
-	focusPkg *types.Package,
-	limitPaths,
-	ignorePaths,
-	includePaths []string,
-	groupBy []string,
-	nostd,
-	nointer bool,
-) (map[*Vertx]string, error) {
-
-	cluster := NewDotCluster("focus")
-	cluster.Attrs = dotAttrs{
-		"bgcolor":   "white",
-		"label":     "",
-		"labelloc":  "t",
-		"labeljust": "c",
-		"fontsize":  "18",
-	}
-	if focusPkg != nil {
-		cluster.Attrs["bgcolor"] = "#e6ecfa"
-		cluster.Attrs["label"] = focusPkg.Name()
-	}
-
-	edges := make(map[*Vertx]string)
-	mu.Lock()
-	cg.DeleteSyntheticNodes()
-	mu.Unlock()
-	logf("%d limit prefixes: %v", len(limitPaths), limitPaths)
-	logf("%d ignore prefixes: %v", len(ignorePaths), ignorePaths)
-	logf("%d include prefixes: %v", len(includePaths), includePaths)
-	logf("no std packages: %v", nostd)
-
-	var isFocused = func(edge *callgraph.Edge) bool {
-		caller := edge.Caller
-		callee := edge.Callee
-		if focusPkg != nil && (caller.Func.Pkg.Pkg.Path() == focusPkg.Path() || callee.Func.Pkg.Pkg.Path() == focusPkg.Path()) {
-			return true
-		}
-		fromFocused := false
-		toFocused := false
-		for _, e := range caller.In {
-			if !isSynthetic(e) && focusPkg != nil &&
-				e.Caller.Func.Pkg.Pkg.Path() == focusPkg.Path() {
-				fromFocused = true
-				break
-			}
-		}
-		for _, e := range callee.Out {
-			if !isSynthetic(e) && focusPkg != nil &&
-				e.Callee.Func.Pkg.Pkg.Path() == focusPkg.Path() {
-				toFocused = true
-				break
-			}
-		}
-		if fromFocused && toFocused {
-			logf("edge semi-focus: %s", edge)
-			return true
-		}
-		return false
+// callKind 根据调用指令区分静态调用、接口调用、闭包调用以及go、defer调用
+func callKind(edge *callgraph.Edge) CallKind {
+	switch edge.Site.(type) {
+	case *ssa.Go:
+		return CallGo
+	case *ssa.Defer:
+		return CallDefer
 	}
-
-	var inIncludes = func(node *callgraph.Node) bool {
-		pkgPath := node.Func.Pkg.Pkg.Path()
-		for _, p := range includePaths {
-			if strings.HasPrefix(pkgPath, p) {
-				return true
-			}
-		}
-		return false
+	common := edge.Site.Common()
+	if common.IsInvoke() {
+		return CallInterface
 	}
+	// 函数值调用以及对匿名函数的调用都视为闭包调用
+	callee := common.StaticCallee()
+	if callee == nil || callee.Parent() != nil {
+		return CallClosure
+	}
+	return CallStatic
+}
 
-	var inLimits = func(node *callgraph.Node) bool {
-		pkgPath := node.Func.Pkg.Pkg.Path()
-		for _, p := range limitPaths {
-			if strings.HasPrefix(pkgPath, p) {
+// pkgFilter 一个包在各个过滤条件下的判断结果
+type pkgFilter struct {
+	focused  bool
+	included bool
+	limited  bool
+	ignored  bool
+	std      bool
+}
+
+// edgeFilter 渲染时对调用边的过滤条件, 在遍历调用边之前一次性算好, 遍历时只读, 可以并发使用
+type edgeFilter struct {
+	nointer  bool
+	hasLimit bool
+	pkgs     map[*ssa.Package]*pkgFilter
+	// callers、callees 每个函数被哪些项目包直接调用、直接调用了哪些项目包
+	callers map[*callgraph.Node]map[string]bool
+	callees map[*callgraph.Node]map[string]bool
+}
+
+// newEdgeFilter focused判断包是否属于项目本身, 只保留与项目包相关的调用边
+func newEdgeFilter(cg *callgraph.Graph, focused func(path string) bool, opts *renderOpts) *edgeFilter {
+	logf("%d limit prefixes: %v", len(opts.limit), opts.limit)
+	logf("%d ignore prefixes: %v", len(opts.ignore), opts.ignore)
+	logf("%d include prefixes: %v", len(opts.include), opts.include)
+	logf("no std packages: %v", opts.nostd)
+
+	hasPrefix := func(path string, prefixes []string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(path, p) {
 				return true
 			}
 		}
 		return false
 	}
-
-	var inIgnores = func(node *callgraph.Node) bool {
-		pkgPath := node.Func.Pkg.Pkg.Path()
-		for _, p := range ignorePaths {
-			if strings.HasPrefix(pkgPath, p) {
-				return true
-			}
+	f := &edgeFilter{
+		nointer:  opts.nointer,
+		hasLimit: len(opts.limit) > 0,
+		pkgs:     make(map[*ssa.Package]*pkgFilter),
+		callers:  make(map[*callgraph.Node]map[string]bool),
+		callees:  make(map[*callgraph.Node]map[string]bool),
+	}
+	for fn, node := range cg.Nodes {
+		if fn == nil || fn.Pkg == nil || f.pkgs[fn.Pkg] != nil {
+			continue
+		}
+		path := fn.Pkg.Pkg.Path()
+		f.pkgs[fn.Pkg] = &pkgFilter{
+			focused:  focused(path),
+			included: hasPrefix(path, opts.include),
+			limited:  hasPrefix(path, opts.limit),
+			ignored:  hasPrefix(path, opts.ignore),
+			std:      opts.nostd && inStd(node),
 		}
-		return false
 	}
-
-	var isInter = func(edge *callgraph.Edge) bool {
-		//caller := edge.Caller
-		callee := edge.Callee
-		if callee.Func.Object() != nil && !callee.Func.Object().Exported() {
-			return true
+	mark := func(m map[*callgraph.Node]map[string]bool, node *callgraph.Node, path string) {
+		if _, ok := m[node]; !ok {
+			m[node] = make(map[string]bool)
 		}
-		return false
+		m[node][path] = true
 	}
-
-	count := 0
-	err := callgraph.GraphVisitEdges(cg, func(edge *callgraph.Edge) error {
-		count++
-
-		caller := edge.Caller
-		callee := edge.Callee
-
-		posCaller := prog.Fset.Position(caller.Func.Pos())
-		posEdge := prog.Fset.Position(edge.Pos())
-		//fileCaller := fmt.Sprintf("%s:%d", posCaller.Filename, posCaller.Line)
-		filenameCaller := filepath.Base(posCaller.Filename)
-
-		// omit synthetic calls
+	callgraph.GraphVisitEdges(cg, func(edge *callgraph.Edge) error {
 		if isSynthetic(edge) {
 			return nil
 		}
-
-		// focus specific pkg
-		if focusPkg != nil &&
-			!isFocused(edge) {
-			return nil
+		if f.pkg(edge.Callee).focused {
+			mark(f.callees, edge.Caller, edge.Callee.Func.Pkg.Pkg.Path())
 		}
-
-		// omit std
-		if nostd &&
-			(inStd(caller) || inStd(callee)) {
-			return nil
+		if f.pkg(edge.Caller).focused {
+			mark(f.callers, edge.Callee, edge.Caller.Func.Pkg.Pkg.Path())
 		}
+		return nil
+	})
+	return f
+}
 
-		// omit inter
-		if nointer && isInter(edge) {
-			return nil
-		}
+// pkg 返回函数所在包的判断结果, 没有包的函数什么条件都不满足
+func (f *edgeFilter) pkg(node *callgraph.Node) *pkgFilter {
+	if p, ok := f.pkgs[node.Func.Pkg]; ok {
+		return p
+	}
+	return &pkgFilter{}
+}
 
-		include := false
-		// include path prefixes
-		if len(includePaths) > 0 &&
-			(inIncludes(caller) || inIncludes(callee)) {
-			logf("include: %s -> %s", caller, callee)
-			include = true
+// isFocused 调用边的一端在项目包中, 或者是从某个项目包出发又回到同一个项目包的中间调用
+func (f *edgeFilter) isFocused(edge *callgraph.Edge) bool {
+	if f.pkg(edge.Caller).focused || f.pkg(edge.Callee).focused {
+		return true
+	}
+	for path := range f.callers[edge.Caller] {
+		if f.callees[edge.Callee][path] {
+			logf("edge semi-focus: %s", edge)
+			return true
 		}
+	}
+	return false
+}
 
-		if !include {
-			// limit path prefixes
-			if len(limitPaths) > 0 &&
-				(!inLimits(caller) || !inLimits(callee)) {
-				logf("NOT in limit: %s -> %s", caller, callee)
-				return nil
-			}
-
-			// ignore path prefixes
-			if len(ignorePaths) > 0 &&
-				(inIgnores(caller) || inIgnores(callee)) {
-				logf("IS ignored: %s -> %s", caller, callee)
-				return nil
-			}
-		}
+func (f *edgeFilter) keep(edge *callgraph.Edge) bool {
+	// omit synthetic calls
+	if isSynthetic(edge) {
+		return false
+	}
+	caller := f.pkg(edge.Caller)
+	callee := f.pkg(edge.Callee)
 
-		//var buf bytes.Buffer
-		//data, _ := json.MarshalIndent(caller.Func, "", " ")
-		//logf("call node: %s -> %s\n %v", caller, callee, string(data))
-		logf("call node: %s -> %s (%s -> %s) %v\n", caller.Func.Pkg, callee.Func.Pkg, caller, callee, filenameCaller)
+	// focus project packages
+	if !f.isFocused(edge) {
+		return false
+	}
 
-		// edges
-		attrs := make(dotAttrs)
+	// omit std
+	if caller.std || callee.std {
+		return false
+	}
 
-		// dynamic call
-		if edge.Site != nil && edge.Site.Common().StaticCallee() == nil {
-			attrs["style"] = "dashed"
-		}
+	// omit inter
+	if f.nointer && edge.Callee.Func.Object() != nil && !edge.Callee.Func.Object().Exported() {
+		return false
+	}
 
-		// go & defer calls
-
-		// use position in file where callee is called as tooltip for the edge
-		fileEdge := fmt.Sprintf(
-			"at %s:%d: calling [%s]",
-			filepath.Base(posEdge.Filename),
-			posEdge.Line,
-			edge.Callee.Func.String(),
-		)
-
-		// omit duplicate calls, except for tooltip enhancements
-		key := &Vertx{
-			Caller:      caller.Func.String(),
-			Description: edge.Description(),
-			Callee:      callee.Func.String(),
-		}
-		if _, ok := edges[key]; !ok {
-			attrs["tooltip"] = fileEdge
-			edges[key] = ""
-		}
+	// include path prefixes
+	if caller.included || callee.included {
+		logf("include: %s -> %s", edge.Caller, edge.Callee)
+		return true
+	}
 
-		return nil
-	})
-	if err != nil {
-		return nil, err
+	// limit path prefixes
+	if f.hasLimit && (!caller.limited || !callee.limited) {
+		logf("NOT in limit: %s -> %s", edge.Caller, edge.Callee)
+		return false
 	}
 
-	return edges, nil
+	// ignore path prefixes
+	if caller.ignored || callee.ignored {
+		logf("IS ignored: %s -> %s", edge.Caller, edge.Callee)
+		return false
+	}
+	return true
+}
 
+// newVertx 将调用边转成Vertx, 附带调用点的位置和调用方式
+func newVertx(prog *ssa.Program, edge *callgraph.Edge) *Vertx {
+	posEdge := prog.Fset.Position(edge.Pos())
+	logf("call node: %s -> %s (%s -> %s)\n", edge.Caller.Func.Pkg, edge.Callee.Func.Pkg, edge.Caller, edge.Callee)
+	return &Vertx{
+		Caller:      edge.Caller.Func.String(),
+		Description: edge.Description(),
+		Callee:      edge.Callee.Func.String(),
+		Site: &CallSite{
+			File:   posEdge.Filename,
+			Line:   posEdge.Line,
+			Column: posEdge.Column,
+			Kind:   callKind(edge),
+		},
+	}
 }
@@ -0,0 +1,163 @@
+package callgraph
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Program 整个项目只加载、分析、渲染一次的调用图, 每个测试文件夹的调用图按测试函数的可达性从中切出。
+// 设置了-matrix时每个构建配置各加载一次, 切出的调用图再按配置合并
+type Program struct {
+	inputPath string
+	builds    []*programBuild
+	edges     EdgeMap
+}
+
+// programBuild 一个构建配置下的全项目调用图
+type programBuild struct {
+	config *BuildConfig
+	anal   *analysis
+	edges  EdgeMap
+}
+
+// LoadProgram 加载inputPath下的所有包(包括测试), 生成整个项目的调用图
+func LoadProgram(inputPath string) (*Program, error) {
+	configs, err := matrixConfigs()
+	if err != nil {
+		return nil, err
+	}
+	p := &Program{inputPath: inputPath}
+	graphs := make([]EdgeMap, 0, len(configs))
+	for _, config := range configs {
+		b, err := loadBuild(inputPath, config)
+		if err != nil {
+			return nil, err
+		}
+		p.builds = append(p.builds, b)
+		graphs = append(graphs, b.edges)
+	}
+	p.edges = mergeBuilds(configs, graphs)
+	return p, nil
+}
+
+func loadBuild(inputPath string, config *BuildConfig) (*programBuild, error) {
+	anal, ws, cleanup, err := workspaceAnalysis(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	anal.config = config
+	if config != nil {
+		log.Printf("[leo] INFO 开始进行全项目调用图分析, module: %v, 构建配置: %v", ws.Paths(), config.Name())
+	} else {
+		log.Printf("[leo] INFO 开始进行全项目调用图分析, module: %v", ws.Paths())
+	}
+	vertxs, err := anal.draw(true, inputPath, ws.Patterns(), true)
+	if err != nil {
+		return nil, err
+	}
+	edges := make(EdgeMap)
+	for _, vertx := range vertxs {
+		edges.Add(vertx.Caller, vertx.Callee, vertx.Description, relSite(inputPath, vertx.Site))
+	}
+	return &programBuild{
+		config: config,
+		anal:   anal,
+		edges:  edges,
+	}, nil
+}
+
+// Anal 切出testPath文件夹中的测试能到达的调用图
+func (p *Program) Anal(testPath string) (EdgeMap, error) {
+	configs := make([]*BuildConfig, 0, len(p.builds))
+	graphs := make([]EdgeMap, 0, len(p.builds))
+	for _, b := range p.builds {
+		// 测试文件可能只在部分构建配置下参与编译
+		if g := b.slice(testPath); g != nil {
+			configs = append(configs, b.config)
+			graphs = append(graphs, g)
+		}
+	}
+	if len(graphs) == 0 {
+		return nil, fmt.Errorf("no test functions found in %s", testPath)
+	}
+	res := mergeBuilds(configs, graphs)
+	log.Printf("[leo] INFO 从全项目调用图中切出%v: %v个调用者", testPath, len(res))
+	return res, nil
+}
+
+// slice 切出testPath文件夹中的测试能到达的调用图, 没有测试函数时返回nil
+func (p *programBuild) slice(testPath string) EdgeMap {
+	roots := testFunctions(p.anal.prog, p.packagesIn(testPath))
+	if len(roots) == 0 {
+		return nil
+	}
+	// 经由testing包的函数值调用会到达别的文件夹的测试函数, 这些测试函数不算作可达
+	others := make(map[*ssa.Function]bool)
+	for _, fn := range testFunctions(p.anal.prog, p.anal.initial) {
+		others[fn] = true
+	}
+	for _, fn := range roots {
+		delete(others, fn)
+	}
+	reachable := p.reachable(roots, others)
+	res := make(EdgeMap)
+	for caller, m := range p.edges {
+		if !reachable[caller] {
+			continue
+		}
+		res[caller] = make(map[string]*Edge, len(m))
+		for callee, edge := range m {
+			res[caller][callee] = edge
+		}
+	}
+	return res
+}
+
+// packagesIn 返回源文件位于dir中的包, 包括测试变体和外部测试包
+func (p *programBuild) packagesIn(dir string) []*packages.Package {
+	dir = filepath.Clean(dir)
+	res := make([]*packages.Package, 0)
+	for _, pkg := range p.anal.initial {
+		for _, f := range pkg.GoFiles {
+			if filepath.Dir(f) == dir {
+				res = append(res, pkg)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// reachable 返回从roots出发、不经过blocked在调用图上能到达的所有函数
+func (p *programBuild) reachable(roots []*ssa.Function, blocked map[*ssa.Function]bool) map[string]bool {
+	seen := make(map[string]bool)
+	queue := make([]*ssa.Function, 0, len(roots))
+	visited := make(map[*ssa.Function]bool)
+	for _, fn := range roots {
+		if !visited[fn] {
+			visited[fn] = true
+			queue = append(queue, fn)
+		}
+	}
+	for len(queue) > 0 {
+		fn := queue[0]
+		queue = queue[1:]
+		seen[fn.String()] = true
+		node := p.anal.callgraph.Nodes[fn]
+		if node == nil {
+			continue
+		}
+		for _, out := range node.Out {
+			if callee := out.Callee.Func; !visited[callee] && !blocked[callee] {
+				visited[callee] = true
+				queue = append(queue, callee)
+			}
+		}
+	}
+	return seen
+}
@@ -0,0 +1,124 @@
+package callgraph
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+var twoPkgModule = map[string]string{
+	"go.mod": "module example.com/two\n\ngo 1.18\n",
+	"a/a.go": `package a
+
+func A() { helperA() }
+
+func helperA() {}
+`,
+	"a/a_test.go": `package a
+
+import "testing"
+
+func TestA(t *testing.T) { A() }
+`,
+	"b/b.go": `package b
+
+import "example.com/two/a"
+
+func B() { a.A(); helperB() }
+
+func helperB() {}
+`,
+	"b/b_test.go": `package b
+
+import "testing"
+
+func TestB(t *testing.T) { B() }
+`,
+}
+
+func TestProgramAnal(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, twoPkgModule)
+	for _, algo := range []string{CallGraphTypeRta, CallGraphTypeVta} {
+		*callgraphAlgo = algo
+		prog, err := LoadProgram(dir)
+		if err != nil {
+			t.Fatalf("%v: %v", algo, err)
+		}
+		a, err := prog.Anal(filepath.Join(dir, "a"))
+		if err != nil {
+			t.Fatalf("%v: %v", algo, err)
+		}
+		if a["example.com/two/a.A"]["example.com/two/a.helperA"] == nil {
+			t.Errorf("%v: view of a misses A -> helperA: %v", algo, a)
+		}
+		if _, ok := a["example.com/two/b.B"]; ok {
+			t.Errorf("%v: view of a contains b.B, which no test in a reaches", algo)
+		}
+		b, err := prog.Anal(filepath.Join(dir, "b"))
+		if err != nil {
+			t.Fatalf("%v: %v", algo, err)
+		}
+		for caller, callee := range map[string]string{
+			"example.com/two/b.B": "example.com/two/a.A",
+			"example.com/two/a.A": "example.com/two/a.helperA",
+		} {
+			if b[caller][callee] == nil {
+				t.Errorf("%v: view of b misses %v -> %v", algo, caller, callee)
+			}
+		}
+	}
+	*callgraphAlgo = CallGraphTypePointer
+}
+
+// multiModule 根目录没有go.mod, 两个module之间用replace互相引用
+var multiModule = map[string]string{
+	"a/go.mod": "module example.com/a\n\ngo 1.18\n",
+	"a/a.go": `package a
+
+func A() {}
+`,
+	"b/go.mod": "module example.com/b\n\ngo 1.18\n\nrequire example.com/a v0.0.0\n\nreplace example.com/a => ../a\n",
+	"b/b.go": `package b
+
+import "example.com/a"
+
+func B() { a.A() }
+`,
+	"b/b_test.go": `package b
+
+import "testing"
+
+func TestB(t *testing.T) { B() }
+`,
+}
+
+func TestProgramMultiModule(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, multiModule)
+	*callgraphAlgo = CallGraphTypeRta
+	defer func() { *callgraphAlgo = CallGraphTypePointer }()
+	prog, err := LoadProgram(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := prog.Anal(filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b["example.com/b.B"]["example.com/a.A"] == nil {
+		t.Errorf("view of b misses the cross-module edge B -> a.A: %v", b)
+	}
+	diffs := Compare(b, EdgeMap{}, dir, SourceStatic)
+	found := false
+	for _, d := range diffs {
+		if d.Kind == EdgeRemovedDiff && d.NodeA.Callee.FuncName == "A" {
+			found = true
+			if want := filepath.Join(dir, "a"); d.NodeA.Callee.FilePath != want {
+				t.Errorf("callee of the diff is in %v, want %v", d.NodeA.Callee.FilePath, want)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("diff misses B -> a.A: %v", diffs)
+	}
+}
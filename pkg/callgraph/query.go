@@ -0,0 +1,134 @@
+package callgraph
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Graph 整个项目(包括测试)的调用图, 测试函数按SSA中的函数签名识别
+func (p *Program) Graph() *Graph {
+	g := NewGraph(p.edges)
+	g.tests = make(map[string]bool)
+	for _, b := range p.builds {
+		for _, pkg := range b.anal.prog.AllPackages() {
+			for _, member := range pkg.Members {
+				if fn, ok := member.(*ssa.Function); ok && isTestFunc(fn) {
+					g.tests[fn.String()] = true
+				}
+			}
+		}
+	}
+	return g
+}
+
+// queryOps leo query支持的查询, 值为需要的函数个数
+var queryOps = map[string]int{
+	"nodes":       0,
+	"scc":         0,
+	"callers":     1,
+	"callees":     1,
+	"callers-all": 1,
+	"callees-all": 1,
+	"tests":       1,
+	"reach":       2,
+	"path":        2,
+	"paths":       2,
+}
+
+// QueryUsage leo query的用法
+const QueryUsage = `query ops:
+  nodes                 all functions
+  scc                   recursive call cycles (strongly connected components)
+  callers <fn>          direct callers of fn
+  callees <fn>          direct callees of fn
+  callers-all <fn>      transitive callers of fn
+  callees-all <fn>      transitive callees of fn
+  tests <fn>            test functions that can reach fn
+  reach <from> <to>     whether from can reach to
+  path <from> <to>      shortest call path from -> to
+  paths <from> <to>     all simple call paths, bounded by -maxlen and -maxpaths
+fn may be a full name like (*example.com/a.T).M or an unambiguous suffix like T).M or a.F
+`
+
+// Query 执行leo query <op> [fn...]: 有-querygraph时查询导出的JSON调用图, 否则分析inputPath下的整个项目
+func Query(inputPath string, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing query op\n%s", QueryUsage)
+	}
+	op, fns := args[0], args[1:]
+	want, ok := queryOps[op]
+	if !ok {
+		return fmt.Errorf("unknown query op %q\n%s", op, QueryUsage)
+	}
+	if len(fns) != want {
+		return fmt.Errorf("query %s needs %d function(s), got %d\n%s", op, want, len(fns), QueryUsage)
+	}
+
+	var g *Graph
+	if *queryGraph != "" {
+		exported, err := ReadExportGraph(*queryGraph)
+		if err != nil {
+			return err
+		}
+		edges, _ := exported.EdgeMap()
+		g = NewGraph(edges)
+	} else {
+		p, err := LoadProgram(inputPath)
+		if err != nil {
+			return err
+		}
+		g = p.Graph()
+	}
+	if *queryPkg != "" {
+		g = g.PackageSubgraph(strings.Split(*queryPkg, ",")...)
+	}
+	for i, fn := range fns {
+		found := g.Resolve(fn)
+		switch len(found) {
+		case 0:
+			return fmt.Errorf("function %q not found in the call graph", fn)
+		case 1:
+			fns[i] = found[0]
+		default:
+			return fmt.Errorf("function %q is ambiguous: %s", fn, strings.Join(found, ", "))
+		}
+	}
+
+	lines := func(res []string) {
+		for _, s := range res {
+			fmt.Fprintln(w, s)
+		}
+	}
+	switch op {
+	case "nodes":
+		lines(g.Nodes())
+	case "scc":
+		for _, scc := range g.SCCs() {
+			fmt.Fprintln(w, strings.Join(scc, " "))
+		}
+	case "callers":
+		lines(g.Callers(fns[0]))
+	case "callees":
+		lines(g.Callees(fns[0]))
+	case "callers-all":
+		lines(g.TransitiveCallers(fns[0]))
+	case "callees-all":
+		lines(g.TransitiveCallees(fns[0]))
+	case "tests":
+		lines(g.Tests(fns[0]))
+	case "reach":
+		fmt.Fprintln(w, g.Reachable(fns[0], fns[1]))
+	case "path":
+		if path := g.ShortestPath(fns[0], fns[1]); path != nil {
+			fmt.Fprintln(w, strings.Join(path, " -> "))
+		}
+	case "paths":
+		for _, path := range g.AllPaths(fns[0], fns[1], *queryMaxLen, *queryMaxPaths) {
+			fmt.Fprintln(w, strings.Join(path, " -> "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package callgraph
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Impact 一处差异受故障影响的程度, 用于给注入点打分
+type Impact struct {
+	// Faults 暴露该差异的故障种类及每种故障注入的变异个数。每种故障单独注入到一份代码中,
+	// 同一种故障的变异是一起注入的, 只能知道差异由哪种故障造成, 变异个数记该种故障的全部变异
+	Faults map[string]int `json:"faults,omitempty"`
+	// TestDirs 出现该差异的测试文件夹个数, 被越多测试文件夹暴露的差异越可能是故障造成的
+	TestDirs int `json:"testDirs"`
+	// FailedDirs 其中故障代码上有测试失败的测试文件夹个数。同一个测试文件夹中的差异都记一次,
+	// 只有同一处差异出现在多个测试文件夹中时才能区分差异
+	FailedDirs int `json:"failedDirs,omitempty"`
+	// Degree 差异所在函数(边差异为调用方)在调用图中直接调用和被调用的函数个数
+	Degree int `json:"degree,omitempty"`
+}
+
+// testsFailed go test -v的输出中是否有失败的测试
+func testsFailed(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "--- FAIL") || line == "FAIL" || strings.HasPrefix(line, "FAIL\t") {
+			return true
+		}
+	}
+	return false
+}
+
+// degrees 每个函数直接调用和被调用的函数个数, 键为Func.ToString()
+func degrees(graphs []EdgeMap, inputPath string) map[string]int {
+	ws, err := loadWorkspace(inputPath)
+	if err != nil {
+		log.Printf("[leo] WARN 无法识别%v中的module: %v", inputPath, err)
+	}
+	neighbours := make(map[string]map[string]bool)
+	link := func(a, b string) {
+		if neighbours[a] == nil {
+			neighbours[a] = make(map[string]bool)
+		}
+		neighbours[a][b] = true
+	}
+	for _, g := range graphs {
+		for caller, callees := range g {
+			for callee := range callees {
+				a, b := funcIn(ws, caller).ToString(), funcIn(ws, callee).ToString()
+				link(a, b)
+				link(b, a)
+			}
+		}
+	}
+	res := make(map[string]int, len(neighbours))
+	for fn, m := range neighbours {
+		res[fn] = len(m)
+	}
+	return res
+}
+
+// AnnotateFault 记录差异由故障种类fault造成, mutants为该种故障注入的变异个数
+func AnnotateFault(diffs []*Diff, fault string, mutants int) {
+	for _, d := range diffs {
+		d.Impact = &Impact{Faults: map[string]int{fault: mutants}}
+	}
+}
+
+// AnnotateImpact 为一个测试文件夹比对出的差异记录影响, graphs为该测试文件夹的调用图, failed为故障代码上测试是否失败。
+// AnnotateFault记录的故障种类保留
+func AnnotateImpact(diffs []*Diff, graphs []EdgeMap, failed bool, inputPath string) {
+	deg := degrees(graphs, inputPath)
+	for _, d := range diffs {
+		impact := &Impact{TestDirs: 1, Degree: deg[d.site().ToString()]}
+		if d.Impact != nil {
+			impact.Faults = d.Impact.Faults
+		}
+		if failed {
+			impact.FailedDirs = 1
+		}
+		d.Impact = impact
+	}
+}
+
+// FaultTypes 暴露该差异的故障种类个数
+func (i *Impact) FaultTypes() int {
+	return len(i.Faults)
+}
+
+// Mutants 暴露该差异的故障种类注入的变异总数
+func (i *Impact) Mutants() int {
+	n := 0
+	for _, m := range i.Faults {
+		n += m
+	}
+	return n
+}
+
+// merge 合并同一处差异在不同故障种类或不同测试文件夹中的影响
+func (i *Impact) merge(o *Impact) *Impact {
+	if i == nil {
+		return o
+	}
+	if o == nil {
+		return i
+	}
+	res := &Impact{TestDirs: i.TestDirs + o.TestDirs, FailedDirs: i.FailedDirs + o.FailedDirs, Degree: i.Degree}
+	if o.Degree > res.Degree {
+		res.Degree = o.Degree
+	}
+	if len(i.Faults)+len(o.Faults) > 0 {
+		// 同一种故障在各测试文件夹中是同一批变异, 不累加
+		res.Faults = make(map[string]int, len(i.Faults)+len(o.Faults))
+		for _, faults := range []map[string]int{i.Faults, o.Faults} {
+			for fault, n := range faults {
+				if old, ok := res.Faults[fault]; !ok || n > old {
+					res.Faults[fault] = n
+				}
+			}
+		}
+	}
+	return res
+}
+
+// site 差异所在的函数: 边差异为调用方, 节点差异为该函数
+func (d *Diff) site() *Func {
+	if node := d.Edge(); node != nil {
+		return node.Caller
+	}
+	if d.Func != nil {
+		return d.Func
+	}
+	return &Func{}
+}
+
+// frequency 差异对应的边在两张图中较大的次数
+func (d *Diff) frequency() int {
+	n := 0
+	for _, node := range []*Node{d.NodeA, d.NodeB} {
+		if node != nil && node.Count > n {
+			n = node.Count
+		}
+	}
+	return n
+}
+
+// Score 差异作为注入点的得分, 越高越值得打日志:
+// 暴露它的故障种类和变异越多、暴露它的测试文件夹越多、静态和动态调用图都支持、调用越频繁、
+// 所在函数与越多函数相连、暴露它的测试文件夹中测试失败的比例越高都会更高
+func (d *Diff) Score() float64 {
+	score := 0.0
+	switch d.Source {
+	case SourceBoth:
+		score += 2
+	case SourceStatic, SourceDynamic:
+		score += 1
+	}
+	score += math.Log2(1 + float64(d.frequency()))
+	if d.Impact != nil {
+		score += 2 * math.Log2(1+float64(d.Impact.FaultTypes()))
+		score += math.Log2(1 + float64(d.Impact.Mutants()))
+		score += math.Log2(1 + float64(d.Impact.TestDirs))
+		score += math.Log2(1 + float64(d.Impact.Degree))
+		if d.Impact.TestDirs > 0 {
+			score += 3 * float64(d.Impact.FailedDirs) / float64(d.Impact.TestDirs)
+		}
+	}
+	return score
+}
+
+// Rank 按得分从高到低排序, 得分相同时按Key排序
+func Rank(diffs []*Diff) []*Diff {
+	res := append([]*Diff(nil), diffs...)
+	sort.SliceStable(res, func(i, j int) bool {
+		si, sj := res[i].Score(), res[j].Score()
+		if si != sj {
+			return si > sj
+		}
+		return res[i].Key() < res[j].Key()
+	})
+	return res
+}
+
+// Budget 按得分保留注入点: 最多top个(0为不限), 每个函数最多perFunc个(0为不限), 返回的差异按得分排序
+func Budget(diffs []*Diff, top, perFunc int) []*Diff {
+	res := make([]*Diff, 0, len(diffs))
+	perSite := make(map[string]int)
+	for _, d := range Rank(diffs) {
+		if top > 0 && len(res) >= top {
+			break
+		}
+		site := d.site().ToString()
+		if perFunc > 0 && perSite[site] >= perFunc {
+			continue
+		}
+		perSite[site]++
+		res = append(res, d)
+	}
+	return res
+}
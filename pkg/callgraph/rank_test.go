@@ -0,0 +1,92 @@
+package callgraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func rankDiff(caller, callee string, source DiffSource, count int, impact *Impact) *Diff {
+	return &Diff{
+		Kind:   EdgeRemovedDiff,
+		Source: source,
+		NodeA: &Node{
+			Caller: String2Func(caller),
+			Callee: String2Func(callee),
+			Count:  count,
+		},
+		Impact: impact,
+	}
+}
+
+func TestRankAndBudget(t *testing.T) {
+	weak := rankDiff("example.com/r.A", "example.com/r.B", SourceDynamic, 1, &Impact{TestDirs: 1})
+	both := rankDiff("example.com/r.A", "example.com/r.C", SourceBoth, 1, &Impact{TestDirs: 1})
+	failing := rankDiff("example.com/r.A", "example.com/r.D", SourceDynamic, 1, &Impact{TestDirs: 1, FailedDirs: 1})
+	central := rankDiff("example.com/r.E", "example.com/r.F", SourceBoth, 8, &Impact{TestDirs: 3, FailedDirs: 1, Degree: 10})
+
+	keys := func(diffs []*Diff) []string {
+		res := make([]string, 0, len(diffs))
+		for _, d := range diffs {
+			res = append(res, d.Edge().Callee.FuncName)
+		}
+		return res
+	}
+	if got, want := keys(Rank([]*Diff{weak, both, failing, central})), []string{"F", "D", "C", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rank = %v, want %v", got, want)
+	}
+	if got, want := keys(Budget([]*Diff{weak, both, failing, central}, 2, 0)), []string{"F", "D"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Budget(top=2) = %v, want %v", got, want)
+	}
+	if got, want := keys(Budget([]*Diff{weak, both, failing, central}, 0, 1)), []string{"F", "D"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Budget(perFunc=1) = %v, want %v", got, want)
+	}
+	if got, want := keys(Budget([]*Diff{weak, both, failing, central}, 0, 2)), []string{"F", "D", "C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Budget(perFunc=2) = %v, want %v", got, want)
+	}
+}
+
+func TestMergeDiffsImpact(t *testing.T) {
+	a := rankDiff("example.com/r.A", "example.com/r.B", SourceStatic, 1, &Impact{Faults: map[string]int{"sync": 3}, TestDirs: 1, Degree: 2})
+	b := rankDiff("example.com/r.A", "example.com/r.B", SourceDynamic, 1, &Impact{Faults: map[string]int{"sync": 3, "null": 2}, TestDirs: 1, FailedDirs: 1, Degree: 3})
+	merged := MergeDiffs([]*Diff{a, b})
+	if len(merged) != 1 {
+		t.Fatalf("got %v diffs, want 1", len(merged))
+	}
+	want := &Impact{Faults: map[string]int{"sync": 3, "null": 2}, TestDirs: 2, FailedDirs: 1, Degree: 3}
+	if got := merged[0].Impact; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged impact = %+v, want %+v", got, want)
+	}
+	if got := merged[0].Impact; got.FaultTypes() != 2 || got.Mutants() != 5 {
+		t.Errorf("merged impact has %v fault types and %v mutants, want 2 and 5", got.FaultTypes(), got.Mutants())
+	}
+	if a.Impact.TestDirs != 1 || len(a.Impact.Faults) != 1 {
+		t.Errorf("merging changed the impact of its input: %+v", a.Impact)
+	}
+}
+
+func TestAnnotateKeepsFaults(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/r\n\ngo 1.18\n"})
+	d := rankDiff("example.com/r.A", "example.com/r.B", SourceStatic, 1, nil)
+	AnnotateFault([]*Diff{d}, "null", 4)
+	AnnotateImpact([]*Diff{d}, nil, true, dir)
+	want := &Impact{Faults: map[string]int{"null": 4}, TestDirs: 1, FailedDirs: 1}
+	if !reflect.DeepEqual(d.Impact, want) {
+		t.Errorf("impact = %+v, want %+v", d.Impact, want)
+	}
+
+	// 被更多故障种类暴露的差异排在前面
+	one := rankDiff("example.com/r.A", "example.com/r.C", SourceStatic, 1, &Impact{Faults: map[string]int{"sync": 2}, TestDirs: 1})
+	two := rankDiff("example.com/r.A", "example.com/r.D", SourceStatic, 1, &Impact{Faults: map[string]int{"sync": 2, "null": 1}, TestDirs: 1})
+	if got := Rank([]*Diff{one, two}); got[0] != two {
+		t.Errorf("a diff exposed by two fault types should rank first, got %v", got[0].Key())
+	}
+}
+
+func TestTestsFailed(t *testing.T) {
+	if testsFailed("=== RUN   TestA\n--- PASS: TestA (0.00s)\nPASS\nok  \texample.com/r\t0.1s\n") {
+		t.Error("passing output reported as failed")
+	}
+	if !testsFailed("=== RUN   TestA\n--- FAIL: TestA (0.00s)\nFAIL\nFAIL\texample.com/r\t0.1s\n") {
+		t.Error("failing output reported as passed")
+	}
+}
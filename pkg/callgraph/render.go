@@ -0,0 +1,335 @@
+package callgraph
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EdgeStatus 调用边在原始调用图与故障调用图之间的变化
+type EdgeStatus string
+
+const (
+	EdgeUnchanged EdgeStatus = "unchanged"
+	EdgeRemoved   EdgeStatus = "removed"
+	EdgeAdded     EdgeStatus = "added"
+	EdgeChanged   EdgeStatus = "changed"
+)
+
+var statusColor = map[EdgeStatus]string{
+	EdgeUnchanged: "#8c8c8c",
+	EdgeRemoved:   "#d62728",
+	EdgeAdded:     "#2ca02c",
+	EdgeChanged:   "#ff7f0e",
+}
+
+// edgeStatusOf 边差异的种类对应的边的变化
+var edgeStatusOf = map[DiffKind]EdgeStatus{
+	EdgeRemovedDiff:      EdgeRemoved,
+	EdgeAddedDiff:        EdgeAdded,
+	DescChangedDiff:      EdgeChanged,
+	FrequencyChangedDiff: EdgeChanged,
+}
+
+// nodeStatusOf 节点差异的种类对应的节点的变化
+var nodeStatusOf = map[DiffKind]EdgeStatus{
+	NodeRemovedDiff: EdgeRemoved,
+	NodeAddedDiff:   EdgeAdded,
+}
+
+// diffNamer 把调用图中的函数名换算成差异中的写法: 项目包记为它在inputPath下工作区中的目录
+func diffNamer(inputPath string) func(name string) string {
+	ws, _ := loadWorkspace(inputPath)
+	names := make(map[string]string)
+	return func(name string) string {
+		res, ok := names[name]
+		if !ok {
+			res = funcIn(ws, name).ToString()
+			names[name] = res
+		}
+		return res
+	}
+}
+
+// diffEdges 合并原始调用图与故障调用图, 按diffs中边差异的种类标记每条边的变化, 没有对应差异的边
+// (包括被基线去掉的差异)视为未变化。inputPath为比对时的项目路径, 用于把函数名对应到差异上
+func diffEdges(raw, faulty EdgeMap, diffs []*Diff, inputPath string) map[string]map[string]EdgeStatus {
+	changed := make(map[string]EdgeStatus)
+	for _, d := range diffs {
+		s, ok := edgeStatusOf[d.Kind]
+		if node := d.Edge(); ok && node != nil {
+			changed[node.Caller.ToString()+"|"+node.Callee.ToString()] = s
+		}
+	}
+	name := diffNamer(inputPath)
+	res := make(map[string]map[string]EdgeStatus)
+	for _, g := range []EdgeMap{raw, faulty} {
+		for caller, m := range g {
+			if _, ok := res[caller]; !ok {
+				res[caller] = make(map[string]EdgeStatus)
+			}
+			for callee := range m {
+				s, ok := changed[name(caller)+"|"+name(callee)]
+				if !ok {
+					s = EdgeUnchanged
+				}
+				res[caller][callee] = s
+			}
+		}
+	}
+	return res
+}
+
+// diffNodes 按diffs中节点差异的种类标记调用图中函数的变化, 只返回有变化的函数
+func diffNodes(raw, faulty EdgeMap, diffs []*Diff, inputPath string) map[string]EdgeStatus {
+	changed := make(map[string]EdgeStatus)
+	for _, d := range diffs {
+		if s, ok := nodeStatusOf[d.Kind]; ok && d.Func != nil {
+			changed[d.Func.ToString()] = s
+		}
+	}
+	name := diffNamer(inputPath)
+	res := make(map[string]EdgeStatus)
+	for _, g := range []EdgeMap{raw, faulty} {
+		for fn := range g.nodes() {
+			if s, ok := changed[name(fn)]; ok {
+				res[fn] = s
+			}
+		}
+	}
+	return res
+}
+
+// unionEdges 合并两张图, 只在故障图中的边取故障图中的属性, 其余取原始图中的属性
+func unionEdges(raw, faulty EdgeMap, status map[string]map[string]EdgeStatus) EdgeMap {
+	union := make(EdgeMap)
+	for caller, m := range status {
+		for callee := range m {
+			edge := raw[caller][callee]
+			if edge == nil {
+				edge = faulty[caller][callee]
+			}
+			if _, ok := union[caller]; !ok {
+				union[caller] = make(map[string]*Edge)
+			}
+			union[caller][callee] = edge
+		}
+	}
+	return union
+}
+
+// dotBuilder 将调用图转成按包(以及按类型)分簇的dotGraph
+type dotBuilder struct {
+	graph  *dotGraph
+	nodes  map[string]*dotNode
+	byType bool
+	url    func(focus string) string
+}
+
+func newDotBuilder(title string) *dotBuilder {
+	graph := newDotGraph(title)
+	graph.Cluster.Attrs = dotAttrs{
+		"bgcolor":   "white",
+		"label":     "",
+		"labelloc":  "t",
+		"labeljust": "c",
+		"fontsize":  "18",
+	}
+	return &dotBuilder{
+		graph:  graph,
+		nodes:  make(map[string]*dotNode),
+		byType: strings.Contains(*groupFlag, "type"),
+	}
+}
+
+func (b *dotBuilder) node(id string) *dotNode {
+	if n, ok := b.nodes[id]; ok {
+		return n
+	}
+	fun := String2Func(id)
+	label := fun.FuncName
+	if fun.StructName != "" {
+		if fun.IsPointer {
+			label = "(*" + fun.StructName + ")." + fun.FuncName
+		} else {
+			label = "(" + fun.StructName + ")." + fun.FuncName
+		}
+	}
+	n := &dotNode{
+		ID: id,
+		Attrs: dotAttrs{
+			"label":   label,
+			"tooltip": id,
+		},
+	}
+	if b.url != nil {
+		n.Attrs["URL"] = b.url(id)
+		n.Attrs["target"] = "_top"
+	}
+	cluster := b.cluster(b.graph.Cluster, fun.FilePath, filepath.Base(fun.FilePath), "#e6ecfa")
+	if b.byType && fun.StructName != "" {
+		cluster = b.cluster(cluster, fun.FilePath+"."+fun.StructName, fun.StructName, "lightsteelblue")
+	}
+	cluster.Nodes = append(cluster.Nodes, n)
+	b.nodes[id] = n
+	return n
+}
+
+func (b *dotBuilder) cluster(parent *dotCluster, key, label, color string) *dotCluster {
+	if c, ok := parent.Clusters[key]; ok {
+		return c
+	}
+	c := NewDotCluster(fmt.Sprintf("%s_%d", parent.ID, len(parent.Clusters)))
+	c.Attrs = dotAttrs{
+		"label":     label,
+		"tooltip":   key,
+		"style":     "filled,rounded",
+		"fillcolor": color,
+		"penwidth":  "0.8",
+	}
+	if b.url != nil {
+		c.Attrs["URL"] = b.url(key)
+		c.Attrs["target"] = "_top"
+	}
+	parent.Clusters[key] = c
+	return c
+}
+
+func (b *dotBuilder) edge(caller, callee string, edge *Edge, status EdgeStatus) {
+	attrs := dotAttrs{
+		"color": statusColor[status],
+	}
+	tooltip := []string{caller + " -> " + callee}
+	if status != EdgeUnchanged && status != "" {
+		attrs["penwidth"] = "2"
+		tooltip = append(tooltip, string(status))
+	}
+	if edge != nil {
+		if len(edge.Configs) > 0 {
+			tooltip = append(tooltip, "in "+strings.Join(edge.Configs, "; "))
+		}
+		for _, site := range edge.Sites {
+			tooltip = append(tooltip, fmt.Sprintf("at %s:%d: %s call", filepath.Base(site.File), site.Line, site.Kind))
+			switch site.Kind {
+			case CallInterface, CallClosure:
+				attrs["style"] = "dashed"
+			case CallGo:
+				attrs["arrowhead"] = "normalnoneodot"
+			case CallDefer:
+				attrs["arrowhead"] = "normalnoneodiamond"
+			}
+		}
+	}
+	attrs["tooltip"] = strings.Join(tooltip, "\n")
+	b.graph.Edges = append(b.graph.Edges, &dotEdge{
+		From:  b.node(caller),
+		To:    b.node(callee),
+		Attrs: attrs,
+	})
+}
+
+// sortedEdges 按caller、callee排序, 保证输出稳定
+func sortedEdges(g EdgeMap) [][2]string {
+	res := make([][2]string, 0)
+	for caller, m := range g {
+		for callee := range m {
+			res = append(res, [2]string{caller, callee})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i][0] != res[j][0] {
+			return res[i][0] < res[j][0]
+		}
+		return res[i][1] < res[j][1]
+	})
+	return res
+}
+
+// WriteGraphDot 将单个调用图以DOT格式写出
+func WriteGraphDot(w io.Writer, title string, g EdgeMap) error {
+	return writeDot(w, title, g, nil, nil)
+}
+
+// WriteDiffDot 将原始调用图与故障调用图的差异以DOT格式写出, 按diffs中的差异把删除、新增、
+// 描述或调用次数变化的边分别着色, 未变化的边只保留与变化节点相连的部分作为上下文
+func WriteDiffDot(w io.Writer, title string, raw, faulty EdgeMap, diffs []*Diff, inputPath string) error {
+	status := diffEdges(raw, faulty, diffs, inputPath)
+	return writeDot(w, title, unionEdges(raw, faulty, status), status, nil)
+}
+
+// writeDot 写出调用图, status不为nil时按变化状态着色, 并省略远离变化的未变化边;
+// url不为nil时为节点和包加上跳转链接
+func writeDot(w io.Writer, title string, g EdgeMap, status map[string]map[string]EdgeStatus, url func(focus string) string) error {
+	touched := make(map[string]bool)
+	for caller, m := range status {
+		for callee, s := range m {
+			if s != EdgeUnchanged {
+				touched[caller] = true
+				touched[callee] = true
+			}
+		}
+	}
+	b := newDotBuilder(title)
+	b.url = url
+	for _, e := range sortedEdges(g) {
+		s := status[e[0]][e[1]]
+		if status != nil && s == EdgeUnchanged && !touched[e[0]] && !touched[e[1]] {
+			continue
+		}
+		b.edge(e[0], e[1], g[e[0]][e[1]], s)
+	}
+	return b.graph.WriteDot(w)
+}
+
+// RenderDot 将write写出的内容保存为out.dot, 开启graphviz时再用本地dot程序生成-format格式的图片,
+// 返回最终生成的文件
+func RenderDot(out string, write func(io.Writer) error) (string, error) {
+	dotFile := out + ".dot"
+	if err := os.MkdirAll(filepath.Dir(dotFile), os.ModePerm); err != nil {
+		return "", err
+	}
+	f, err := os.Create(dotFile)
+	if err != nil {
+		return "", err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if !*graphvizFlag || *outputFormat == "dot" {
+		return dotFile, nil
+	}
+	return runDotToImage(dotFile, *outputFormat)
+}
+
+// RenderDiffFiles 在-dotdir下输出原始调用图、故障调用图以及二者的差异图, 未设置-dotdir时不输出。
+// diffs为两张图比对出的差异, inputPath为比对时的项目路径
+func RenderDiffFiles(name string, raw, faulty EdgeMap, diffs []*Diff, inputPath string) error {
+	if *dotDir == "" {
+		return nil
+	}
+	base := filepath.Join(*dotDir, name)
+	files := []struct {
+		suffix string
+		write  func(io.Writer) error
+	}{
+		{"raw", func(w io.Writer) error { return WriteGraphDot(w, name+" (raw)", raw) }},
+		{"faulty", func(w io.Writer) error { return WriteGraphDot(w, name+" (faulty)", faulty) }},
+		{"diff", func(w io.Writer) error { return WriteDiffDot(w, name+" (diff)", raw, faulty, diffs, inputPath) }},
+	}
+	for _, file := range files {
+		out, err := RenderDot(base+"."+file.suffix, file.write)
+		if err != nil {
+			return err
+		}
+		log.Printf("[leo] INFO 调用图已输出: %v", out)
+	}
+	return nil
+}
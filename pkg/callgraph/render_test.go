@@ -0,0 +1,53 @@
+package callgraph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiffDot(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/a\n\ngo 1.18\n"})
+	raw := make(EdgeMap)
+	raw.Add("example.com/a.Main", "example.com/a.Keep", "static function call", nil)
+	raw.Add("example.com/a.Main", "example.com/a.Often", "static function call", nil)
+	raw.Add("example.com/a.Main", "(*example.com/a/b.T).Gone", "static method call", nil)
+	raw.Add("example.com/a.Other", "example.com/a.Alone", "static function call", nil)
+	faulty := make(EdgeMap)
+	faulty.Add("example.com/a.Main", "example.com/a.Keep", "static function call", nil)
+	// 只有调用次数变化的边也要着色
+	faulty.Add("example.com/a.Main", "example.com/a.Often", "static function call", nil)
+	faulty.Add("example.com/a.Main", "example.com/a.Often", "static function call", nil)
+	faulty.Add("example.com/a.Main", "example.com/a.New", "static function call", nil)
+	faulty.Add("example.com/a.Other", "example.com/a.Alone", "static function call", nil)
+
+	var buf bytes.Buffer
+	diffs := Compare(raw, faulty, dir, SourceDynamic)
+	if err := WriteDiffDot(&buf, "diff", raw, faulty, diffs, dir); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, want := range []string{
+		`"example.com/a.Main" -> "(*example.com/a/b.T).Gone" [ color="` + statusColor[EdgeRemoved],
+		`"example.com/a.Main" -> "example.com/a.New" [ color="` + statusColor[EdgeAdded],
+		`"example.com/a.Main" -> "example.com/a.Often" [ color="` + statusColor[EdgeChanged],
+		`"example.com/a.Main" -> "example.com/a.Keep" [ color="` + statusColor[EdgeUnchanged],
+		`subgraph "cluster_focus_0"`,
+		`label="(*T).Gone"`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("missing %s in\n%s", want, dot)
+		}
+	}
+	if strings.Contains(dot, "a.Alone") {
+		t.Errorf("unchanged edge without changed neighbours should be left out:\n%s", dot)
+	}
+	// 被基线去掉的差异不再着色
+	buf.Reset()
+	if err := WriteDiffDot(&buf, "diff", raw, faulty, nil, dir); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), statusColor[EdgeChanged]) || strings.Contains(buf.String(), statusColor[EdgeRemoved]) {
+		t.Errorf("edges without a diff should be unchanged:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,513 @@
+package callgraph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dataznGao/leo/pkg/cache"
+)
+
+// uiGraph serve-ui中可浏览的一张调用图, 来自-exportdir下导出的JSON
+type uiGraph struct {
+	Name   string
+	Kind   string
+	edges  EdgeMap
+	status map[string]map[string]EdgeStatus
+	// hash 导出文件内容的哈希, 作为图片缓存键的一部分, 重新导出后不会用到旧的图片
+	hash string
+}
+
+// uiServer 浏览-exportdir中的原始、故障、差异调用图的本地HTTP服务
+type uiServer struct {
+	inputPath string
+	graphs    map[string]*uiGraph
+	names     []string
+	// logSites leo log实际注入日志的差异, 按差异中的"caller|callee"索引, 没有导出时为nil
+	logSites map[string][]*Diff
+	// 同一张图同一个focus的渲染会写同一个文件, 需要串行
+	renderMu sync.Mutex
+	outDir   string
+}
+
+// ServeUI 启动serve-ui, 浏览-exportdir下导出的调用图, inputPath为源码目录, 用于展示变化的边在原项目中的调用点。
+// 故障调用图的调用点没有行号, 只链接到文件。默认只监听本机, 页面可以读取inputPath下的源码
+func ServeUI(inputPath string) error {
+	if *exportDir == "" {
+		return fmt.Errorf("serve-ui needs -exportdir pointing at the exported call graphs")
+	}
+	graphs, err := loadUIGraphs(*exportDir)
+	if err != nil {
+		return err
+	}
+	if len(graphs) == 0 {
+		return fmt.Errorf("no exported call graph (*.raw.json, *.faulty.json, *.diff.json) found in %s", *exportDir)
+	}
+	logSites, err := loadLogSites(*exportDir)
+	if err != nil {
+		return err
+	}
+	if logSites == nil {
+		log.Printf("[leo] WARN %v下没有%v, 页面中不展示注入点, 用leo log -exportdir导出", *exportDir, logSitesFile)
+	}
+	outDir, err := os.MkdirTemp("", "leo-ui")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+	s := &uiServer{
+		inputPath: inputPath,
+		graphs:    graphs,
+		logSites:  logSites,
+		outDir:    outDir,
+	}
+	for name := range graphs {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+
+	ln, err := net.Listen("tcp", *httpFlag)
+	if err != nil {
+		return err
+	}
+	addr := "http://" + ln.Addr().String()
+	log.Printf("[leo] INFO serve-ui已启动: %v, 共%v张调用图", addr, len(graphs))
+	if !*skipBrowser {
+		go openBrowser(addr)
+	}
+	return http.Serve(ln, s.handler())
+}
+
+// uiGraphKinds ExportFiles导出的调用图的种类, -exportdir下的其他JSON(cover.json、基线、调用上下文树等)不是调用图
+var uiGraphKinds = []string{"raw", "faulty", "diff"}
+
+// loadUIGraphs 读取dir下ExportFiles导出的所有JSON调用图
+func loadUIGraphs(dir string) (map[string]*uiGraph, error) {
+	files := make([]string, 0)
+	for _, kind := range uiGraphKinds {
+		matches, err := filepath.Glob(filepath.Join(dir, "*."+kind+".json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	res := make(map[string]*uiGraph)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		g := new(ExportGraph)
+		if err := json.Unmarshal(data, g); err != nil {
+			return nil, fmt.Errorf("read %s: %v", file, err)
+		}
+		edges, status := g.EdgeMap()
+		kind := g.Graph["kind"]
+		if kind != "diff" {
+			status = nil
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		res[name] = &uiGraph{
+			Name:   name,
+			Kind:   kind,
+			edges:  edges,
+			status: status,
+			hash:   cache.Key(string(data)),
+		}
+	}
+	return res, nil
+}
+
+// loadLogSites 读取dir下ExportLogSites写出的注入点, 文件不存在时返回nil
+func loadLogSites(dir string) (map[string][]*Diff, error) {
+	data, err := os.ReadFile(filepath.Join(dir, logSitesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	diffs := make([]*Diff, 0)
+	if err := json.Unmarshal(data, &diffs); err != nil {
+		return nil, fmt.Errorf("read %s: %v", logSitesFile, err)
+	}
+	res := make(map[string][]*Diff)
+	for _, d := range diffs {
+		if node := d.Edge(); node != nil {
+			key := node.Caller.ToString() + "|" + node.Callee.ToString()
+			res[key] = append(res[key], d)
+		}
+	}
+	return res, nil
+}
+
+func (s *uiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveIndex)
+	mux.HandleFunc("/view", s.serveView)
+	mux.HandleFunc("/graph", s.serveGraph)
+	mux.HandleFunc("/source", s.serveSource)
+	return mux
+}
+
+// options 按请求参数生成渲染选项, 与命令行的-focus、-limit、-ignore、-include、-nointer含义相同
+func (s *uiServer) options(r *http.Request) (*analysis, error) {
+	a := new(analysis)
+	a.OptsSetup()
+	// -focus默认是main, 浏览时默认展示整张图
+	if a.opts.focus == "main" {
+		a.opts.focus = ""
+	}
+	a.OverrideByHTTP(r)
+	return a, a.ProcessListArgs()
+}
+
+func (s *uiServer) graph(r *http.Request) (*uiGraph, error) {
+	g, ok := s.graphs[r.FormValue("name")]
+	if !ok {
+		return nil, fmt.Errorf("unknown graph %q", r.FormValue("name"))
+	}
+	return g, nil
+}
+
+// filterEdges 按focus、limit、ignore、include、nointer过滤调用边, 同时过滤对应的变化状态
+func (a *analysis) filterEdges(g EdgeMap, status map[string]map[string]EdgeStatus) (EdgeMap, map[string]map[string]EdgeStatus) {
+	keep := func(id string) bool {
+		pkg := String2Func(id).FilePath
+		for _, p := range a.opts.include {
+			if strings.HasPrefix(pkg, p) {
+				return true
+			}
+		}
+		if len(a.opts.limit) > 0 {
+			limited := false
+			for _, p := range a.opts.limit {
+				if strings.HasPrefix(pkg, p) {
+					limited = true
+					break
+				}
+			}
+			if !limited {
+				return false
+			}
+		}
+		for _, p := range a.opts.ignore {
+			if strings.HasPrefix(pkg, p) {
+				return false
+			}
+		}
+		return true
+	}
+	focused := func(id string) bool {
+		if a.opts.focus == "" || id == a.opts.focus {
+			return true
+		}
+		pkg := String2Func(id).FilePath
+		return pkg == a.opts.focus || filepath.Base(pkg) == a.opts.focus
+	}
+	exported := func(id string) bool {
+		name := String2Func(id).FuncName
+		return name != "" && name[0] >= 'A' && name[0] <= 'Z'
+	}
+	edges := make(EdgeMap)
+	var res map[string]map[string]EdgeStatus
+	if status != nil {
+		res = make(map[string]map[string]EdgeStatus)
+	}
+	for caller, m := range g {
+		for callee, edge := range m {
+			if !keep(caller) || !keep(callee) || !(focused(caller) || focused(callee)) {
+				continue
+			}
+			if a.opts.nointer && (!exported(caller) || !exported(callee)) {
+				continue
+			}
+			if _, ok := edges[caller]; !ok {
+				edges[caller] = make(map[string]*Edge)
+			}
+			edges[caller][callee] = edge
+			if status != nil {
+				if _, ok := res[caller]; !ok {
+					res[caller] = make(map[string]EdgeStatus)
+				}
+				res[caller][callee] = status[caller][callee]
+			}
+		}
+	}
+	return edges, res
+}
+
+// query 返回保留name和过滤选项、替换focus后的请求参数
+func query(r *http.Request, focus string) string {
+	q := url.Values{}
+	for _, key := range []string{"name", "limit", "ignore", "include", "nointer", "group"} {
+		if v := r.FormValue(key); v != "" {
+			q.Set(key, v)
+		}
+	}
+	if focus != "" {
+		q.Set("f", focus)
+	}
+	return q.Encode()
+}
+
+func (s *uiServer) serveGraph(w http.ResponseWriter, r *http.Request) {
+	g, err := s.graph(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	a, err := s.options(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.renderMu.Lock()
+	defer s.renderMu.Unlock()
+	// 同一张图在不同的过滤条件下、重新导出后渲染出的图片不同, 都要体现在缓存键中
+	filters := fmt.Sprint(a.opts.limit, a.opts.ignore, a.opts.include, a.opts.nointer, a.opts.group)
+	key := g.Name + ".view." + cache.Key(g.hash, filters)[:16]
+	img := a.FindCachedImg(key)
+	if img == "" {
+		edges, status := a.filterEdges(g.edges, g.status)
+		link := func(focus string) string {
+			return "/view?" + query(r, focus)
+		}
+		focus := a.opts.focus
+		if focus == "" {
+			focus = "all"
+		}
+		out := filepath.Join(s.outDir, g.Name, url.PathEscape(focus))
+		img, err = RenderDot(out, func(w io.Writer) error {
+			return writeDot(w, g.Name, edges, status, link)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := a.CacheImg(key, img); err != nil {
+			log.Printf("[leo] WARN 缓存图片失败: %v", err)
+		}
+	}
+	if strings.HasSuffix(img, ".dot") {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	}
+	http.ServeFile(w, r, img)
+}
+
+// uiEdge 视图页面中列出的一条变化边
+type uiEdge struct {
+	Caller, Callee string
+	Status         EdgeStatus
+	Color          string
+	Sites          []*CallSite
+	// Logged leo log在这条边上注入了日志, LogSites为注入日志的调用点, 没有调用点时日志在调用方中按名字匹配的调用之后
+	Logged   bool
+	LogSites []*CallSite
+}
+
+func (s *uiServer) serveView(w http.ResponseWriter, r *http.Request) {
+	g, err := s.graph(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	a, err := s.options(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	edges, status := a.filterEdges(g.edges, g.status)
+	changed := make([]*uiEdge, 0)
+	name := diffNamer(s.inputPath)
+	for _, e := range sortedEdges(edges) {
+		st := status[e[0]][e[1]]
+		if st == "" || st == EdgeUnchanged {
+			continue
+		}
+		edge := &uiEdge{
+			Caller: e[0],
+			Callee: e[1],
+			Status: st,
+			Color:  statusColor[st],
+			Sites:  sitesIn(edges[e[0]][e[1]], s.inputPath),
+		}
+		for _, d := range s.logSites[name(e[0])+"|"+name(e[1])] {
+			edge.Logged = true
+			edge.LogSites = append(edge.LogSites, d.Edge().Sites...)
+		}
+		changed = append(changed, edge)
+	}
+	image := "/graph?" + query(r, r.FormValue("f"))
+	err = viewTmpl.Execute(w, map[string]interface{}{
+		"Graph":    g,
+		"Focus":    a.opts.focus,
+		"Limit":    r.FormValue("limit"),
+		"Ignore":   r.FormValue("ignore"),
+		"Include":  r.FormValue("include"),
+		"NoInter":  a.opts.nointer,
+		"Image":    image,
+		"Svg":      *graphvizFlag && *outputFormat == "svg",
+		"Img":      *graphvizFlag && *outputFormat != "svg" && *outputFormat != "dot",
+		"Changed":  changed,
+		"LogSites": s.logSites != nil,
+		"Edges":    len(sortedEdges(edges)),
+		"AllFocus": "/view?" + query(r, "all"),
+	})
+	if err != nil {
+		log.Printf("[leo] WARN 页面渲染失败: %v", err)
+	}
+}
+
+func (s *uiServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	graphs := make([]*uiGraph, 0, len(s.names))
+	for _, name := range s.names {
+		graphs = append(graphs, s.graphs[name])
+	}
+	if err := indexTmpl.Execute(w, graphs); err != nil {
+		log.Printf("[leo] WARN 页面渲染失败: %v", err)
+	}
+}
+
+// sourceLine 源码页中的一行
+type sourceLine struct {
+	No   int
+	Text string
+	Hit  bool
+}
+
+// serveSource 展示inputPath下的源码文件, 高亮line所在行, 不允许访问inputPath之外的文件
+func (s *uiServer) serveSource(w http.ResponseWriter, r *http.Request) {
+	file, err := s.sourcePath(r.FormValue("file"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	line, _ := strconv.Atoi(r.FormValue("line"))
+	f, err := os.Open(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	lines := make([]*sourceLine, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for no := 1; scanner.Scan(); no++ {
+		lines = append(lines, &sourceLine{No: no, Text: scanner.Text(), Hit: no == line})
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rel, _ := filepath.Rel(s.inputPath, file)
+	if err := sourceTmpl.Execute(w, map[string]interface{}{"File": rel, "Lines": lines}); err != nil {
+		log.Printf("[leo] WARN 页面渲染失败: %v", err)
+	}
+}
+
+// sourcePath 将file解析为inputPath下的绝对路径
+func (s *uiServer) sourcePath(file string) (string, error) {
+	root, err := filepath.Abs(s.inputPath)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(root, file)
+	}
+	file = filepath.Clean(file)
+	if rel, err := filepath.Rel(root, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of %s", file, root)
+	}
+	return file, nil
+}
+
+// openBrowser 用系统默认浏览器打开url
+func openBrowser(url string) {
+	var args []string
+	switch runtime.GOOS {
+	case "darwin":
+		args = []string{"open"}
+	case "windows":
+		args = []string{"cmd", "/c", "start"}
+	default:
+		args = []string{"xdg-open"}
+	}
+	cmd := exec.Command(args[0], append(args[1:], url)...)
+	if err := cmd.Start(); err != nil {
+		log.Printf("[leo] WARN 打开浏览器失败: %v, 请手动访问 %v", err, url)
+	}
+}
+
+const uiStyle = `<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; vertical-align: top; }
+.graph { width: 100%; border: 1px solid #ccc; }
+pre.src { font-size: 13px; }
+pre.src span.hit { background: #ffe58f; display: block; }
+pre.src span.no { color: #999; user-select: none; }
+</style>`
+
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>leo</title>` + uiStyle + `</head><body>
+<h2>leo call graphs</h2>
+<table>
+<tr><th>graph</th><th>kind</th></tr>
+{{range .}}<tr><td><a href="/view?name={{.Name}}">{{.Name}}</a></td><td>{{.Kind}}</td></tr>
+{{end}}</table>
+</body></html>`))
+
+var viewTmpl = template.Must(template.New("view").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Graph.Name}} - leo</title>` + uiStyle + `</head><body>
+<p><a href="/">all graphs</a> | <b>{{.Graph.Name}}</b> ({{.Graph.Kind}}, {{.Edges}} edges shown)</p>
+<form action="/view">
+<input type="hidden" name="name" value="{{.Graph.Name}}">
+focus <input name="f" value="{{.Focus}}" placeholder="package or function">
+limit <input name="limit" value="{{.Limit}}">
+ignore <input name="ignore" value="{{.Ignore}}">
+include <input name="include" value="{{.Include}}">
+<label><input type="checkbox" name="nointer" value="1"{{if .NoInter}} checked{{end}}> nointer</label>
+<input type="submit" value="show"> <a href="{{.AllFocus}}">whole graph</a>
+</form>
+{{if .Svg}}<object class="graph" type="image/svg+xml" data="{{.Image}}"></object>
+{{else if .Img}}<img class="graph" src="{{.Image}}">
+{{else}}<p><a href="{{.Image}}">DOT source</a> (run with -graphviz to render images)</p>{{end}}
+{{if .Changed}}<h3>changed edges</h3>
+<table>
+<tr><th>status</th><th>caller</th><th>callee</th><th>call sites</th><th>proposed log sites</th></tr>
+{{range .Changed}}<tr>
+<td style="color: {{.Color}}">{{.Status}}</td>
+<td><a href="/view?name={{$.Graph.Name}}&amp;f={{.Caller}}">{{.Caller}}</a></td>
+<td><a href="/view?name={{$.Graph.Name}}&amp;f={{.Callee}}">{{.Callee}}</a></td>
+<td>{{range .Sites}}{{template "site" .}}{{end}}</td>
+<td>{{if .Logged}}{{range .LogSites}}{{template "site" .}}{{else}}calls in {{.Caller}}{{end}}{{else}}-{{end}}</td>
+</tr>
+{{end}}</table>
+{{if not .LogSites}}<p>run leo log with -exportdir to list the proposed log sites</p>{{end}}{{end}}
+</body></html>
+{{define "site"}}{{if .Line}}<a href="/source?file={{.File}}&amp;line={{.Line}}#L{{.Line}}">{{.File}}:{{.Line}}</a>{{else}}<a href="/source?file={{.File}}">{{.File}}</a>{{end}} {{.Kind}}<br>{{end}}`))
+
+var sourceTmpl = template.Must(template.New("source").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.File}} - leo</title>` + uiStyle + `</head><body>
+<h3>{{.File}}</h3>
+<pre class="src">{{range .Lines}}<span id="L{{.No}}"{{if .Hit}} class="hit"{{end}}><span class="no">{{printf "%5d" .No}}</span>  {{.Text}}
+</span>{{end}}</pre>
+</body></html>`))
@@ -0,0 +1,102 @@
+package callgraph
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/pkg/cache"
+)
+
+func TestServeUI(t *testing.T) {
+	src := writeModule(t, map[string]string{
+		"go.mod": "module example.com/a\n\ngo 1.18\n",
+		"a.go":   "package a\n\nfunc Main() {\n\tNew()\n}\n",
+	})
+	raw := make(EdgeMap)
+	raw.Add("example.com/a.Main", "example.com/a.Keep", "static function call", nil)
+	raw.Add("example.com/b.Other", "example.com/b.Alone", "static function call", nil)
+	faulty := make(EdgeMap)
+	faulty.Add("example.com/a.Main", "example.com/a.Keep", "static function call", nil)
+	faulty.Add("example.com/a.Main", "example.com/a.New", "static function call",
+		&CallSite{File: "a.go", Line: 4, Column: 2, Kind: CallStatic})
+	faulty.Add("example.com/b.Other", "example.com/b.Alone", "static function call", nil)
+
+	dir := t.TempDir()
+	diffs := Compare(raw, faulty, src, SourceStatic)
+	if err := NewDiffExportGraph("t.static", raw, faulty, diffs, src).Write(filepath.Join(dir, "t.static.diff"), "json"); err != nil {
+		t.Fatal(err)
+	}
+	// -exportdir下其他不是调用图的JSON不影响serve-ui
+	os.WriteFile(filepath.Join(dir, "cover.json"), []byte("[]"), 0666)
+	os.WriteFile(filepath.Join(dir, "t.context.json"), []byte("{}"), 0666)
+	defer func(d string) { *exportDir = d }(*exportDir)
+	*exportDir = dir
+	logged := make([]*Diff, 0)
+	for _, d := range diffs {
+		if d.Kind == EdgeAddedDiff {
+			logged = append(logged, d)
+		}
+	}
+	if err := ExportLogSites(logged); err != nil {
+		t.Fatal(err)
+	}
+	graphs, err := loadUIGraphs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graphs) != 1 {
+		t.Fatalf("want only the exported graph, got %v", len(graphs))
+	}
+	logSites, err := loadLogSites(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(d string) { *cache.Dir = d }(*cache.Dir)
+	*cache.Dir = t.TempDir()
+	s := &uiServer{inputPath: src, graphs: graphs, names: []string{"t.static.diff"}, logSites: logSites, outDir: t.TempDir()}
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if _, body := get("/"); !strings.Contains(body, "t.static.diff") {
+		t.Errorf("index does not list the graph:\n%s", body)
+	}
+	_, body := get("/view?name=t.static.diff&f=example.com/a")
+	// 调用点和注入点各链接一次
+	if !strings.Contains(body, "example.com/a.New") || strings.Count(body, "line=4#L4") != 2 {
+		t.Errorf("view does not list the added edge with its call site and log site:\n%s", body)
+	}
+	_, body = get("/graph?name=t.static.diff&f=example.com/a")
+	if !strings.Contains(body, `URL="/view?f=example.com%2Fa.New`) || strings.Contains(body, "example.com/b") {
+		t.Errorf("graph is not focused on example.com/a or lacks node links:\n%s", body)
+	}
+	// 过滤条件不同时不能用缓存中的图片
+	if _, body = get("/graph?name=t.static.diff&f=example.com/a&nointer=1&ignore=example.com/a"); strings.Contains(body, "example.com/a.New") {
+		t.Errorf("a cached image was served for different filters:\n%s", body)
+	}
+	if _, body = get("/source?file=a.go&line=4"); !strings.Contains(body, `id="L4" class="hit"`) {
+		t.Errorf("source line is not highlighted:\n%s", body)
+	}
+	if host, _, err := net.SplitHostPort(*httpFlag); err != nil || host != "127.0.0.1" {
+		t.Errorf("serve-ui should listen on the loopback interface by default, got %q", *httpFlag)
+	}
+	outside := filepath.Join(filepath.Dir(src), "secret.go")
+	os.WriteFile(outside, []byte("package secret\n"), 0666)
+	if code, _ := get("/source?file=../secret.go"); code != http.StatusForbidden {
+		t.Errorf("file outside the input path served with status %d", code)
+	}
+}
@@ -0,0 +1,125 @@
+package callgraph
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// SkippedPackage -tolerant模式下因为有错误而没有分析的包
+type SkippedPackage struct {
+	Path string `json:"path"`
+	// Config 按-matrix分析时出错的构建配置
+	Config string   `json:"config,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+	// Cause 包本身没有错误、因为依赖的包有错误而被跳过时, 为出错的依赖
+	Cause string `json:"cause,omitempty"`
+}
+
+// dropBroken 去掉有错误的包以及直接或间接依赖它们的包, 返回剩下的包和被跳过的包。
+// 依赖有错误的包时类型信息不完整, 无法构建ssa, 只能一起跳过
+func dropBroken(initial []*packages.Package) ([]*packages.Package, []*SkippedPackage) {
+	broken := make(map[*packages.Package]*SkippedPackage)
+	seen := make(map[*packages.Package]bool)
+	var visit func(pkg *packages.Package)
+	visit = func(pkg *packages.Package) {
+		if seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		if len(pkg.Errors) > 0 {
+			skipped := &SkippedPackage{Path: pkg.PkgPath}
+			for _, err := range pkg.Errors {
+				skipped.Errors = append(skipped.Errors, err.Error())
+			}
+			broken[pkg] = skipped
+		}
+		paths := make([]string, 0, len(pkg.Imports))
+		for path := range pkg.Imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			imp := pkg.Imports[path]
+			visit(imp)
+			if cause, ok := broken[imp]; ok && broken[pkg] == nil {
+				if cause.Cause != "" {
+					broken[pkg] = &SkippedPackage{Path: pkg.PkgPath, Cause: cause.Cause}
+				} else {
+					broken[pkg] = &SkippedPackage{Path: pkg.PkgPath, Cause: cause.Path}
+				}
+			}
+		}
+		// 依赖的包有错误时go/packages也会把包标记为IllTyped, 上面没有找到原因时单独报告
+		if pkg.IllTyped && broken[pkg] == nil {
+			broken[pkg] = &SkippedPackage{Path: pkg.PkgPath}
+		}
+	}
+	res := make([]*packages.Package, 0, len(initial))
+	for _, pkg := range initial {
+		visit(pkg)
+		if broken[pkg] == nil {
+			res = append(res, pkg)
+		}
+	}
+
+	skipped := make([]*SkippedPackage, 0, len(broken))
+	for _, s := range broken {
+		skipped = append(skipped, s)
+	}
+	return res, dedupSkipped(skipped)
+}
+
+// dedupSkipped 测试变体与原包的导入路径相同, 每个导入路径只保留一条(优先保留带错误信息的), 按路径排序
+func dedupSkipped(skipped []*SkippedPackage) []*SkippedPackage {
+	byKey := make(map[string]*SkippedPackage)
+	for _, s := range skipped {
+		key := s.Config + "\x00" + s.Path
+		if old, ok := byKey[key]; !ok || len(old.Errors) == 0 && len(s.Errors) > 0 {
+			byKey[key] = s
+		}
+	}
+	res := make([]*SkippedPackage, 0, len(byKey))
+	for _, s := range byKey {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Config != res[j].Config {
+			return res[i].Config < res[j].Config
+		}
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
+
+// Skipped -tolerant模式下加载整个项目时跳过的包
+func (p *Program) Skipped() []*SkippedPackage {
+	res := make([]*SkippedPackage, 0)
+	for _, b := range p.builds {
+		for _, s := range b.anal.skipped {
+			if b.config != nil {
+				s.Config = b.config.Name()
+			}
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// ExportSkipped 在-exportdir下写出跳过的包的报告skipped.<kind>.json, 未设置-exportdir或没有跳过的包时不写
+func ExportSkipped(kind string, skipped []*SkippedPackage) error {
+	if *exportDir == "" || len(skipped) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(*exportDir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(skipped, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(*exportDir, "skipped."+kind+".json"), data, 0666)
+}
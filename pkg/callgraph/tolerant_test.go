@@ -0,0 +1,66 @@
+package callgraph
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+var brokenModule = map[string]string{
+	"go.mod": "module example.com/broken\n\ngo 1.18\n",
+	"a/a.go": `package a
+
+func A() { helper() }
+
+func helper() {}
+`,
+	"a/a_test.go": `package a
+
+import "testing"
+
+func TestA(t *testing.T) { A() }
+`,
+	"c/c.go": `package c
+
+func C() int { return "not an int" }
+`,
+	"b/b.go": `package b
+
+import "example.com/broken/c"
+
+func B() int { return c.C() }
+`,
+}
+
+func TestProgramTolerant(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeModule(t, brokenModule)
+	defer func(algo string, tolerant bool) { *callgraphAlgo, *tolerantFlag = algo, tolerant }(*callgraphAlgo, *tolerantFlag)
+	*callgraphAlgo = CallGraphTypeRta
+	*tolerantFlag = false
+	if _, err := LoadProgram(dir); err == nil {
+		t.Fatalf("loading a module with type errors should fail without -tolerant")
+	}
+
+	*tolerantFlag = true
+	prog, err := LoadProgram(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := prog.Anal(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a["example.com/broken/a.A"]["example.com/broken/a.helper"] == nil {
+		t.Errorf("healthy package a was not analysed: %v", a)
+	}
+	skipped := prog.Skipped()
+	if len(skipped) != 2 {
+		t.Fatalf("skipped = %+v, want b and c", skipped)
+	}
+	if b := skipped[0]; b.Path != "example.com/broken/b" || b.Cause != "example.com/broken/c" || len(b.Errors) != 0 {
+		t.Errorf("b should be skipped because of c: %+v", b)
+	}
+	if c := skipped[1]; c.Path != "example.com/broken/c" || c.Cause != "" || len(c.Errors) == 0 {
+		t.Errorf("c should be skipped with its type errors: %+v", c)
+	}
+}
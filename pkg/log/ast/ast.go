@@ -4,6 +4,8 @@ import (
 	"github.com/dataznGao/leo/pkg/callgraph"
 	"github.com/dataznGao/leo/util"
 	"go/ast"
+	"go/token"
+	"go/types"
 	"strings"
 )
 
@@ -29,22 +31,67 @@ func GenerateLog(content string) ast.Stmt {
 
 type File struct {
 	File   *ast.File
+	Fset   *token.FileSet
 	Logged bool
+	// Info 文件的类型信息, 类型检查失败时为nil
+	Info *types.Info
+}
+
+// inCallerFile 有调用点信息时按调用点所在的文件匹配, 否则按调用者所在的包路径前缀匹配
+func inCallerFile(filePath string, node *callgraph.Node) bool {
+	if len(node.Sites) == 0 {
+		return strings.HasPrefix(filePath, node.Caller.FilePath)
+	}
+	for _, site := range node.Sites {
+		if site.File == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+// injectNode 返回diff在原代码中对应的调用边, 不需要注入时返回nil。
+// 原调用图中的边消失或改变时直接使用原调用图中的边; 只在故障调用图中出现的边,
+// 其调用点的行号来自故障代码, 只保留文件和调用方式, 由函数名在原代码中找到对应的调用
+func injectNode(diff *callgraph.Diff) *callgraph.Node {
+	switch diff.Kind {
+	case callgraph.EdgeRemovedDiff, callgraph.DescChangedDiff, callgraph.PathRemovedDiff:
+		return diff.NodeA
+	case callgraph.EdgeAddedDiff, callgraph.PathAddedDiff:
+		if diff.NodeB == nil {
+			return nil
+		}
+		node := *diff.NodeB
+		node.Sites = make([]*callgraph.CallSite, 0, len(diff.NodeB.Sites))
+		for _, s := range diff.NodeB.Sites {
+			site := *s
+			site.Line, site.Column = 0, 0
+			node.Sites = append(node.Sites, &site)
+		}
+		return &node
+	}
+	return nil
+}
+
+// Injectable diff是否需要注入日志
+func Injectable(diff *callgraph.Diff) bool {
+	return injectNode(diff) != nil
 }
 
 func InjureLog(filePath string, file *File, diffs []*callgraph.Diff) ([]byte, bool) {
 	hasLogged := false
 	for _, diff := range diffs {
-		// 这种情况下，说明是原调用图存在该调用关系，可以进行注入
-		if diff.NodeA != nil {
-			if strings.HasPrefix(filePath, diff.NodeA.Caller.FilePath) {
+		if node := injectNode(diff); node != nil {
+			if inCallerFile(filePath, node) {
 				diffVisitor := &DiffVisitor{
 					diff: diff,
+					fset: file.Fset,
+					info: file.Info,
 				}
 				ast.Walk(diffVisitor, file.File)
 				hasLogged = *diffVisitor.HasLogged
 			}
 		}
 	}
-	return util.GetFileCode(file.File), hasLogged
+	return util.GetFileCode(file.Fset, file.File), hasLogged
 }
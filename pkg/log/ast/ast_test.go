@@ -0,0 +1,87 @@
+package _ast
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+)
+
+const siteSrc = `package demo
+
+func work() {}
+
+func Start() {
+	work()
+	go work()
+}
+`
+
+func TestInjureLogUsesCallSiteKind(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "/demo/start.go", siteSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := &callgraph.Diff{
+		NodeA: &callgraph.Node{
+			Caller: &callgraph.Func{FilePath: "/demo", FuncName: "Start"},
+			Callee: &callgraph.Func{FilePath: "/demo", FuncName: "work"},
+			Sites:  []*callgraph.CallSite{{File: "/demo/start.go", Line: 7, Column: 2, Kind: callgraph.CallGo}},
+		},
+		Kind: callgraph.EdgeRemovedDiff,
+	}
+	code, logged := InjureLog("/demo/start.go", &File{File: f, Fset: fset}, []*callgraph.Diff{diff})
+	if !logged {
+		t.Fatalf("no log injected:\n%s", code)
+	}
+	want := "go work()\n\tlog.Print(\"this is a log\")"
+	if !strings.Contains(string(code), want) || strings.Count(string(code), "log.Print") != 1 {
+		t.Fatalf("log should follow only the go statement:\n%s", code)
+	}
+}
+
+const addedSrc = `package demo
+
+func fetch() error { return nil }
+
+func fallback() {}
+
+func Load() {
+	if err := fetch(); err != nil {
+		fallback()
+	}
+}
+`
+
+func TestInjureLogForAddedEdge(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "/demo/load.go", addedSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := func(callee string) *callgraph.Diff {
+		return &callgraph.Diff{
+			Kind: callgraph.EdgeAddedDiff,
+			NodeB: &callgraph.Node{
+				Caller: &callgraph.Func{FilePath: "/demo", FuncName: "Load"},
+				Callee: &callgraph.Func{FilePath: "/demo", FuncName: callee},
+				// 行号来自故障代码, 与原代码不一致
+				Sites: []*callgraph.CallSite{{File: "/demo/load.go", Line: 42, Column: 3, Kind: callgraph.CallStatic}},
+			},
+		}
+	}
+	code, logged := InjureLog("/demo/load.go", &File{File: f, Fset: fset}, []*callgraph.Diff{added("injected")})
+	if logged || strings.Contains(string(code), "log.Print") {
+		t.Fatalf("a call that does not exist in the original source should not be logged:\n%s", code)
+	}
+	code, logged = InjureLog("/demo/load.go", &File{File: f, Fset: fset}, []*callgraph.Diff{added("fallback")})
+	if !logged {
+		t.Fatalf("no log injected for the added edge:\n%s", code)
+	}
+	if want := "fallback()\n\t\tlog.Print(\"this is a log\")"; !strings.Contains(string(code), want) {
+		t.Fatalf("log should follow the fallback call in the original source:\n%s", code)
+	}
+}
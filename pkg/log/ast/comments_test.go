@@ -0,0 +1,56 @@
+package _ast
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+)
+
+const commentsSrc = `//go:build linux
+
+// Package demo 演示
+package demo
+
+import "os"
+
+func open(name string) (*os.File, error) { return os.Open(name) }
+
+// Load 读取文件
+func Load(name string) error {
+	// 打开文件
+	f, err := open(name) // 可能失败
+	if err != nil {
+		return err
+	}
+
+	/* 关闭 */
+	return f.Close()
+}
+`
+
+func TestInjureLogKeepsComments(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "/demo/load.go", commentsSrc, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := &callgraph.Diff{
+		Kind: callgraph.EdgeRemovedDiff,
+		NodeA: &callgraph.Node{
+			Caller: &callgraph.Func{FilePath: "/demo", FuncName: "Load"},
+			Callee: &callgraph.Func{FilePath: "/demo", FuncName: "open"},
+		},
+	}
+	code, logged := InjureLog("/demo/load.go", &File{File: f, Fset: fset}, []*callgraph.Diff{diff})
+	if !logged {
+		t.Fatalf("no log injected:\n%s", code)
+	}
+	want := strings.Replace(commentsSrc, `import "os"`, "import (\n\t\"log\"\n\t\"os\"\n)", 1)
+	want = strings.Replace(want, "// 可能失败\n", "// 可能失败\n\tlog.Print(\"this is a log\")\n", 1)
+	if string(code) != want {
+		t.Errorf("only the log and its import should be added:\n%s", code)
+	}
+}
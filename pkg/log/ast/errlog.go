@@ -0,0 +1,107 @@
+package _ast
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+var errorType = types.Universe.Lookup("error").Type()
+
+// placeErrorLogs 对把被调用者返回的错误赋给变量的语句(v, err := callee()), 在检查该错误的if err != nil分支开头
+// 打印错误和被调用者, 没有检查时在语句之后补一个只打印日志的if err != nil。不增加return, 不改变控制流。
+// 处理过的块记入CanInjuredMap, 不再按位置注入。没有类型信息时不处理, 返回是否注入
+func placeErrorLogs(fun *ast.FuncDecl, m *calleeMatcher) bool {
+	if m.info == nil || fun.Body == nil {
+		return false
+	}
+	logged := false
+	positions := findCalleeStmts(fun, m)
+	for i := len(positions) - 1; i >= 0; i-- {
+		pos := positions[i]
+		stmt := pos.block.List[pos.index]
+		if ifStmt, ok := stmt.(*ast.IfStmt); ok {
+			// if v, err := callee(); err != nil {...}
+			name := m.errorVar(ifStmt.Init)
+			if name == "" || !isNilCheck(ifStmt.Cond, name) {
+				continue
+			}
+			insertLog(ifStmt.Body, 0, m.errorLogAt(name, ifStmt.Body.Lbrace+1))
+			CanInjuredMap[Item{Block: ifStmt.Body, Callee: m.node.Callee.FuncName}] = true
+		} else {
+			name := m.errorVar(stmt)
+			if name == "" {
+				continue
+			}
+			var next ast.Stmt
+			if pos.index+1 < len(pos.block.List) {
+				next = pos.block.List[pos.index+1]
+			}
+			if check, ok := next.(*ast.IfStmt); ok && check.Init == nil && isNilCheck(check.Cond, name) {
+				insertLog(check.Body, 0, m.errorLogAt(name, check.Body.Lbrace+1))
+			} else {
+				check := &ast.IfStmt{
+					Cond: &ast.BinaryExpr{X: ast.NewIdent(name), Op: token.NEQ, Y: ast.NewIdent("nil")},
+					Body: &ast.BlockStmt{List: []ast.Stmt{m.generateErrorLog(name, stmt.End())}},
+				}
+				pos.block.List = append(pos.block.List[:pos.index+1], append([]ast.Stmt{check}, pos.block.List[pos.index+1:]...)...)
+			}
+		}
+		CanInjuredMap[Item{Block: pos.block, Callee: m.node.Callee.FuncName}] = true
+		logged = true
+	}
+	return logged
+}
+
+// errorVar stmt是把被调用者的返回值赋给变量的语句时, 返回其中类型为error的变量名, 否则返回空字符串
+func (m *calleeMatcher) errorVar(stmt ast.Stmt) string {
+	assign, ok := stmt.(*ast.AssignStmt)
+	if !ok || len(assign.Rhs) != 1 || assign.Tok != token.DEFINE && assign.Tok != token.ASSIGN {
+		return ""
+	}
+	rhs := assign.Rhs[0]
+	for paren, ok := rhs.(*ast.ParenExpr); ok; paren, ok = rhs.(*ast.ParenExpr) {
+		rhs = paren.X
+	}
+	call, ok := rhs.(*ast.CallExpr)
+	if !ok || !m.match(call, "") {
+		return ""
+	}
+	for _, lhs := range assign.Lhs {
+		ident, ok := lhs.(*ast.Ident)
+		if !ok || ident.Name == "_" {
+			continue
+		}
+		if obj := m.info.ObjectOf(ident); obj != nil && types.Identical(obj.Type(), errorType) {
+			return ident.Name
+		}
+	}
+	return ""
+}
+
+// isNilCheck 条件是否为name != nil
+func isNilCheck(cond ast.Expr, name string) bool {
+	bin, ok := cond.(*ast.BinaryExpr)
+	if !ok || bin.Op != token.NEQ {
+		return false
+	}
+	x, ok := bin.X.(*ast.Ident)
+	if !ok || x.Name != name {
+		return false
+	}
+	y, ok := bin.Y.(*ast.Ident)
+	return ok && y.Name == "nil"
+}
+
+func (m *calleeMatcher) errorLogAt(name string, pos token.Pos) func() ast.Stmt {
+	return func() ast.Stmt { return m.generateErrorLog(name, pos) }
+}
+
+// generateErrorLog 打印被调用者和它返回的错误: log.Print("this is a log", " callee returned error: ", err),
+// 开启了LogValues时再附上pos处可见的值
+func (m *calleeMatcher) generateErrorLog(name string, pos token.Pos) ast.Stmt {
+	return m.generateLog(pos,
+		&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(" " + m.node.Callee.FuncName + " returned error: ")},
+		ast.NewIdent(name))
+}
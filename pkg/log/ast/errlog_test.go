@@ -0,0 +1,83 @@
+package _ast
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+)
+
+const errSrc = `package demo
+
+func fetch() (int, error) { return 0, nil }
+
+func Checked() error {
+	v, err := fetch()
+	if err != nil {
+		return err
+	}
+	println(v)
+	return nil
+}
+
+func Inline() error {
+	if _, err := fetch(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func Unchecked() error {
+	_, err := fetch()
+	return err
+}
+`
+
+func checkTypes(t *testing.T, fset *token.FileSet, f *ast.File) *types.Info {
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object), Uses: make(map[*ast.Ident]types.Object)}
+	if _, err := new(types.Config).Check("demo", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestInjureErrorLog(t *testing.T) {
+	tests := []struct {
+		caller string
+		want   string
+	}{
+		{"Checked", "if err != nil {\n\t\tlog.Print(\"this is a log\", \" fetch returned error: \", err)\n\t\treturn err"},
+		{"Inline", "err != nil {\n\t\tlog.Print(\"this is a log\", \" fetch returned error: \", err)\n\t\treturn err"},
+		// 没有检查错误时补一个只打印日志的if, 原来的return不变
+		{"Unchecked", "_, err := fetch()\n\tif err != nil {\n\t\tlog.Print(\"this is a log\", \" fetch returned error: \", err)\n\t}\n\treturn err"},
+	}
+	for _, tt := range tests {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "/demo/err.go", errSrc, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diff := &callgraph.Diff{
+			Kind: callgraph.EdgeRemovedDiff,
+			NodeA: &callgraph.Node{
+				Caller: &callgraph.Func{FilePath: "/demo", FuncName: tt.caller},
+				Callee: &callgraph.Func{FilePath: "/demo", FuncName: "fetch"},
+			},
+		}
+		file := &File{File: f, Fset: fset, Info: checkTypes(t, fset, f)}
+		code, logged := InjureLog("/demo/err.go", file, []*callgraph.Diff{diff})
+		if !logged || !strings.Contains(string(code), tt.want) {
+			t.Errorf("%v: want %q in\n%s", tt.caller, tt.want, code)
+		}
+		if n := strings.Count(string(code), "log.Print"); n != 1 {
+			t.Errorf("%v: got %v logs, want 1:\n%s", tt.caller, n, code)
+		}
+		if n := strings.Count(string(code), "return "); n != strings.Count(errSrc, "return ") {
+			t.Errorf("%v: the injected log changed the return paths:\n%s", tt.caller, code)
+		}
+	}
+}
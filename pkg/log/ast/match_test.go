@@ -0,0 +1,101 @@
+package _ast
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+)
+
+const matchSrc = `package demo
+
+type file struct{}
+
+func (f *file) Close() error { return nil }
+
+type conn struct{}
+
+func (c conn) Close() error { return nil }
+
+type closer interface{ Close() error }
+
+type job struct{}
+
+func (job) Close() {}
+
+func fetch() {}
+
+func Run(f *file, c conn) {
+	f.Close()
+	c.Close()
+}
+
+func Use(x closer) {
+	x.Close()
+}
+
+func Hook(fetch func()) {
+	fetch()
+}
+`
+
+func TestTypedCalleeMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		callee *callgraph.Func
+		desc   string
+		typed  bool
+		want   []string
+	}{
+		// 接收者类型不同的同名方法不匹配
+		{"receiver", "Run", &callgraph.Func{FilePath: "/demo", StructName: "conn", FuncName: "Close"}, "", true,
+			[]string{"c.Close()\n\tlog.Print"}},
+		{"package", "Run", &callgraph.Func{FilePath: "/other", StructName: "conn", FuncName: "Close"}, "", true, nil},
+		// 通过接口的调用看不出实际的接收者, 接收者类型实现了接口时匹配
+		{"interface", "Use", &callgraph.Func{FilePath: "/demo", StructName: "conn", FuncName: "Close"}, "", true,
+			[]string{"x.Close()\n\tlog.Print"}},
+		{"not implemented", "Use", &callgraph.Func{FilePath: "/demo", StructName: "job", FuncName: "Close"}, "", true, nil},
+		// 同名的函数类型参数不是被调用的函数
+		{"variable", "Hook", &callgraph.Func{FilePath: "/demo", FuncName: "fetch"}, "static function call", true, nil},
+		// 动态调用时函数类型的变量可能指向被调用者, 只比对调用点
+		{"dynamic", "Hook", &callgraph.Func{FilePath: "/demo", FuncName: "upper"}, "dynamic function call", true,
+			[]string{"fetch()\n\tlog.Print"}},
+		// 没有类型信息时按名字匹配
+		{"untyped", "Run", &callgraph.Func{FilePath: "/demo", StructName: "conn", FuncName: "Close"}, "", false,
+			[]string{"f.Close()\n\tlog.Print"}},
+	}
+	for _, tt := range tests {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "/demo/match.go", matchSrc, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := &File{File: f, Fset: fset}
+		if tt.typed {
+			file.Info = checkTypes(t, fset, f)
+		}
+		diff := &callgraph.Diff{
+			Kind: callgraph.EdgeRemovedDiff,
+			NodeA: &callgraph.Node{
+				Caller:      &callgraph.Func{FilePath: "/demo", FuncName: tt.caller},
+				Callee:      tt.callee,
+				Description: tt.desc,
+			},
+		}
+		code, logged := InjureLog("/demo/match.go", file, []*callgraph.Diff{diff})
+		if logged != (len(tt.want) > 0) {
+			t.Errorf("%v: logged = %v:\n%s", tt.name, logged, code)
+		}
+		if n := strings.Count(string(code), "log.Print"); n != len(tt.want) {
+			t.Errorf("%v: got %v logs, want %v:\n%s", tt.name, n, len(tt.want), code)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(string(code), want) {
+				t.Errorf("%v: want %q in\n%s", tt.name, want, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package _ast
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/dataznGao/leo/constant"
+	"github.com/dataznGao/leo/pkg/callgraph"
+)
+
+// Placement 日志相对于被调用者的位置
+type Placement string
+
+const (
+	// PlaceAfter 调用所在的语句之后, 调用在if的初始化或条件中时为if的分支开头
+	PlaceAfter Placement = "after"
+	// PlaceBefore 调用所在的语句之前
+	PlaceBefore Placement = "before"
+	// PlaceErrBranch 调用之后检查错误的if err != nil分支开头, 没有时同PlaceAfter
+	PlaceErrBranch Placement = "err"
+	// PlaceEntryExit 调用方函数的入口, 以及用defer在函数退出时
+	PlaceEntryExit Placement = "entry"
+	// PlaceElse 调用所在的if(或之后检查错误的if)的else分支开头, 没有else时补上, 没有if时同PlaceAfter
+	PlaceElse Placement = "else"
+)
+
+var allPlacements = []Placement{PlaceAfter, PlaceBefore, PlaceErrBranch, PlaceEntryExit, PlaceElse}
+
+// Placements 按造成差异的故障种类选择日志位置, 没有单独设置的故障种类用Default
+type Placements struct {
+	Default Placement
+	// ByFault 故障种类对应的日志位置, Faults为设置时的顺序
+	ByFault map[string]Placement
+	Faults  []string
+}
+
+// LogPlacements 注入日志时使用的位置
+var LogPlacements = &Placements{Default: PlaceAfter}
+
+// For 差异对应的日志位置。一处差异可能由多种故障造成, 按设置的顺序取第一个造成它的故障种类的位置
+func (p *Placements) For(d *callgraph.Diff) Placement {
+	if d.Impact != nil {
+		for _, fault := range p.Faults {
+			if _, ok := d.Impact.Faults[fault]; ok {
+				return p.ByFault[fault]
+			}
+		}
+	}
+	return p.Default
+}
+
+// ParsePlacements 解析日志位置: 一个位置(如"err"), 或逗号分隔的"故障种类=位置", 用default设置其余故障种类,
+// 如"exception-unhandled=err,null=before,default=after"。faults为可以设置的故障种类
+func ParsePlacements(s string, faults []string) (*Placements, error) {
+	res := &Placements{Default: PlaceAfter, ByFault: make(map[string]Placement)}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return res, nil
+	}
+	if !strings.Contains(s, "=") {
+		placement, err := parsePlacement(s)
+		res.Default = placement
+		return res, err
+	}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("placement %q: want fault=placement", part)
+		}
+		placement, err := parsePlacement(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		fault := strings.TrimSpace(kv[0])
+		if fault == "default" {
+			res.Default = placement
+			continue
+		}
+		known := false
+		for _, f := range faults {
+			known = known || f == fault
+		}
+		if !known {
+			return nil, fmt.Errorf("placement %q: unknown fault type %q, want one of %v", part, fault, faults)
+		}
+		if _, ok := res.ByFault[fault]; !ok {
+			res.Faults = append(res.Faults, fault)
+		}
+		res.ByFault[fault] = placement
+	}
+	return res, nil
+}
+
+func parsePlacement(s string) (Placement, error) {
+	s = strings.TrimSpace(s)
+	for _, p := range allPlacements {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown placement %q, want one of %v", s, allPlacements)
+}
+
+// stmtPos 块中含有被调用者的语句
+type stmtPos struct {
+	block *ast.BlockStmt
+	index int
+}
+
+// findCalleeStmts 函数中所有直接含有被调用者的语句, 同一个块中的语句按下标递增
+func findCalleeStmts(fun *ast.FuncDecl, m *calleeMatcher) []stmtPos {
+	res := make([]stmtPos, 0)
+	ast.Inspect(fun, func(n ast.Node) bool {
+		if block, ok := n.(*ast.BlockStmt); ok {
+			for i, stmt := range block.List {
+				if len(getAllCallee(stmt, m)) > 0 {
+					res = append(res, stmtPos{block, i})
+				}
+			}
+		}
+		return true
+	})
+	return res
+}
+
+// placeLog 按placement在函数中注入日志, 返回是否注入
+func placeLog(fun *ast.FuncDecl, m *calleeMatcher, placement Placement) bool {
+	if fun.Body == nil {
+		return false
+	}
+	positions := findCalleeStmts(fun, m)
+	if len(positions) == 0 {
+		return false
+	}
+	if placement == PlaceEntryExit {
+		if len(fun.Body.List) > 0 && isLog(fun.Body.List[0]) {
+			return true
+		}
+		// defer的参数在函数入口就求值了, 退出时的日志不带变量值
+		fun.Body.List = append([]ast.Stmt{m.generateLog(fun.Body.Lbrace + 1), generateDeferLog(constant.LogContent)}, fun.Body.List...)
+		return true
+	}
+	// 从后往前注入, 插入的日志不会改变还没处理的语句的下标
+	for i := len(positions) - 1; i >= 0; i-- {
+		pos := positions[i]
+		stmt := pos.block.List[pos.index]
+		var next ast.Stmt
+		if pos.index+1 < len(pos.block.List) {
+			next = pos.block.List[pos.index+1]
+		}
+		switch placement {
+		case PlaceBefore:
+			insertLog(pos.block, pos.index, m.logAt(stmt.Pos()))
+		case PlaceErrBranch:
+			if body := errBranch(stmt, next); body != nil {
+				insertLog(body, 0, m.logAt(body.Lbrace+1))
+			} else {
+				m.insertLogAfter(pos.block, pos.index)
+			}
+		case PlaceElse:
+			if body, at := elseBranch(stmt, next); body != nil {
+				insertLog(body, 0, m.logAt(at))
+			} else {
+				m.insertLogAfter(pos.block, pos.index)
+			}
+		default:
+			m.insertLogAfter(pos.block, pos.index)
+		}
+	}
+	return true
+}
+
+// logAt 返回生成注入到pos处的日志的函数, 确定要插入时才生成
+func (m *calleeMatcher) logAt(pos token.Pos) func() ast.Stmt {
+	return func() ast.Stmt { return m.generateLog(pos) }
+}
+
+// insertLog 在块的第i条语句之前插入gen生成的日志, 那里已经有日志时不插入
+func insertLog(block *ast.BlockStmt, i int, gen func() ast.Stmt) {
+	if i < len(block.List) && isLog(block.List[i]) || i > 0 && isLog(block.List[i-1]) {
+		return
+	}
+	block.List = append(block.List[:i], append([]ast.Stmt{gen()}, block.List[i:]...)...)
+}
+
+// insertLogAfter 在块的第i条语句之后插入日志, return等跳转语句之后不可达, 插在它之前
+func (m *calleeMatcher) insertLogAfter(block *ast.BlockStmt, i int) {
+	stmt := block.List[i]
+	switch stmt.(type) {
+	case *ast.ReturnStmt, *ast.BranchStmt:
+		insertLog(block, i, m.logAt(stmt.Pos()))
+	default:
+		insertLog(block, i+1, m.logAt(stmt.End()))
+	}
+}
+
+// errBranch 检查调用返回的错误的分支: 调用在if的初始化中且条件是err != nil, 或者下一条语句是if err != nil
+func errBranch(stmt, next ast.Stmt) *ast.BlockStmt {
+	if ifStmt, ok := stmt.(*ast.IfStmt); ok && ifStmt.Init != nil && isErrCheck(ifStmt.Cond) {
+		return ifStmt.Body
+	}
+	if ifStmt, ok := next.(*ast.IfStmt); ok && ifStmt.Init == nil && isErrCheck(ifStmt.Cond) {
+		return ifStmt.Body
+	}
+	return nil
+}
+
+// elseBranch 调用所在的if, 或者之后检查错误的if的else分支, 没有else时补上; else是else if时返回nil。
+// at为else分支在原代码中对应的位置, 补上的else没有位置, 用if分支的开头
+func elseBranch(stmt, next ast.Stmt) (block *ast.BlockStmt, at token.Pos) {
+	ifStmt, ok := stmt.(*ast.IfStmt)
+	if !ok {
+		if ifStmt, ok = next.(*ast.IfStmt); !ok || ifStmt.Init != nil || !isErrCheck(ifStmt.Cond) {
+			return nil, token.NoPos
+		}
+	}
+	switch e := ifStmt.Else.(type) {
+	case nil:
+		block := &ast.BlockStmt{}
+		ifStmt.Else = block
+		return block, ifStmt.Body.Lbrace + 1
+	case *ast.BlockStmt:
+		return e, e.Lbrace + 1
+	}
+	return nil, token.NoPos
+}
+
+// isErrCheck 条件是否形如err != nil
+func isErrCheck(cond ast.Expr) bool {
+	bin, ok := cond.(*ast.BinaryExpr)
+	if !ok || bin.Op != token.NEQ {
+		return false
+	}
+	x, ok := bin.X.(*ast.Ident)
+	if !ok || !strings.Contains(strings.ToLower(x.Name), "err") {
+		return false
+	}
+	y, ok := bin.Y.(*ast.Ident)
+	return ok && y.Name == "nil"
+}
+
+// isLog 语句是否是注入的日志, 包括按指针是否为nil分别打印的日志
+func isLog(stmt ast.Stmt) bool {
+	var call *ast.CallExpr
+	switch s := stmt.(type) {
+	case *ast.ExprStmt:
+		call, _ = s.X.(*ast.CallExpr)
+	case *ast.DeferStmt:
+		call = s.Call
+	case *ast.IfStmt:
+		return s.Init == nil && len(s.Body.List) == 1 && isLog(s.Body.List[0])
+	}
+	if call == nil || len(call.Args) == 0 {
+		return false
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	return ok && lit.Value == constant.LogContent
+}
+
+func generateDeferLog(content string) ast.Stmt {
+	return &ast.DeferStmt{Call: GenerateLog(content).(*ast.ExprStmt).X.(*ast.CallExpr)}
+}
@@ -0,0 +1,109 @@
+package _ast
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+)
+
+const placementSrc = `package demo
+
+func fetch() error { return nil }
+
+func check() bool { return true }
+
+func Load() error {
+	err := fetch()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func Cond() {
+	if check() {
+		println()
+	}
+}
+`
+
+var testFaults = []string{"sync", "exception-unhandled", "null"}
+
+func TestPlacements(t *testing.T) {
+	defer func(p *Placements) { LogPlacements = p }(LogPlacements)
+	removed := func(caller, callee string, faults ...string) *callgraph.Diff {
+		d := &callgraph.Diff{
+			Kind: callgraph.EdgeRemovedDiff,
+			NodeA: &callgraph.Node{
+				Caller: &callgraph.Func{FilePath: "/demo", FuncName: caller},
+				Callee: &callgraph.Func{FilePath: "/demo", FuncName: callee},
+			},
+			Impact: &callgraph.Impact{Faults: make(map[string]int)},
+		}
+		for _, f := range faults {
+			d.Impact.Faults[f] = 1
+		}
+		return d
+	}
+	tests := []struct {
+		placement string
+		diff      *callgraph.Diff
+		want      string
+	}{
+		{"before", removed("Load", "fetch"), "\tlog.Print(\"this is a log\")\n\terr := fetch()"},
+		{"err", removed("Load", "fetch"), "if err != nil {\n\t\tlog.Print(\"this is a log\")\n\t\treturn err"},
+		{"entry", removed("Load", "fetch"), "func Load() error {\n\tlog.Print(\"this is a log\")\n\tdefer log.Print(\"this is a log\")\n\terr := fetch()"},
+		{"else", removed("Cond", "check"), "} else {\n\t\tlog.Print(\"this is a log\")\n\t}"},
+		{"else", removed("Load", "fetch"), "return err\n\t} else {\n\t\tlog.Print(\"this is a log\")\n\t}"},
+		// 只为null设置了位置, sync造成的差异用default
+		{"null=err,default=before", removed("Load", "fetch", "sync"), "\tlog.Print(\"this is a log\")\n\terr := fetch()"},
+		// 多种故障造成的差异按设置的顺序取第一个
+		{"sync=before,null=err", removed("Load", "fetch", "null", "sync"), "\tlog.Print(\"this is a log\")\n\terr := fetch()"},
+		{"exception-unhandled=err", removed("Load", "fetch", "exception-unhandled"), "if err != nil {\n\t\tlog.Print(\"this is a log\")\n\t\treturn err"},
+	}
+	for _, tt := range tests {
+		placements, err := ParsePlacements(tt.placement, testFaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+		LogPlacements = placements
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "/demo/load.go", placementSrc, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, logged := InjureLog("/demo/load.go", &File{File: f, Fset: fset}, []*callgraph.Diff{tt.diff})
+		if !logged || !strings.Contains(string(code), tt.want) {
+			t.Errorf("placement %v: want %q in\n%s", tt.placement, tt.want, code)
+		}
+		if n := strings.Count(string(code), "log.Print"); tt.placement != "entry" && n != 1 {
+			t.Errorf("placement %v: got %v logs, want 1:\n%s", tt.placement, n, code)
+		}
+	}
+}
+
+func TestParsePlacements(t *testing.T) {
+	p, err := ParsePlacements("exception-unhandled=err, null=before,default=entry", testFaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	by := func(faults ...string) *callgraph.Diff {
+		d := &callgraph.Diff{Kind: callgraph.EdgeRemovedDiff, Impact: &callgraph.Impact{Faults: make(map[string]int)}}
+		for _, f := range faults {
+			d.Impact.Faults[f] = 1
+		}
+		return d
+	}
+	if p.For(by("exception-unhandled")) != PlaceErrBranch || p.For(by("null")) != PlaceBefore || p.For(by("sync")) != PlaceEntryExit ||
+		p.For(by("null", "exception-unhandled")) != PlaceErrBranch || p.For(&callgraph.Diff{}) != PlaceEntryExit {
+		t.Errorf("got %+v", p)
+	}
+	for _, bad := range []string{"sideways", "null=sideways", "no-such-fault=err", "edge-removed=err", "null"} {
+		if _, err := ParsePlacements(bad, testFaults); err == nil {
+			t.Errorf("ParsePlacements(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package _ast
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/dataznGao/leo/constant"
+)
+
+// ValueRules 日志中记录变量值的规则
+type ValueRules struct {
+	Enabled bool
+	// MaxLen 每个值最多打印的字符数, 不大于0时不截断
+	MaxLen int
+	// Redact 名字或字段路径(如req.Inner.Token)中含有其中任意一个字符串(不区分大小写)的值不打印, 只打印<redacted>
+	Redact []string
+}
+
+// LogValues 注入日志时记录变量值的规则
+var LogValues = &ValueRules{}
+
+func (r *ValueRules) redacted(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range r.Redact {
+		if s != "" && strings.Contains(key, strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateLog 生成注入到pos处的日志。开启了LogValues且有类型信息时,
+// 以key=value的形式附上匹配到的调用的参数, 以及调用方的接收者和参数中在pos处可见的值。
+// 要经过指针取值的字段只在指针都不为nil时打印: if c != nil { log(..., c.addr) } else { log(...) }
+// args放在日志内容之后、值之前
+func (m *calleeMatcher) generateLog(pos token.Pos, args ...ast.Expr) ast.Stmt {
+	entries := m.valueArgs(pos)
+	logWith := func(guarded bool) ast.Stmt {
+		stmt := GenerateLog(constant.LogContent).(*ast.ExprStmt)
+		call := stmt.X.(*ast.CallExpr)
+		call.Args = append(call.Args, cloneArgs(args)...)
+		for _, e := range entries {
+			if guarded || len(e.guards) == 0 {
+				call.Args = append(call.Args, e.args...)
+			}
+		}
+		return stmt
+	}
+	var cond ast.Expr
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		for _, g := range e.guards {
+			key := types.ExprString(g)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			check := &ast.BinaryExpr{X: cloneExpr(g), Op: token.NEQ, Y: ast.NewIdent("nil")}
+			if cond == nil {
+				cond = check
+			} else {
+				cond = &ast.BinaryExpr{X: cond, Op: token.LAND, Y: check}
+			}
+		}
+	}
+	if cond == nil {
+		return logWith(false)
+	}
+	return &ast.IfStmt{
+		Cond: cond,
+		Body: &ast.BlockStmt{List: []ast.Stmt{logWith(true)}},
+		Else: &ast.BlockStmt{List: []ast.Stmt{logWith(false)}},
+	}
+}
+
+// logValue 一个要打印的值
+type logValue struct {
+	key  string
+	expr ast.Expr
+	// obj 表达式引用的变量, 在pos处不可见时不打印
+	obj *types.Var
+	typ types.Type
+	// guards 对expr求值前必须不为nil的指针, 外层的在前
+	guards []ast.Expr
+}
+
+// logEntry 日志中一个值对应的参数, guards不为空时只在这些指针都不为nil时打印
+type logEntry struct {
+	args   []ast.Expr
+	guards []ast.Expr
+}
+
+// maxValueDepth 结构体最多展开的层数
+const maxValueDepth = 3
+
+// fmtAlias fmt被pos处的其他标识符遮蔽时导入fmt使用的名字
+const fmtAlias = "leofmt"
+
+func (m *calleeMatcher) valueArgs(pos token.Pos) []logEntry {
+	if !LogValues.Enabled || m.info == nil || m.fun == nil || !pos.IsValid() {
+		return nil
+	}
+	scope := m.scopeAt(pos)
+	if scope == nil {
+		return nil
+	}
+	values := make([]logValue, 0)
+	if call := m.nearestCall(pos); call != nil {
+		for _, arg := range call.Args {
+			if obj, typ, guards := m.safeExpr(arg); obj != nil {
+				values = appendValues(values, logValue{types.ExprString(arg), arg, obj, typ, guards}, 0)
+			}
+		}
+	}
+	fields := make([]*ast.Field, 0)
+	if m.fun.Recv != nil {
+		fields = append(fields, m.fun.Recv.List...)
+	}
+	if m.fun.Type != nil && m.fun.Type.Params != nil {
+		fields = append(fields, m.fun.Type.Params.List...)
+	}
+	for _, field := range fields {
+		for _, name := range field.Names {
+			if obj, ok := m.info.Defs[name].(*types.Var); ok && name.Name != "_" {
+				values = appendValues(values, logValue{name.Name, name, obj, obj.Type(), nil}, 0)
+			}
+		}
+	}
+
+	res := []logEntry{{args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(" call=" + m.node.Callee.FuncName)}}}}
+	fmtName := fmtNameAt(scope, pos)
+	seen := make(map[string]bool)
+	for _, v := range values {
+		// 只打印在pos处仍然指向同一个变量的值
+		if _, obj := scope.LookupParent(v.obj.Name(), pos); obj != v.obj || seen[v.key] {
+			continue
+		}
+		key := &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(" " + v.key + "=")}
+		if LogValues.redacted(v.key) {
+			seen[v.key] = true
+			res = append(res, logEntry{args: []ast.Expr{key, &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("<redacted>")}}})
+			continue
+		}
+		if fmtName == "" {
+			continue
+		}
+		seen[v.key] = true
+		if m.fmtImports == nil {
+			m.fmtImports = make(map[string]bool)
+		}
+		m.fmtImports[fmtName] = true
+		// fmt.Sprintf("%.64s", fmt.Sprint(v)), 只打印基本类型的值, 不会调用String、Error或复制锁
+		value := ast.Expr(&ast.CallExpr{Fun: fmtFunc(fmtName, "Sprint"), Args: []ast.Expr{cloneExpr(v.expr)}})
+		if LogValues.MaxLen > 0 {
+			format := &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("%." + strconv.Itoa(LogValues.MaxLen) + "s")}
+			value = &ast.CallExpr{Fun: fmtFunc(fmtName, "Sprintf"), Args: []ast.Expr{format, value}}
+		}
+		res = append(res, logEntry{args: []ast.Expr{key, value}, guards: v.guards})
+	}
+	return res
+}
+
+// fmtNameAt 在pos处引用fmt包使用的名字: fmt没有被遮蔽时为fmt, 否则为fmtAlias, 两者都被占用时为空
+func fmtNameAt(scope *types.Scope, pos token.Pos) string {
+	for _, name := range []string{"fmt", fmtAlias} {
+		_, obj := scope.LookupParent(name, pos)
+		if obj == nil {
+			return name
+		}
+		if pkg, ok := obj.(*types.PkgName); ok && pkg.Imported().Path() == "fmt" {
+			return name
+		}
+	}
+	return ""
+}
+
+// appendValues 基本类型的值直接打印, 结构体和指向结构体的指针按字段路径(req.Inner.Token)展开到基本类型的字段,
+// 经过的指针记入guards; 接口、切片等其余类型以及有String、Error方法的类型不打印
+func appendValues(values []logValue, v logValue, depth int) []logValue {
+	if hasFormatMethod(v.typ) {
+		return values
+	}
+	guards := v.guards
+	var st *types.Struct
+	switch t := v.typ.Underlying().(type) {
+	case *types.Basic:
+		if t.Kind() != types.UnsafePointer && t.Kind() != types.UntypedNil {
+			values = append(values, v)
+		}
+		return values
+	case *types.Struct:
+		st = t
+	case *types.Pointer:
+		if st, _ = t.Elem().Underlying().(*types.Struct); st == nil {
+			return values
+		}
+		guards = append(append(make([]ast.Expr, 0, len(v.guards)+1), v.guards...), v.expr)
+	default:
+		return values
+	}
+	if depth >= maxValueDepth {
+		return values
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		// 其他包中未导出的字段在这里访问不到
+		if field.Name() == "_" || !field.Exported() && field.Pkg() != v.obj.Pkg() {
+			continue
+		}
+		expr := &ast.SelectorExpr{X: v.expr, Sel: ast.NewIdent(field.Name())}
+		values = appendValues(values, logValue{v.key + "." + field.Name(), expr, v.obj, field.Type(), guards}, depth+1)
+	}
+	return values
+}
+
+// hasFormatMethod fmt打印t类型的值时是否会调用它的String或Error方法
+func hasFormatMethod(t types.Type) bool {
+	methods := types.NewMethodSet(t)
+	for _, name := range []string{"String", "Error"} {
+		if sel := methods.Lookup(nil, name); sel != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func fmtFunc(pkg, name string) ast.Expr {
+	return &ast.SelectorExpr{X: ast.NewIdent(pkg), Sel: ast.NewIdent(name)}
+}
+
+// safeExpr 表达式经过类型检查且没有副作用时返回它引用的变量、表达式的类型,
+// 以及求值前必须不为nil的指针: 变量, 或者字段选择(v.a.b), 经过指针取值的字段要求指针不为nil
+func (m *calleeMatcher) safeExpr(e ast.Expr) (*types.Var, types.Type, []ast.Expr) {
+	switch x := e.(type) {
+	case *ast.ParenExpr:
+		return m.safeExpr(x.X)
+	case *ast.Ident:
+		if obj, ok := m.info.Uses[x].(*types.Var); ok && !obj.IsField() {
+			return obj, obj.Type(), nil
+		}
+	case *ast.SelectorExpr:
+		sel, ok := m.info.Selections[x]
+		if !ok || sel.Kind() != types.FieldVal {
+			break
+		}
+		obj, typ, guards := m.safeExpr(x.X)
+		if obj == nil {
+			break
+		}
+		if !sel.Indirect() {
+			return obj, sel.Type(), guards
+		}
+		// 只处理对x.X本身取值的情况, 经过嵌入的指针字段取值时无法写出要检查的指针
+		if _, ptr := typ.Underlying().(*types.Pointer); ptr && len(sel.Index()) == 1 {
+			return obj, sel.Type(), append(append(make([]ast.Expr, 0, len(guards)+1), guards...), x.X)
+		}
+	}
+	return nil, nil, nil
+}
+
+func cloneArgs(args []ast.Expr) []ast.Expr {
+	res := make([]ast.Expr, 0, len(args))
+	for _, arg := range args {
+		res = append(res, cloneExpr(arg))
+	}
+	return res
+}
+
+func cloneExpr(e ast.Expr) ast.Expr {
+	switch x := e.(type) {
+	case *ast.ParenExpr:
+		return cloneExpr(x.X)
+	case *ast.SelectorExpr:
+		return &ast.SelectorExpr{X: cloneExpr(x.X), Sel: ast.NewIdent(x.Sel.Name)}
+	case *ast.Ident:
+		return ast.NewIdent(x.Name)
+	}
+	return e
+}
+
+// nearestCall 当前函数中离pos最近的匹配的调用
+func (m *calleeMatcher) nearestCall(pos token.Pos) *ast.CallExpr {
+	var res *ast.CallExpr
+	best := -1
+	for call, wrap := range collectCalls(m.fun.Body) {
+		if !m.match(call, wrap) || !call.Pos().IsValid() {
+			continue
+		}
+		d := int(call.Pos()) - int(pos)
+		if d < 0 {
+			d = -d
+		}
+		if best < 0 || d < best || d == best && call.Pos() < res.Pos() {
+			res, best = call, d
+		}
+	}
+	return res
+}
+
+// scopeAt pos处最内层的作用域, 从当前函数的作用域逐层向内查找
+func (m *calleeMatcher) scopeAt(pos token.Pos) *types.Scope {
+	if m.fun.Type == nil {
+		return nil
+	}
+	scope, ok := m.info.Scopes[m.fun.Type]
+	if !ok {
+		return nil
+	}
+	return scope.Innermost(pos)
+}
@@ -0,0 +1,147 @@
+package _ast
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+)
+
+const valuesSrc = `package demo
+
+type Client struct{ addr string }
+
+type Inner struct {
+	Token string
+	Port  int
+}
+
+type Req struct {
+	ID    int
+	Inner Inner
+	P     *Inner
+}
+
+type Level int
+
+func (l Level) String() string { return "level" }
+
+type Opts struct {
+	Level Level
+	Debug bool
+}
+
+func send(id int, token string, inner *Inner, addr string) error { return nil }
+
+func (c *Client) Do(req Req, password string, opts Opts) error {
+	local := req.ID
+	err := send(local, req.Inner.Token, req.P, c.addr)
+	return err
+}
+`
+
+// injectValues 对src做类型检查后按diff注入日志
+func injectValues(t *testing.T, src string, diff *callgraph.Diff) string {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "/demo/do.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	if _, err := new(types.Config).Check("demo", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+	code, logged := InjureLog("/demo/do.go", &File{File: f, Fset: fset, Info: info}, []*callgraph.Diff{diff})
+	if !logged {
+		t.Fatalf("no log injected:\n%s", code)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "do.go", code, 0); err != nil {
+		t.Errorf("injected code does not parse: %v\n%s", err, code)
+	}
+	return string(code)
+}
+
+func TestLogValues(t *testing.T) {
+	defer func(r *ValueRules) { LogValues = r }(LogValues)
+	LogValues = &ValueRules{Enabled: true, MaxLen: 8, Redact: []string{"token", "PASSWORD"}}
+
+	code := injectValues(t, valuesSrc, &callgraph.Diff{
+		Kind: callgraph.EdgeRemovedDiff,
+		NodeA: &callgraph.Node{
+			Caller: &callgraph.Func{FilePath: "/demo", StructName: "Client", IsPointer: true, FuncName: "Do"},
+			Callee: &callgraph.Func{FilePath: "/demo", FuncName: "send"},
+		},
+	})
+	for _, want := range []string{
+		`" call=send", " local=", fmt.Sprintf("%.8s", fmt.Sprint(local))`,
+		`" req.Inner.Token=", "<redacted>"`,
+		`" req.ID=", fmt.Sprintf("%.8s", fmt.Sprint(req.ID))`,
+		`" password=", "<redacted>"`,
+		`" opts.Debug=", fmt.Sprintf("%.8s", fmt.Sprint(opts.Debug))`,
+		// 经过指针取值的字段只在指针不为nil时打印
+		`if req.P != nil && c != nil {`,
+		`" c.addr=", fmt.Sprintf("%.8s", fmt.Sprint(c.addr))`,
+		`" req.P.Port=", fmt.Sprintf("%.8s", fmt.Sprint(req.P.Port))`,
+		`"fmt"`,
+		`"log"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("want %s in\n%s", want, code)
+		}
+	}
+	// else分支中的日志不打印要经过指针取值的字段
+	for _, guarded := range []string{"c.addr=", "req.P.Port="} {
+		if n := strings.Count(code, guarded); n != 1 {
+			t.Errorf("%s logged %d times, want only in the guarded log:\n%s", guarded, n, code)
+		}
+	}
+	// 指针、结构体整体和有String方法的值都不打印
+	for _, unwanted := range []string{`" c="`, `" req="`, "req.P=", `" opts="`, "opts.Level="} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("%s should not be logged:\n%s", unwanted, code)
+		}
+	}
+}
+
+const shadowSrc = `package demo
+
+func send(id int) error { return nil }
+
+func Do(id int) error {
+	fmt := "%d"
+	_ = fmt
+	err := send(id)
+	return err
+}
+`
+
+func TestLogValuesShadowedFmt(t *testing.T) {
+	defer func(r *ValueRules) { LogValues = r }(LogValues)
+	LogValues = &ValueRules{Enabled: true}
+
+	code := injectValues(t, shadowSrc, &callgraph.Diff{
+		Kind: callgraph.EdgeRemovedDiff,
+		NodeA: &callgraph.Node{
+			Caller: &callgraph.Func{FilePath: "/demo", FuncName: "Do"},
+			Callee: &callgraph.Func{FilePath: "/demo", FuncName: "send"},
+		},
+	})
+	for _, want := range []string{`leofmt "fmt"`, `" id=", leofmt.Sprint(id)`} {
+		if !strings.Contains(code, want) {
+			t.Errorf("want %s in\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "fmt.Sprint") && !strings.Contains(code, "leofmt.Sprint") || strings.Contains(code, "\t\"fmt\"") {
+		t.Errorf("shadowed fmt used:\n%s", code)
+	}
+}
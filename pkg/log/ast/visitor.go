@@ -1,12 +1,15 @@
 package _ast
 
 import (
-	"github.com/dataznGao/leo/constant"
 	"github.com/dataznGao/leo/pkg/callgraph"
 	"go/ast"
 	"go/token"
+	"go/types"
+	"path/filepath"
 	"strconv"
 	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
 )
 
 type Item struct {
@@ -21,20 +24,278 @@ var AnonyFuncMap = make(map[string]*ast.FuncLit)
 
 type DiffVisitor struct {
 	diff      *callgraph.Diff
+	fset      *token.FileSet
+	info      *types.Info
 	HasLogged *bool
 }
 
 func (v *DiffVisitor) Visit(node ast.Node) ast.Visitor {
 	if f, ok := node.(*ast.File); ok {
-		v.HasLogged = setLog(f, v.diff)
+		v.HasLogged = setLog(f, v.fset, v.info, v.diff)
 	}
 	return nil
 }
 
-func setLog(file *ast.File, diff *callgraph.Diff) *bool {
+// calleeMatcher 判断调用表达式是否对应diff中的被调用者, 有调用点信息时同时比对调用方式和行号
+type calleeMatcher struct {
+	diff *callgraph.Diff
+	node *callgraph.Node
+	fset *token.FileSet
+	// info 类型信息, 没有时为nil
+	info *types.Info
+	// exact 当前函数中存在落在调用点行号上的调用时, 只匹配这些调用
+	exact bool
+	// fun 正在注入的函数
+	fun *ast.FuncDecl
+	// fmtImports 注入的日志中引用fmt包用到的名字
+	fmtImports map[string]bool
+	// recv 在当前包及其依赖中找到的被调用者的接收者类型, recvDone为true后才有效
+	recv     *types.Named
+	recvDone bool
+}
+
+// logPos 日志插在块的第i条语句之后时在原代码中对应的位置, i为-1时是块的开头
+func (m *calleeMatcher) logPos(block *ast.BlockStmt, i int) token.Pos {
+	if i < 0 || i >= len(block.List) {
+		return block.Lbrace + 1
+	}
+	return block.List[i].End()
+}
+
+func newCalleeMatcher(fset *token.FileSet, info *types.Info, diff *callgraph.Diff) *calleeMatcher {
+	return &calleeMatcher{
+		diff: diff,
+		node: injectNode(diff),
+		fset: fset,
+		info: info,
+	}
+}
+
+// matchName 按函数名匹配被调用者
+func (m *calleeMatcher) matchName(call *ast.CallExpr) bool {
+	callee := m.node.Callee
+	if ident, ok := call.Fun.(*ast.Ident); ok {
+		return ident.Name == callee.FuncName && callee.StructName == ""
+	} else if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+		return sel.Sel.Name == callee.FuncName && callee.StructName != ""
+	}
+	// 对匿名函数，无法通过ast来遍历，直接用body判断
+	if lit, ok := call.Fun.(*ast.FuncLit); ok {
+		for name, body := range AnonyFuncMap {
+			if lit == body && name == callee.FuncName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchCallee 有类型信息时比对调用解析到的函数的包、接收者类型和函数名, 解析不到时按函数名匹配。
+// 通过函数类型的变量发起的调用在静态类型上看不出被调用的函数, diff中是动态调用时只比对调用点
+func (m *calleeMatcher) matchCallee(call *ast.CallExpr) bool {
+	if m.info != nil {
+		if obj := m.calleeObject(call.Fun); obj != nil {
+			if _, ok := obj.(*types.Var); ok && m.dynamicCall() {
+				return true
+			}
+			return m.matchFunc(obj)
+		}
+	}
+	return m.matchName(call)
+}
+
+// dynamicCall diff中的调用是否是通过函数值发起的动态调用
+func (m *calleeMatcher) dynamicCall() bool {
+	if strings.Contains(m.node.Description, "dynamic function call") {
+		return true
+	}
+	for _, site := range m.node.Sites {
+		if site.Kind == callgraph.CallClosure {
+			return true
+		}
+	}
+	return false
+}
+
+// calleeObject 被调用的表达式引用的对象: 函数、方法, 或者函数类型的变量、类型转换、内置函数
+func (m *calleeMatcher) calleeObject(fun ast.Expr) types.Object {
+	for {
+		switch x := fun.(type) {
+		case *ast.ParenExpr:
+			fun = x.X
+		case *ast.IndexExpr:
+			// 显式实例化的泛型函数f[T]()
+			fun = x.X
+		case *ast.IndexListExpr:
+			fun = x.X
+		case *ast.Ident:
+			return m.info.Uses[x]
+		case *ast.SelectorExpr:
+			return m.info.Uses[x.Sel]
+		default:
+			return nil
+		}
+	}
+}
+
+// matchFunc 对象是否就是diff中的被调用者。通过接口调用的方法在静态类型上看不出实际的接收者,
+// 比对方法名以及被调用者的接收者类型是否实现了这个接口
+func (m *calleeMatcher) matchFunc(obj types.Object) bool {
+	callee := m.node.Callee
+	fn, ok := obj.(*types.Func)
+	if !ok || fn.Name() != callee.FuncName {
+		return false
+	}
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		t := recv.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		if iface, ok := t.Underlying().(*types.Interface); ok {
+			return callee.StructName != "" && m.implements(iface)
+		}
+		named, ok := t.(*types.Named)
+		if !ok || named.Obj().Name() != callee.StructName {
+			return false
+		}
+	} else if callee.StructName != "" {
+		return false
+	}
+	return m.samePackage(fn)
+}
+
+// implements 被调用者的接收者类型或其指针类型是否实现了iface。接收者类型不在当前包及其依赖中时
+// (比如实现只在别的包中注入), 在这里无法判断, 视为实现
+func (m *calleeMatcher) implements(iface *types.Interface) bool {
+	named := m.calleeType()
+	if named == nil {
+		return true
+	}
+	return types.Implements(named, iface) || types.Implements(types.NewPointer(named), iface)
+}
+
+// calleeType 在当前包及其依赖中查找被调用者的接收者类型, 找不到时返回nil
+func (m *calleeMatcher) calleeType() *types.Named {
+	if m.recvDone {
+		return m.recv
+	}
+	m.recvDone = true
+	var pkg *types.Package
+	for _, obj := range m.info.Defs {
+		if obj != nil && obj.Pkg() != nil {
+			pkg = obj.Pkg()
+			break
+		}
+	}
+	seen := make(map[*types.Package]bool)
+	var find func(pkg *types.Package) *types.Named
+	find = func(pkg *types.Package) *types.Named {
+		if pkg == nil || seen[pkg] {
+			return nil
+		}
+		seen[pkg] = true
+		if tn, ok := pkg.Scope().Lookup(m.node.Callee.StructName).(*types.TypeName); ok && m.samePackage(tn) {
+			if named, ok := tn.Type().(*types.Named); ok {
+				return named
+			}
+		}
+		for _, imp := range pkg.Imports() {
+			if named := find(imp); named != nil {
+				return named
+			}
+		}
+		return nil
+	}
+	m.recv = find(pkg)
+	return m.recv
+}
+
+// samePackage 对象是否声明在被调用者的包中。项目中的包在diff中记为目录, 按声明所在的目录比对; 其余的包记为导入路径
+func (m *calleeMatcher) samePackage(obj types.Object) bool {
+	path := m.node.Callee.FilePath
+	if obj.Pkg() != nil && obj.Pkg().Path() == path {
+		return true
+	}
+	if m.fset == nil || !obj.Pos().IsValid() {
+		return false
+	}
+	dir, err1 := filepath.Abs(filepath.Dir(m.fset.Position(obj.Pos()).Filename))
+	want, err2 := filepath.Abs(path)
+	return err1 == nil && err2 == nil && dir == want
+}
+
+// matchSite 比对调用方式(go、defer或普通调用), exact时还要求行号一致
+func (m *calleeMatcher) matchSite(call *ast.CallExpr, wrap callgraph.CallKind) bool {
+	if len(m.node.Sites) == 0 {
+		return true
+	}
+	for _, site := range m.node.Sites {
+		kindOk := wrap == ""
+		if site.Kind == callgraph.CallGo || site.Kind == callgraph.CallDefer {
+			kindOk = site.Kind == wrap
+		}
+		if kindOk && (!m.exact || m.onSite(call, site)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *calleeMatcher) onSite(call *ast.CallExpr, site *callgraph.CallSite) bool {
+	if m.fset == nil {
+		return false
+	}
+	pos := m.fset.Position(call.Pos())
+	return pos.Filename == site.File && pos.Line == site.Line
+}
+
+func (m *calleeMatcher) match(call *ast.CallExpr, wrap callgraph.CallKind) bool {
+	return m.matchCallee(call) && m.matchSite(call, wrap)
+}
+
+// resetExact 检查函数中是否有调用恰好落在调用点上
+func (m *calleeMatcher) resetExact(fun *ast.FuncDecl) {
+	m.exact = false
+	if fun.Body == nil {
+		return
+	}
+	for call, wrap := range collectCalls(fun.Body) {
+		if !m.matchCallee(call) || !m.matchSite(call, wrap) {
+			continue
+		}
+		for _, site := range m.node.Sites {
+			if m.onSite(call, site) {
+				m.exact = true
+				return
+			}
+		}
+	}
+}
+
+// collectCalls 收集node中所有的调用表达式, 以及它们是否由go或defer发起
+func collectCalls(node ast.Node) map[*ast.CallExpr]callgraph.CallKind {
+	calls := make(map[*ast.CallExpr]callgraph.CallKind)
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.GoStmt:
+			calls[x.Call] = callgraph.CallGo
+		case *ast.DeferStmt:
+			calls[x.Call] = callgraph.CallDefer
+		case *ast.CallExpr:
+			if _, ok := calls[x]; !ok {
+				calls[x] = ""
+			}
+		}
+		return true
+	})
+	return calls
+}
+
+func setLog(file *ast.File, fset *token.FileSet, info *types.Info, diff *callgraph.Diff) *bool {
 	hasLog := false
 	// 设置log
-	caller := diff.NodeA.Caller
+	m := newCalleeMatcher(fset, info, diff)
+	caller := m.node.Caller
 	funs := GetFuns(file)
 	// 获取匿名函数map
 	AnonyFuncMap = GetAnonyFuns(funs)
@@ -71,9 +332,9 @@ func setLog(file *ast.File, diff *callgraph.Diff) *bool {
 		if canLog {
 			flag = true
 			// 函数粒度注入
-			if setLogInFun(fun, diff) {
+			if setLogInFun(fun, m) {
 				hasLog = true
-				setImportLog(file)
+				setImportLog(fset, file)
 			}
 		}
 	}
@@ -89,13 +350,21 @@ func setLog(file *ast.File, diff *callgraph.Diff) *bool {
 					Name: &ast.Ident{Name: name},
 					Type: lit.Type,
 					Body: lit.Body,
-				}, diff) {
+				}, m) {
 					hasLog = true
-					setImportLog(file)
+					setImportLog(fset, file)
 				}
 			}
 		}
 	}
+	if hasLog && fset != nil {
+		if m.fmtImports["fmt"] {
+			astutil.AddImport(fset, file, "fmt")
+		}
+		if m.fmtImports[fmtAlias] {
+			astutil.AddNamedImport(fset, file, fmtAlias, "fmt")
+		}
+	}
 	return &hasLog
 }
 
@@ -168,7 +437,12 @@ func (v *litCounter) Visit(node ast.Node) ast.Visitor {
 	return v
 }
 
-func setImportLog(file *ast.File) {
+// setImportLog 导入log, 有fset时由astutil按位置放进已有的import声明
+func setImportLog(fset *token.FileSet, file *ast.File) {
+	if fset != nil {
+		astutil.AddImport(fset, file, "log")
+		return
+	}
 	logPath := new(ast.BasicLit)
 	logPath.Value = "\"log\""
 	logPath.Kind = token.STRING
@@ -208,9 +482,11 @@ func setImportLog(file *ast.File) {
 	}
 }
 
-func setLogInFun(fun *ast.FuncDecl, diff *callgraph.Diff) bool {
+func setLogInFun(fun *ast.FuncDecl, m *calleeMatcher) bool {
 	hasLog := false
-	vis := &calleeVis{diff, &hasLog}
+	m.fun = fun
+	m.resetExact(fun)
+	vis := &calleeVis{m, &hasLog}
 	ast.Walk(vis, fun)
 	return *vis.hasLog
 }
@@ -225,10 +501,11 @@ func GetFuns(file *ast.File) []*ast.FuncDecl {
 	return funs
 }
 
-func getAllCallee(stmt ast.Node, diff *callgraph.Diff) []*ast.CallExpr {
+func getAllCallee(stmt ast.Node, m *calleeMatcher) []*ast.CallExpr {
 	res := make([]*ast.CallExpr, 0)
 	v := &calleeStmtVis{
-		diff:   diff,
+		m:      m,
+		wrap:   make(map[*ast.CallExpr]callgraph.CallKind),
 		Callee: res,
 	}
 	ast.Walk(v, stmt)
@@ -236,26 +513,39 @@ func getAllCallee(stmt ast.Node, diff *callgraph.Diff) []*ast.CallExpr {
 }
 
 type calleeVis struct {
-	diff   *callgraph.Diff
+	m      *calleeMatcher
 	hasLog *bool
 }
 
 func (v *calleeVis) Visit(node ast.Node) ast.Visitor {
 	// 函数中找到有有函数调用的block
 	fun := node.(*ast.FuncDecl)
-	block, index := FindHasCalleeBlock(fun, v.diff)
+	placement := LogPlacements.For(v.m.diff)
+	// 有类型信息时优先在检查被调用者返回的错误处打印错误
+	if (placement == PlaceAfter || placement == PlaceErrBranch) && placeErrorLogs(fun, v.m) {
+		tr := true
+		v.hasLog = &tr
+	}
+	if placement != PlaceAfter {
+		if placeLog(fun, v.m, placement) {
+			tr := true
+			v.hasLog = &tr
+		}
+		return nil
+	}
+	block, index := FindHasCalleeBlock(fun, v.m)
 	if block != nil && len(block) > 0 {
 		tr := true
 		v.hasLog = &tr
 		for j, stmt := range block {
 			if _, ok := CanInjuredMap[Item{
 				Block:  stmt,
-				Callee: v.diff.NodeA.Callee.FuncName,
+				Callee: v.m.node.Callee.FuncName,
 			}]; ok {
 				continue
 			} else {
 				if len(stmt.List) == 0 {
-					stmt.List = append(stmt.List, GenerateLog(constant.LogContent))
+					stmt.List = append(stmt.List, v.m.generateLog(stmt.Lbrace+1))
 				} else {
 					can := true
 					if index[j] >= 0 {
@@ -264,27 +554,19 @@ func (v *calleeVis) Visit(node ast.Node) ast.Visitor {
 						}
 					}
 					for _, s := range stmt.List {
-						if stm, ok := s.(*ast.ExprStmt); ok {
-							if ide, ok := stm.X.(*ast.CallExpr); ok {
-								if len(ide.Args) > 0 {
-									if lit, ok := ide.Args[0].(*ast.BasicLit); ok {
-										if lit.Value == constant.LogContent {
-											can = false
-											break
-										}
-									}
-								}
-							}
+						if isLog(s) {
+							can = false
+							break
 						}
 					}
 					if can {
 						stmt.List = append(stmt.List[:index[j]+1],
-							append([]ast.Stmt{GenerateLog(constant.LogContent)}, stmt.List[index[j]+1:]...)...)
+							append([]ast.Stmt{v.m.generateLog(v.m.logPos(stmt, index[j]))}, stmt.List[index[j]+1:]...)...)
 					}
 				}
 				CanInjuredMap[Item{
 					Block:  stmt,
-					Callee: v.diff.NodeA.Callee.FuncName,
+					Callee: v.m.node.Callee.FuncName,
 				}] = true
 			}
 		}
@@ -294,7 +576,7 @@ func (v *calleeVis) Visit(node ast.Node) ast.Visitor {
 }
 
 type blockVisitor struct {
-	diff  *callgraph.Diff
+	m     *calleeMatcher
 	block []*ast.BlockStmt
 	index []int
 }
@@ -304,18 +586,18 @@ func (v *blockVisitor) Visit(node ast.Node) ast.Visitor {
 		// 判断这个list里面有没有callee
 		for i, stmt := range block.List {
 			// 对这个stmt，判断其内部有没有callee
-			if len(getAllCallee(stmt, v.diff)) > 0 {
+			if len(getAllCallee(stmt, v.m)) > 0 {
 				v.index = append(v.index, i)
 				v.block = append(v.block, block)
 			}
 		}
 		// 如果是If语句，条件内有CallExpr
 	} else if ifStmt, ok := node.(*ast.IfStmt); ok {
-		if ifStmt.Init != nil && len(getAllCallee(ifStmt.Init, v.diff)) > 0 {
+		if ifStmt.Init != nil && len(getAllCallee(ifStmt.Init, v.m)) > 0 {
 			// 立刻添加日志，
 			v.index = append(v.index, -1)
 			v.block = append(v.block, ifStmt.Body)
-		} else if ifStmt.Cond != nil && len(getAllCallee(ifStmt.Cond, v.diff)) > 0 {
+		} else if ifStmt.Cond != nil && len(getAllCallee(ifStmt.Cond, v.m)) > 0 {
 			// 立刻添加日志，
 			v.index = append(v.index, 0)
 			v.block = append(v.block, ifStmt.Body)
@@ -324,10 +606,10 @@ func (v *blockVisitor) Visit(node ast.Node) ast.Visitor {
 	return v
 }
 
-func FindHasCalleeBlock(node *ast.FuncDecl, diff *callgraph.Diff) ([]*ast.BlockStmt, []int) {
+func FindHasCalleeBlock(node *ast.FuncDecl, m *calleeMatcher) ([]*ast.BlockStmt, []int) {
 	// block中有函数调用, 给他加日志
 	v := &blockVisitor{
-		diff:  diff,
+		m:     m,
 		block: make([]*ast.BlockStmt, 0),
 		index: make([]int, 0),
 	}
@@ -353,7 +635,8 @@ func dedup(block []*ast.BlockStmt, index []int) ([]*ast.BlockStmt, []int) {
 }
 
 type calleeStmtVis struct {
-	diff   *callgraph.Diff
+	m      *calleeMatcher
+	wrap   map[*ast.CallExpr]callgraph.CallKind
 	Callee []*ast.CallExpr
 }
 
@@ -362,23 +645,14 @@ func (v *calleeStmtVis) Visit(node ast.Node) ast.Visitor {
 	if _, ok := node.(*ast.BlockStmt); ok {
 		return nil
 	}
+	if goStmt, ok := node.(*ast.GoStmt); ok {
+		v.wrap[goStmt.Call] = callgraph.CallGo
+	} else if deferStmt, ok := node.(*ast.DeferStmt); ok {
+		v.wrap[deferStmt.Call] = callgraph.CallDefer
+	}
 	if calleeStmt, ok := node.(*ast.CallExpr); ok {
-		if callee, ok := calleeStmt.Fun.(*ast.Ident); ok {
-			if callee.Name == v.diff.NodeA.Callee.FuncName && v.diff.NodeA.Callee.StructName == "" {
-				v.Callee = append(v.Callee, calleeStmt)
-			}
-		} else if callee, ok := calleeStmt.Fun.(*ast.SelectorExpr); ok {
-			if callee.Sel.Name == v.diff.NodeA.Callee.FuncName && v.diff.NodeA.Callee.StructName != "" {
-				v.Callee = append(v.Callee, calleeStmt)
-			}
-		}
-		// 对匿名函数，无法通过ast来遍历，直接用body判断
-		if lit, ok := calleeStmt.Fun.(*ast.FuncLit); ok {
-			for name, body := range AnonyFuncMap {
-				if lit == body && name == v.diff.NodeA.Callee.FuncName {
-					v.Callee = append(v.Callee, calleeStmt)
-				}
-			}
+		if v.m.match(calleeStmt, v.wrap[calleeStmt]) {
+			v.Callee = append(v.Callee, calleeStmt)
 		}
 	}
 	return v
@@ -0,0 +1,84 @@
+package _log
+
+import (
+	"flag"
+	"log"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+	_ast "github.com/dataznGao/leo/pkg/log/ast"
+)
+
+var (
+	topSites   = flag.Int("top", 0, "Inject logs only at the N highest-scoring sites (0 means unlimited).")
+	maxPerFunc = flag.Int("perfunc", 0, "Inject at most K logs into each function, keeping its highest-scoring sites (0 means unlimited).")
+	coverMode  = flag.String("cover", "", "Inject logs only at a minimal set of sites that covers every diff [diffs] or every changed call path [paths] (empty disables it). The chosen sites are written to cover.json under -exportdir.")
+	coverDepth = flag.Int("coverDepth", 3, "With -cover, a log after a call covers the diffs at most this many calls below it; the calls its callee makes are 1 below (0 covers only the call itself).")
+)
+
+// coverDiffs -cover时只保留覆盖所有差异(或所有变化的调用链)的最小注入点集合
+func coverDiffs(diffs []*callgraph.Diff, calls callgraph.Calls, inputPath string) []*callgraph.Diff {
+	if *coverMode == "" {
+		return diffs
+	}
+	sites := make([]*callgraph.Diff, 0, len(diffs))
+	for _, d := range diffs {
+		if _ast.Injectable(d) {
+			sites = append(sites, d)
+		}
+	}
+	targets := sites
+	switch *coverMode {
+	case "diffs":
+	case "paths":
+		targets = make([]*callgraph.Diff, 0)
+		for _, d := range sites {
+			if len(d.Path) > 0 {
+				targets = append(targets, d)
+			}
+		}
+		if len(targets) == 0 {
+			log.Printf("[leo] WARN 没有变化的调用链, -cover=paths改为覆盖所有差异")
+			targets = sites
+		}
+	default:
+		log.Printf("[leo] WARN 不支持的-cover: %v, 不计算覆盖", *coverMode)
+		return diffs
+	}
+	if len(calls) == 0 {
+		log.Printf("[leo] WARN 没有调用关系, 每个注入点只覆盖它自己")
+	}
+	cover := callgraph.Cover(sites, targets, calls, inputPath, *coverDepth)
+	res := make([]*callgraph.Diff, 0, len(cover))
+	for _, c := range cover {
+		trace := c.Site.PrintTrace()
+		log.Printf("[leo] INFO 注入点%v -> %v覆盖%v个差异", trace[1], trace[2], len(c.Covers))
+		res = append(res, c.Site)
+	}
+	log.Printf("[leo] INFO 用%v个注入点覆盖了%v个差异", len(res), len(targets))
+	if err := callgraph.ExportCover(cover); err != nil {
+		log.Printf("[leo] WARN 最小覆盖导出失败, err: %v", err)
+	}
+	return res
+}
+
+// budgetDiffs 按得分和-top、-perfunc挑出要注入日志的差异, 不需要注入的差异不占名额
+func budgetDiffs(diffs []*callgraph.Diff) []*callgraph.Diff {
+	sites := make([]*callgraph.Diff, 0, len(diffs))
+	for _, d := range diffs {
+		if _ast.Injectable(d) {
+			sites = append(sites, d)
+		}
+	}
+	res := callgraph.Budget(sites, *topSites, *maxPerFunc)
+	if len(res) < len(sites) {
+		log.Printf("[leo] INFO 按得分保留了%v/%v个注入点", len(res), len(sites))
+	}
+	for i, d := range res {
+		if i == 10 {
+			break
+		}
+		trace := d.PrintTrace()
+		log.Printf("[leo] INFO 注入点#%v 得分%.2f %v: %v -> %v", i+1, d.Score(), d.Kind, trace[1], trace[2])
+	}
+	return res
+}
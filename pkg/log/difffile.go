@@ -0,0 +1,120 @@
+package _log
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+	"github.com/dataznGao/leo/util"
+)
+
+var diffFileFlag = flag.String("diffs", "", "Diff file written by leo log and read by leo inject (default <output>.diffs.json)")
+
+// DiffFileVersion 差异文件的格式版本, Diff的JSON格式不兼容地变化时加一
+const DiffFileVersion = 2
+
+// DiffFile 保存到磁盘上的调用图差异, leo inject可以直接从它注入日志, 不必重新生成调用图
+type DiffFile struct {
+	Version int `json:"version"`
+	// InputPath 生成差异时的项目路径, 差异中的文件路径都以它开头
+	InputPath string `json:"inputPath"`
+	// Options 生成差异时影响调用图的选项
+	Options string            `json:"options"`
+	Created time.Time         `json:"created"`
+	Diffs   []*callgraph.Diff `json:"diffs"`
+	// Calls 原始、故障调用图中的调用关系, 用于-cover计算注入点的覆盖
+	Calls callgraph.Calls `json:"calls,omitempty"`
+}
+
+// diffFilePath -diffs, 未设置时为输出目录旁边的<output>.diffs.json
+func diffFilePath(outputPath string) string {
+	if *diffFileFlag != "" {
+		return *diffFileFlag
+	}
+	return filepath.Clean(outputPath) + ".diffs.json"
+}
+
+// WriteDiffFile 将差异写到path, 先写临时文件再改名, 中途失败不会留下不完整的文件
+func WriteDiffFile(path, inputPath string, diffs []*callgraph.Diff, calls callgraph.Calls) error {
+	data, err := json.MarshalIndent(&DiffFile{
+		Version:   DiffFileVersion,
+		InputPath: inputPath,
+		Options:   callgraph.OptionsKey(),
+		Created:   time.Now(),
+		Diffs:     diffs,
+		Calls:     calls,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadDiffFile 读取WriteDiffFile写出的差异, 版本不一致时报错
+func ReadDiffFile(path string) (*DiffFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := new(DiffFile)
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("read %s: %v", path, err)
+	}
+	if f.Version != DiffFileVersion {
+		return nil, fmt.Errorf("%s has diff file version %d, this leo reads version %d", path, f.Version, DiffFileVersion)
+	}
+	return f, nil
+}
+
+// Rebase 项目移动到inputPath后, 把差异中以原项目路径开头的文件路径换成新的路径
+func (f *DiffFile) Rebase(inputPath string) {
+	if f.InputPath == "" || f.InputPath == inputPath {
+		return
+	}
+	rebase := func(path string) string {
+		if res := util.CompareAndExchange(path, inputPath, f.InputPath); res != "" {
+			return res
+		}
+		return path
+	}
+	for _, d := range f.Diffs {
+		if d.Func != nil {
+			d.Func.FilePath = rebase(d.Func.FilePath)
+		}
+		for _, node := range []*callgraph.Node{d.NodeA, d.NodeB} {
+			if node == nil {
+				continue
+			}
+			node.Caller.FilePath = rebase(node.Caller.FilePath)
+			node.Callee.FilePath = rebase(node.Callee.FilePath)
+			for _, site := range node.Sites {
+				site.File = rebase(site.File)
+			}
+		}
+	}
+	f.InputPath = inputPath
+}
+
+// Inject 从差异文件向inputPath的源码注入日志并输出到outputPath, 不重新生成调用图
+func Inject(inputPath, outputPath string) error {
+	path := diffFilePath(outputPath)
+	f, err := ReadDiffFile(path)
+	if err != nil {
+		return err
+	}
+	if f.InputPath != inputPath {
+		log.Printf("[leo] WARN 差异文件生成于%v, 路径换成%v", f.InputPath, inputPath)
+	}
+	f.Rebase(inputPath)
+	log.Printf("[leo] INFO 从%v读取了%v个diff: %v", path, len(f.Diffs), callgraph.CountDiffs(f.Diffs))
+	return DiffLog(inputPath, outputPath, f.Diffs, f.Calls)
+}
@@ -0,0 +1,55 @@
+package _log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dataznGao/leo/pkg/callgraph"
+)
+
+func TestDiffFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.diffs.json")
+	diffs := []*callgraph.Diff{{
+		Kind:   callgraph.EdgeRemovedDiff,
+		Source: callgraph.SourceStatic,
+		NodeA: &callgraph.Node{
+			Caller: &callgraph.Func{FilePath: "/old/proj/a", FuncName: "A"},
+			Callee: &callgraph.Func{FilePath: "fmt", FuncName: "Println"},
+			Sites:  []*callgraph.CallSite{{File: "/old/proj/a/a.go", Line: 3, Kind: callgraph.CallStatic}},
+		},
+	}, {
+		Kind:   callgraph.NodeAddedDiff,
+		Source: callgraph.SourceDynamic,
+		Func:   &callgraph.Func{FilePath: "/old/proj/b", FuncName: "B"},
+	}}
+	if err := WriteDiffFile(path, "/old/proj", diffs, nil); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ReadDiffFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Version != DiffFileVersion || len(f.Diffs) != 2 || f.Diffs[0].Key() != diffs[0].Key() {
+		t.Fatalf("round trip lost diffs: %+v", f)
+	}
+	f.Rebase("/new/proj")
+	if got := f.Diffs[0].NodeA.Sites[0].File; got != "/new/proj/a/a.go" {
+		t.Errorf("site not rebased: %v", got)
+	}
+	if got := f.Diffs[0].NodeA.Callee.FilePath; got != "fmt" {
+		t.Errorf("package outside the project should stay: %v", got)
+	}
+	if got := f.Diffs[1].Func.FilePath; got != "/new/proj/b" {
+		t.Errorf("node diff not rebased: %v", got)
+	}
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), fmt.Sprintf(`"version": %d`, DiffFileVersion), `"version": 99`, 1)), 0666)
+	if _, err := ReadDiffFile(path); err == nil {
+		t.Errorf("a diff file of another version should be rejected")
+	}
+}
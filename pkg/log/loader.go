@@ -1,10 +1,17 @@
 package _log
 
 import (
+	"github.com/dataznGao/leo/pkg/callgraph"
 	_ast "github.com/dataznGao/leo/pkg/log/ast"
 	"github.com/dataznGao/leo/util"
+	"go/ast"
 	"go/parser"
 	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/packages"
 )
 
 // LoadPackage 加载需要添加日志的文件夹，返回文件名对应的文件
@@ -16,12 +23,13 @@ func LoadPackage(path string) (map[string]*_ast.File, []string, error) {
 	files := make(map[string]*_ast.File, 0)
 	fset := token.NewFileSet() // positions are relative to fset
 	for _, file := range m {
-		f, err1 := parser.ParseFile(fset, file, nil, 0)
+		f, err1 := parser.ParseFile(fset, file, nil, parser.ParseComments)
 		if err1 != nil {
 			return nil, nil, err1
 		}
 		lf := &_ast.File{
 			File:   f,
+			Fset:   fset,
 			Logged: false,
 		}
 		files[file] = lf
@@ -29,3 +37,91 @@ func LoadPackage(path string) (map[string]*_ast.File, []string, error) {
 
 	return files, n, nil
 }
+
+// LoadTypes 用go/packages对inputPath下的项目做类型检查, 复用files中已经解析的语法树,
+// 类型信息记录在对应的File.Info中。按-tags和-matrix的构建配置加载, 设置了-matrix时依次加载每个配置,
+// 文件取第一个包含它的配置的类型信息。类型检查失败时打印警告, 没有类型信息的文件注入日志时按名字匹配被调用者
+func LoadTypes(inputPath string, files map[string]*_ast.File) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("[leo] WARN 类型检查失败, 不使用类型信息: %v", err)
+		}
+	}()
+	byPath := make(map[string]*_ast.File, len(files))
+	var fset *token.FileSet
+	for name, f := range files {
+		if abs, err := filepath.Abs(name); err == nil {
+			byPath[abs] = f
+		}
+		fset = f.Fset
+	}
+	if fset == nil {
+		return
+	}
+	ws, err := util.LoadWorkspace(inputPath)
+	if err != nil {
+		log.Printf("[leo] WARN 类型检查失败, 不使用类型信息: %v", err)
+		return
+	}
+	tmpDir, err := os.MkdirTemp("", "leo-types")
+	if err != nil {
+		log.Printf("[leo] WARN 类型检查失败, 不使用类型信息: %v", err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	env, err := ws.GoWorkEnv(tmpDir)
+	if err != nil {
+		log.Printf("[leo] WARN 类型检查失败, 不使用类型信息: %v", err)
+		return
+	}
+	configs, err := callgraph.MatrixConfigs()
+	if err != nil {
+		log.Printf("[leo] WARN 类型检查失败, 不使用类型信息: %v", err)
+		return
+	}
+	checked := 0
+	for _, config := range configs {
+		buildFlags, configEnv := config.LoadFlags()
+		cfg := &packages.Config{
+			Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports |
+				packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+			Dir:        inputPath,
+			Env:        append(append(os.Environ(), env...), configEnv...),
+			BuildFlags: buildFlags,
+			Tests:      true,
+			Fset:       fset,
+			// 返回已经解析的语法树, 类型信息才能对应到注入日志时修改的节点上
+			ParseFile: func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+				if f, ok := byPath[filename]; ok {
+					return f.File, nil
+				}
+				return parser.ParseFile(fset, filename, src, 0)
+			},
+		}
+		pkgs, err := packages.Load(cfg, ws.Patterns()...)
+		if err != nil {
+			log.Printf("[leo] WARN 构建配置%q类型检查失败: %v", config.Name(), err)
+			continue
+		}
+		errs := make([]packages.Error, 0)
+		packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+			errs = append(errs, pkg.Errors...)
+			if pkg.TypesInfo == nil {
+				return
+			}
+			for _, name := range pkg.CompiledGoFiles {
+				if f, ok := byPath[name]; ok && f.Info == nil {
+					f.Info = pkg.TypesInfo
+					checked++
+				}
+			}
+		})
+		if len(errs) > 0 {
+			log.Printf("[leo] WARN 构建配置%q类型检查有%v个错误, 第一个: %v", config.Name(), len(errs), errs[0])
+		}
+	}
+	log.Printf("[leo] INFO 类型检查了%v/%v个文件", checked, len(files))
+	if checked < len(files) {
+		log.Printf("[leo] WARN %v个文件没有类型信息, 在其中注入日志时按名字匹配被调用者", len(files)-checked)
+	}
+}
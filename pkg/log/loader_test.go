@@ -0,0 +1,102 @@
+package _log
+
+import (
+	"go/ast"
+	"go/build"
+	"go/types"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeFiles 在临时目录中生成测试用的项目
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, code := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(code), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// skipIfLoaderBroken golang.org/x/tools v0.4.0的go/packages在新版本的go上无法做类型检查
+func skipIfLoaderBroken(t *testing.T) {
+	if _, ok := types.SizesFor("gc", runtime.GOARCH).(*types.StdSizes); !ok {
+		t.Skip("golang.org/x/tools v0.4.0 cannot type-check packages with this Go toolchain")
+	}
+}
+
+func TestLoadTypes(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeFiles(t, map[string]string{
+		"go.mod":      "module example.com/types\n\ngo 1.18\n",
+		"a/a.go":      "package a\n\nimport \"errors\"\n\nfunc Fetch() error { return errors.New(\"x\") }\n",
+		"a/a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestFetch(t *testing.T) { err := Fetch(); _ = err }\n\nvar Helper = Fetch\n",
+		"a/x_test.go": "package a_test\n\nimport \"example.com/types/a\"\n\nvar _ = a.Helper\n",
+		"b/b.go":      "package b\n\nimport \"example.com/types/a\"\n\nfunc Get() error { return a.Fetch() }\n",
+	})
+	files, _, err := LoadPackage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadTypes(dir, files)
+	test := files[filepath.Join(dir, "a", "a_test.go")]
+	if test == nil || test.Info == nil {
+		t.Fatalf("no type information for a_test.go")
+	}
+	found := false
+	ast.Inspect(test.File, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == "err" {
+			if obj := test.Info.ObjectOf(ident); obj != nil && obj.Type().String() == "error" {
+				found = true
+			}
+		}
+		return true
+	})
+	if !found {
+		t.Errorf("the type information does not cover the parsed syntax tree")
+	}
+	// 依赖工作区中其他包的文件和外部测试包也要有完整的类型信息
+	for _, name := range []string{"b/b.go", "a/x_test.go"} {
+		f := files[filepath.Join(dir, name)]
+		if f == nil || f.Info == nil {
+			t.Fatalf("no type information for %v", name)
+		}
+		ast.Inspect(f.File, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok && f.Info.Uses[sel.Sel] == nil {
+				t.Errorf("%v: %v.%v is not resolved", name, sel.X, sel.Sel.Name)
+			}
+			return true
+		})
+	}
+}
+
+func TestLoadTypesTags(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeFiles(t, map[string]string{
+		"go.mod":            "module example.com/tags\n\ngo 1.18\n",
+		"a/a.go":            "package a\n\nfunc Run() error { return impl() }\n",
+		"a/impl_default.go": "//go:build !special\n\npackage a\n\nfunc impl() error { return nil }\n",
+		"a/impl_special.go": "//go:build special\n\npackage a\n\nfunc impl() error { return nil }\n",
+	})
+	defer func(tags []string) { build.Default.BuildTags = tags }(build.Default.BuildTags)
+	build.Default.BuildTags = []string{"special"}
+	files, _, err := LoadPackage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadTypes(dir, files)
+	// 按-tags加载, 只有满足构建约束的实现有类型信息
+	if f := files[filepath.Join(dir, "a", "impl_special.go")]; f == nil || f.Info == nil {
+		t.Errorf("the file selected by -tags has no type information")
+	}
+	if f := files[filepath.Join(dir, "a", "impl_default.go")]; f == nil || f.Info != nil {
+		t.Errorf("the file excluded by -tags should have no type information")
+	}
+}
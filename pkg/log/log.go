@@ -2,9 +2,12 @@ package _log
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/dataznGao/bingo"
+	"github.com/dataznGao/bingo/core/transformer"
 	"github.com/dataznGao/leo/constant"
+	"github.com/dataznGao/leo/pkg/cache"
 	"github.com/dataznGao/leo/pkg/caller"
 	"github.com/dataznGao/leo/pkg/callgraph"
 	_ast "github.com/dataznGao/leo/pkg/log/ast"
@@ -18,6 +21,14 @@ import (
 
 var threshold = 100
 
+var (
+	logValues = flag.Bool("logvalues", false, "Include the arguments of the changed call and the caller's receiver and parameters in each log as key=value pairs; structs are expanded to their basic-typed fields, other values are skipped (needs type information).")
+	logMaxLen = flag.Int("logmaxlen", 64, "With -logvalues, print at most this many characters of each value (0 means unlimited).")
+	redact    = flag.String("redact", "password,passwd,secret,token,apikey,credential", "With -logvalues, print <redacted> instead of values whose names or field paths contain any of these strings, case-insensitively (separated by comma).")
+)
+
+var placementFlag = flag.String("placement", string(_ast.PlaceAfter), "Where to put each log relative to the changed call [after | before | err | entry | else]: after the statement, before it, inside the following 'if err != nil' branch, at the caller's entry and exit, or in the else branch. Set it per fault type with e.g. 'exception-unhandled=err,null=before,default=after'; a diff caused by several fault types uses the first one listed.")
+
 func Log(inputPath, outputPath string) error {
 	// 1. 启动服务端
 	go func() { caller.StartServe() }()
@@ -27,46 +38,81 @@ func Log(inputPath, outputPath string) error {
 		return err
 	}
 	allDiffs := make([]*callgraph.Diff, 0)
+	// calls 所有测试文件夹的原始、故障调用图中的调用关系, 用于计算注入点的覆盖
+	calls := make(callgraph.Calls)
 
 	if len(testPath) < threshold {
 		threshold = len(testPath)
 	}
-	// 3. 进行diff图生成
+	// 3. 进行diff图生成, 每个测试文件夹完成后都保存一次差异文件, 中途失败也能用leo inject注入
+	diffFile := diffFilePath(outputPath)
+	// skippedRaw、skippedFaulty -tolerant模式下原始、故障项目中跳过的包, 命中缓存时来自缓存
+	var skippedRaw, skippedFaulty []*callgraph.SkippedPackage
 	cnt := 0
 	for _, s := range testPath {
-		if diffs, err := generateDiff(inputPath, s, outputPath); err != nil {
+		if res, err := generateDiff(inputPath, s, outputPath); err != nil {
 			log.Printf("[leo] WARN testPath: %v run has err: %v\n", s, err)
 		} else {
-			allDiffs = append(allDiffs, diffs...)
+			allDiffs = callgraph.MergeDiffs(append(allDiffs, res.Diffs...))
+			for _, g := range []callgraph.EdgeMap{res.Raw, res.Faulty, res.DyRaw, res.DyFaulty} {
+				calls.Merge(g)
+			}
+			skippedRaw = mergeSkipped(skippedRaw, res.SkippedRaw)
+			skippedFaulty = mergeSkipped(skippedFaulty, res.SkippedFaulty)
+			if err := WriteDiffFile(diffFile, inputPath, allDiffs, calls); err != nil {
+				log.Printf("[leo] WARN 差异文件保存失败, err: %v", err)
+			}
 			cnt++
 			if cnt == threshold {
 				break
 			}
 		}
 	}
-	os.RemoveAll(tmpPath)
-	os.RemoveAll(removeDir)
+	for _, p := range faultyProjects {
+		os.RemoveAll(p.enhanced)
+		os.RemoveAll(p.dir)
+	}
 	os.RemoveAll(realInputPath)
-	allDiffs = callgraph.DedupDiff(allDiffs)
+	reportSkipped("raw", skippedRaw)
+	reportSkipped("faulty", skippedFaulty)
+	rawProgram, faultyProjects = nil, nil
+	srcHash = ""
+	if noiseCount > 0 {
+		log.Printf("[leo] INFO 动态调用图基线共去掉了%v个由抖动引起的差异", noiseCount)
+	}
+	noiseCount = 0
+	if cnt > 0 {
+		log.Printf("[leo] INFO 差异已保存到%v, 可以用leo inject重新注入", diffFile)
+	}
 	// 4. 根据diff图打日志
-	return DiffLog(inputPath, outputPath, allDiffs)
+	return DiffLog(inputPath, outputPath, allDiffs, calls)
 }
 
 var (
-	removeDir string = ""
 	// 增强后输入的路径
 	realInputPath string = ""
-	// tmpPath
-	tmpPath    string = ""
-	isFirst           = false
-	isModFirst        = false
+	isFirst              = false
+	// 原始、故障项目只加载分析一次, 各测试文件夹从中切出自己的调用图
+	rawProgram *callgraph.Program
 )
 
-func DiffLog(inputPath, outputPath string, diffs []*callgraph.Diff) error {
+// DiffLog 按差异向inputPath的源码注入日志并输出到outputPath, calls为计算注入点覆盖用的调用关系
+func DiffLog(inputPath, outputPath string, diffs []*callgraph.Diff, calls callgraph.Calls) error {
+	placements, err := _ast.ParsePlacements(*placementFlag, faultNames())
+	if err != nil {
+		return err
+	}
+	_ast.LogPlacements = placements
+	_ast.LogValues = &_ast.ValueRules{Enabled: *logValues, MaxLen: *logMaxLen, Redact: strings.Split(*redact, ",")}
 	files, notGoFiles, err := LoadPackage(inputPath)
 	if err != nil {
 		return err
 	}
+	LoadTypes(inputPath, files)
+	diffs = budgetDiffs(coverDiffs(diffs, calls, inputPath))
+	if err := callgraph.ExportLogSites(diffs); err != nil {
+		log.Printf("[leo] WARN 注入点导出失败, err: %v", err)
+	}
 	// 注入error, 并产生import log
 	for k, file := range files {
 		code, hasLogged := _ast.InjureLog(k, file, diffs)
@@ -86,10 +132,12 @@ func DiffLog(inputPath, outputPath string, diffs []*callgraph.Diff) error {
 }
 
 // generateDiff inputPath: 项目文件夹所在的地址, testPath: 单元测试所在的文件夹地址, outputPath: 日志增强后所在的地址
-func generateDiff(inputPath, testPath, outputPath string) ([]*callgraph.Diff, error) {
+func generateDiff(inputPath, testPath, outputPath string) (res *diffResult, err error) {
+	// 一个测试文件夹中故障注入或分析panic时只跳过这个测试文件夹
 	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println("Recovered from:", err)
+		if r := recover(); r != nil {
+			fmt.Println("Recovered from:", r)
+			res, err = nil, fmt.Errorf("panic: %v", r)
 		}
 	}()
 	// 校验
@@ -101,20 +149,150 @@ func generateDiff(inputPath, testPath, outputPath string) ([]*callgraph.Diff, er
 	if !strings.HasPrefix(testPath, inputPath) {
 		return nil, errors.New("[bingo] the testPath or inputPath set err! please check! err")
 	}
+	key := diffCacheKey(inputPath, testPath)
+	res = new(diffResult)
+	if key != "" && cache.Get(key, res) {
+		log.Printf("[leo] INFO testPath: %v 命中缓存, 跳过调用图生成", testPath)
+		callgraph.AddTestPackages(inputPath, res.TestPackages)
+	} else {
+		res, err = generateGraphs(inputPath, testPath)
+		if err != nil {
+			return nil, err
+		}
+		res.TestPackages = callgraph.TestPackages(inputPath)
+		log.Printf("[leo] INFO 开始比对调用图")
+		// 每种故障分别与原始调用图比对, 同一处差异合并时记下所有造成它的故障种类
+		all := make([]*callgraph.Diff, 0)
+		for _, f := range res.faults {
+			diffs := callgraph.Compare(res.Raw, f.static, inputPath, callgraph.SourceStatic)
+			dyDiffs := callgraph.Compare(res.DyRaw, f.dynamic, inputPath, callgraph.SourceDynamic)
+			dyDiffs = append(dyDiffs, callgraph.CompareContexts(res.CtxRaw, f.ctx, inputPath)...)
+			if res.Baseline != nil {
+				var noise []*callgraph.Diff
+				dyDiffs, noise = res.Baseline.FilterDiffs(dyDiffs, inputPath)
+				res.Noise = callgraph.MergeDiffs(append(res.Noise, noise...))
+			}
+			diffs = append(diffs, dyDiffs...)
+			callgraph.AnnotateFault(diffs, f.fault, f.mutants)
+			all = append(all, diffs...)
+		}
+		res.Diffs = callgraph.MergeDiffs(all)
+		callgraph.AnnotateImpact(res.Diffs, []callgraph.EdgeMap{res.Raw, res.Faulty, res.DyRaw, res.DyFaulty}, res.Failed, inputPath)
+		if key != "" {
+			if err := cache.Put(key, res); err != nil {
+				log.Printf("[leo] WARN 缓存写入失败, err: %v", err)
+			}
+		}
+	}
+
+	name := graphName(inputPath, testPath)
+	staticDiffs := callgraph.DiffsFrom(res.Diffs, callgraph.SourceStatic)
+	dynamicDiffs := callgraph.DiffsFrom(res.Diffs, callgraph.SourceDynamic)
+	if err := callgraph.RenderDiffFiles(name+".static", res.Raw, res.Faulty, staticDiffs, inputPath); err != nil {
+		log.Printf("[leo] WARN 静态调用图输出失败, err: %v", err)
+	}
+	if err := callgraph.RenderDiffFiles(name+".dynamic", res.DyRaw, res.DyFaulty, dynamicDiffs, inputPath); err != nil {
+		log.Printf("[leo] WARN 动态调用图输出失败, err: %v", err)
+	}
+	if err := callgraph.ExportFiles(name+".static", res.Raw, res.Faulty, staticDiffs, inputPath); err != nil {
+		log.Printf("[leo] WARN 静态调用图导出失败, err: %v", err)
+	}
+	if err := callgraph.ExportFiles(name+".dynamic", res.DyRaw, res.DyFaulty, dynamicDiffs, inputPath); err != nil {
+		log.Printf("[leo] WARN 动态调用图导出失败, err: %v", err)
+	}
+	if err := callgraph.ExportContexts(name, res.CtxRaw, res.CtxFaulty); err != nil {
+		log.Printf("[leo] WARN 调用上下文树导出失败, err: %v", err)
+	}
+	if res.Baseline != nil {
+		noiseCount += len(res.Noise)
+		log.Printf("[leo] INFO 动态调用图基线去掉了%v个由抖动引起的差异", len(res.Noise))
+		if err := callgraph.ExportBaseline(name, res.Baseline, res.Noise); err != nil {
+			log.Printf("[leo] WARN 动态调用图基线导出失败, err: %v", err)
+		}
+	}
+	// /Users/misery/GolandProjects/rpc_demo/tttt/aaas MyT RunClient1
+	// /Users/misery/GolandProjects/rpc_demo/tttt/aaas MyT RunClient1$1
+	log.Printf("[leo] INFO 共有%v个diff: %v", len(res.Diffs), callgraph.CountDiffs(res.Diffs))
+	log.Printf("[leo] INFO 调用图比对完成")
+	return res, nil
+}
+
+// diffResult 一个测试文件夹的原始、故障调用图(静态和动态)以及比对结果, 会被缓存
+type diffResult struct {
+	Raw      callgraph.EdgeMap `json:"raw"`
+	Faulty   callgraph.EdgeMap `json:"faulty"`
+	DyRaw    callgraph.EdgeMap `json:"dyRaw"`
+	DyFaulty callgraph.EdgeMap `json:"dyFaulty"`
+	Diffs    []*callgraph.Diff `json:"diffs"`
+	// Failed 故障代码上是否有测试失败
+	Failed bool `json:"failed,omitempty"`
+	// CtxRaw、CtxFaulty 原始、故障代码的动态调用上下文树
+	CtxRaw    *callgraph.ContextTree `json:"ctxRaw,omitempty"`
+	CtxFaulty *callgraph.ContextTree `json:"ctxFaulty,omitempty"`
+	// Baseline 设置了-baselineRuns时未注入故障的动态调用图基线, Noise为被它去掉的动态差异
+	Baseline *callgraph.Baseline `json:"baseline,omitempty"`
+	Noise    []*callgraph.Diff   `json:"noise,omitempty"`
+	// SkippedRaw、SkippedFaulty -tolerant模式下加载原始、故障项目时跳过的包
+	SkippedRaw    []*callgraph.SkippedPackage `json:"skippedRaw,omitempty"`
+	SkippedFaulty []*callgraph.SkippedPackage `json:"skippedFaulty,omitempty"`
+	// TestPackages 加载原始项目时识别出的外部测试包和测试main包, 命中缓存时重新登记
+	TestPackages map[string]string `json:"testPackages,omitempty"`
+	// faults 每种故障的调用图, Faulty、DyFaulty、CtxFaulty为它们的并集, 只用于比对, 不缓存
+	faults []*faultRun
+}
+
+// faultNames 注入的故障种类的名字
+func faultNames() []string {
+	names := make([]string, 0, len(faultTypes))
+	for _, f := range faultTypes {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// faultsKey 注入的故障种类, 作为缓存键的一部分
+func faultsKey() string {
+	return "faults=" + strings.Join(faultNames(), ",")
+}
+
+// srcHash 本次运行中项目源码的哈希, 只计算一次
+var srcHash string
+
+// noiseCount 本次运行中被动态调用图基线去掉的差异个数
+var noiseCount int
+
+// diffCacheKey 由项目源码、分析选项、故障种类和测试文件夹决定的缓存键, 未开启缓存或计算失败时返回空字符串
+func diffCacheKey(inputPath, testPath string) string {
+	if !cache.Enabled() {
+		return ""
+	}
+	if srcHash == "" {
+		hash, err := cache.HashTree(inputPath)
+		if err != nil {
+			log.Printf("[leo] WARN 计算源码哈希失败, 不使用缓存, err: %v", err)
+			return ""
+		}
+		srcHash = hash
+	}
+	// diff中的调用点是拼上inputPath的绝对路径, inputPath也要作为键的一部分
+	return cache.Key(srcHash, callgraph.OptionsKey(), faultsKey(), inputPath, graphName(inputPath, testPath))
+}
+
+// generateGraphs 插桩、故障注入并生成testPath的原始调用图和每种故障的故障调用图
+func generateGraphs(inputPath, testPath string) (*diffResult, error) {
 	// leo/scene1.test.init
-	index := strings.LastIndex(inputPath, constant.Separator)
-	removeDir1 := inputPath[:index] + constant.Separator + "leo_tmp"
-	// 保证临时文件夹会被删除
-	if removeDir == "" && removeDir1 != "" {
-		os.RemoveAll(removeDir1)
-		removeDir = removeDir1
+	parent := inputPath[:strings.LastIndex(inputPath, constant.Separator)]
+	if faultyProjects == nil {
+		faultyProjects = newFaultyProjects(parent)
 	}
 	group := task.NewGroup(2)
-	rawCallGraph := make(map[string]map[string]string, 0)
-	modCallGraph := make(map[string]map[string]string, 0)
-	dyRawCallGraph := make(map[string]map[string]string, 0)
-	dyModCallGraph := make(map[string]map[string]string, 0)
-	var err error
+	rawCallGraph := make(callgraph.EdgeMap, 0)
+	dyRawCallGraph := make(callgraph.EdgeMap, 0)
+	var ctxRaw *callgraph.ContextTree
+	var baseline *callgraph.Baseline
+	var rawErr error
+	faults := make([]*faultRun, len(faultyProjects))
+	faultErrs := make([]error, len(faultyProjects))
 
 	// 1. 原始调用图生成
 	group.Add(func() {
@@ -122,8 +300,7 @@ func generateDiff(inputPath, testPath, outputPath string) ([]*callgraph.Diff, er
 		log.Printf("[leo] INFO ===== 原始调用图生成开始 =====")
 		log.Printf("[leo] INFO ===== 动态原始调用图生成开始 =====")
 		// 0. 对代码进行插桩
-		_index := strings.LastIndex(inputPath, constant.Separator)
-		realInputPath1 := inputPath[:_index] + constant.Separator + constant.EnhanceInputPath
+		realInputPath1 := parent + constant.Separator + constant.EnhanceInputPath
 		// 保证临时文件夹会被删除
 		isFirst = false
 		if realInputPath == "" && realInputPath1 != "" {
@@ -136,96 +313,239 @@ func generateDiff(inputPath, testPath, outputPath string) ([]*callgraph.Diff, er
 		// 原始调用图用0标识，如果是第一次插入才需要run
 		num := 0
 		if isFirst {
-			err = InsertCollector(inputPath, realInputPath, num)
-			if err != nil {
+			if err := InsertCollector(inputPath, realInputPath, num); err != nil {
 				log.Printf("[leo] ERROR ===== 动态调用图插桩失败 =====")
 			}
 		}
-		dyRawCallGraph, err = callgraph.DynamicAnal(realInputPath, myTestPath, num)
+		var err error
+		dyRawCallGraph, _, err = callgraph.DynamicAnal(realInputPath, myTestPath, num)
 		if err != nil {
 			log.Printf("[leo] ERROR ===== 动态调用图生成失败 =====")
 		}
+		// 基线会再次运行测试, 调用上下文树要在那之前取
+		ctxRaw = callgraph.DynamicContext(num)
+		if runs := callgraph.BaselineRuns(); runs > 1 {
+			log.Printf("[leo] INFO ===== 动态调用图基线生成开始 =====")
+			var berr error
+			if baseline, berr = callgraph.DynamicBaseline(realInputPath, myTestPath, num, runs); berr != nil {
+				log.Printf("[leo] WARN ===== 动态调用图基线生成失败, 不过滤抖动: %v =====", berr)
+			}
+		}
 		log.Printf("[leo] INFO ===== 静态原始调用图生成开始 =====")
-		rawCallGraph, err = callgraph.Anal(realInputPath, myTestPath)
-		if err != nil {
+		// 静态调用图分析未插桩的原项目, 调用点的行号才能与注入日志的源码对应
+		rawCallGraph, rawErr = staticAnal(&rawProgram, inputPath, testPath)
+		if rawErr != nil {
 			log.Printf("[leo] WARN ===== 原始调用图生成失败 =====")
 		}
 		log.Printf("[leo] INFO ===== 原始调用图生成完毕 =====")
 	})
 
-	// 2. 调用故障注入，故障调用图生成
+	// 2. 每种故障各自注入一份代码，生成故障调用图
 	group.Add(func() {
-		myTestPath := testPath
-		tmpPath1 := removeDir
-		log.Printf("tmpPath: %v", tmpPath1)
-		isModFirst = false
-		if tmpPath == "" && tmpPath1 != "" {
-			os.RemoveAll(tmpPath1)
-			tmpPath = tmpPath1
-			isModFirst = true
-		}
-		// 0. 对代码进行插桩
-		_index := strings.LastIndex(tmpPath, constant.Separator)
-		realInputPath1 := tmpPath[:_index] + constant.Separator + constant.TmpEnhanceInputPath
-		// 测试文件的相对位置改变
-		myTestPath = util.CompareAndExchange(myTestPath, realInputPath1, inputPath)
-		// 故障调用图用1标识
-		num := 1
-		if isModFirst {
-			err = InsertCollector(inputPath, realInputPath1, num)
-			if err != nil {
-				log.Printf("[leo] ERROR ===== 动态调用图插桩失败 =====")
-			}
-			// 进行故障注入
-			env := bingo.CreateMutationEnv(realInputPath1, tmpPath, myTestPath)
-			env.SyncFault("*.*.*.*")
-			env.SwitchMissDefaultFault("*.*.*.*")
-			env.ExceptionUncaughtFault("*.*.*.*")
-			env.ExceptionShortcircuitFault("*.*.*.*")
-			env.ExceptionUnhandledFault("*.*.*.*")
-			env.NullFault("*.*.*.*")
-			f := bingo.MutationPerformer{}
-			log.Printf("[leo] INFO ===== 故障注入启动 =====")
-			err := f.SetEnv(env).Run(true)
-			if err != nil {
-				log.Fatal("fault inject err !")
-			}
-			log.Printf("[leo] INFO ===== 故障注入完毕 =====")
-			log.Printf("[leo] INFO ===== 故障调用图生成开始 =====")
-			log.Printf("[leo] INFO ===== 原始调用图生成开始 =====")
-			log.Printf("[leo] INFO ===== 动态原始调用图生成开始 =====")
-
-		}
-		isModFirst = false
-		myTestPath = util.CompareAndExchange(myTestPath, tmpPath, realInputPath1)
-		dyModCallGraph, err = callgraph.DynamicAnal(tmpPath, myTestPath, num)
-		if err != nil {
-			log.Printf("[leo] ERROR ===== 故障动态调用图生成失败 =====")
+		for i, p := range faultyProjects {
+			faults[i], faultErrs[i] = p.anal(inputPath, testPath)
 		}
-		modCallGraph, err = callgraph.Anal(tmpPath, myTestPath)
-		if err != nil {
-			log.Printf("[leo] WARN ===== 故障调用图生成失败 =====")
-		}
-		log.Printf("[leo] INFO ===== 故障调用图生成完毕 =====")
 	})
 	group.Start()
 	group.Wait()
+	if rawErr != nil {
+		return nil, rawErr
+	}
+	for _, err := range faultErrs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	res := &diffResult{
+		Raw:      rawCallGraph,
+		DyRaw:    dyRawCallGraph,
+		CtxRaw:   ctxRaw,
+		Baseline: baseline,
+		faults:   faults,
+	}
+	statics, dynamics := make([]callgraph.EdgeMap, 0, len(faults)), make([]callgraph.EdgeMap, 0, len(faults))
+	res.CtxFaulty = callgraph.NewContextTree()
+	for _, f := range faults {
+		statics = append(statics, f.static)
+		dynamics = append(dynamics, f.dynamic)
+		res.CtxFaulty.Merge(f.ctx)
+		res.Failed = res.Failed || f.failed
+	}
+	res.Faulty, res.DyFaulty = callgraph.UnionGraphs(statics...), callgraph.UnionGraphs(dynamics...)
+	if rawProgram != nil {
+		res.SkippedRaw = rawProgram.Skipped()
+	}
+	for _, p := range faultyProjects {
+		if p.program != nil {
+			res.SkippedFaulty = mergeSkipped(res.SkippedFaulty, p.program.Skipped())
+		}
+	}
+	return res, nil
+}
+
+// faultType bingo的一种故障, name用于缓存键和差异的影响
+type faultType struct {
+	name   string
+	inject func(env *bingo.MutationEnv)
+}
+
+// faultTypes 注入的故障种类, 每种单独注入到一份代码中, 才能知道差异是哪种故障造成的
+var faultTypes = []faultType{
+	{"sync", func(env *bingo.MutationEnv) { env.SyncFault("*.*.*.*") }},
+	{"switch-miss-default", func(env *bingo.MutationEnv) { env.SwitchMissDefaultFault("*.*.*.*") }},
+	{"exception-uncaught", func(env *bingo.MutationEnv) { env.ExceptionUncaughtFault("*.*.*.*") }},
+	{"exception-shortcircuit", func(env *bingo.MutationEnv) { env.ExceptionShortcircuitFault("*.*.*.*") }},
+	{"exception-unhandled", func(env *bingo.MutationEnv) { env.ExceptionUnhandledFault("*.*.*.*") }},
+	{"null", func(env *bingo.MutationEnv) { env.NullFault("*.*.*.*") }},
+}
+
+// faultyProject 注入了一种故障的项目, 第一个未命中缓存的测试文件夹插桩并注入, 之后的测试文件夹复用
+type faultyProject struct {
+	fault faultType
+	// num 动态调用图的编号, 原始调用图为0
+	num int
+	// enhanced 插桩后的项目, dir 在enhanced上注入故障后的项目
+	enhanced string
+	dir      string
+	// injected 是否已经注入, err为注入失败的原因
+	injected bool
+	err      error
+	// mutants bingo注入的变异个数
+	mutants int
+	program *callgraph.Program
+}
+
+// faultyProjects 每种故障对应的项目, Log结束时删除
+var faultyProjects []*faultyProject
+
+func newFaultyProjects(parent string) []*faultyProject {
+	res := make([]*faultyProject, 0, len(faultTypes))
+	for i, f := range faultTypes {
+		res = append(res, &faultyProject{
+			fault:    f,
+			num:      i + 1,
+			enhanced: parent + constant.Separator + constant.TmpEnhanceInputPath + "_" + f.name,
+			dir:      parent + constant.Separator + "leo_tmp_" + f.name,
+		})
+	}
+	return res
+}
+
+// inject 插桩并注入故障, 只在第一次调用时执行
+func (p *faultyProject) inject(inputPath, testPath string) error {
+	if p.injected {
+		return p.err
+	}
+	p.injected = true
+	os.RemoveAll(p.enhanced)
+	os.RemoveAll(p.dir)
+	if err := InsertCollector(inputPath, p.enhanced, p.num); err != nil {
+		log.Printf("[leo] ERROR ===== 动态调用图插桩失败 =====")
+	}
+	env := bingo.CreateMutationEnv(p.enhanced, p.dir, util.CompareAndExchange(testPath, p.enhanced, inputPath))
+	p.fault.inject(env)
+	log.Printf("[leo] INFO ===== 故障注入启动: %v =====", p.fault.name)
+	// OutInfo记录了bingo写出的每个变异
+	before := len(transformer.OutInfo)
+	if err := (&bingo.MutationPerformer{}).SetEnv(env).Run(true); err != nil {
+		p.err = fmt.Errorf("inject %s faults: %v", p.fault.name, err)
+		return p.err
+	}
+	p.mutants = len(transformer.OutInfo) - before
+	log.Printf("[leo] INFO ===== 故障注入完毕: %v, 共%v个变异 =====", p.fault.name, p.mutants)
+	return nil
+}
+
+// faultRun 一个测试文件夹在注入了一种故障的项目上的调用图
+type faultRun struct {
+	fault   string
+	mutants int
+	static  callgraph.EdgeMap
+	dynamic callgraph.EdgeMap
+	ctx     *callgraph.ContextTree
+	// failed 故障代码上是否有测试失败
+	failed bool
+}
+
+// anal 生成testPath在注入了该种故障的项目上的静态、动态调用图
+func (p *faultyProject) anal(inputPath, testPath string) (*faultRun, error) {
+	if err := p.inject(inputPath, testPath); err != nil {
+		return nil, err
+	}
+	log.Printf("[leo] INFO ===== 故障调用图生成开始: %v =====", p.fault.name)
+	// 测试文件的相对位置改变
+	myTestPath := util.CompareAndExchange(testPath, p.dir, inputPath)
+	run := &faultRun{fault: p.fault.name, mutants: p.mutants}
+	var err error
+	run.dynamic, run.failed, err = callgraph.DynamicAnal(p.dir, myTestPath, p.num)
 	if err != nil {
+		log.Printf("[leo] ERROR ===== 故障动态调用图生成失败 =====")
+	}
+	run.ctx = callgraph.DynamicContext(p.num)
+	run.static, err = staticAnal(&p.program, p.dir, myTestPath)
+	if err != nil {
+		log.Printf("[leo] WARN ===== 故障调用图生成失败 =====")
 		return nil, err
 	}
-	log.Printf("[leo] INFO 开始比对调用图")
-	// 修正调用图
+	// 故障项目经过插桩和故障注入, 去掉插桩的调用, 调用点的行号对不上原项目, 也去掉
+	run.static.DropCallees(caller.CollectorPath)
+	run.static.StripPositions()
+	log.Printf("[leo] INFO ===== 故障调用图生成完毕: %v =====", p.fault.name)
+	return run, nil
+}
 
-	diffs := callgraph.Compare(rawCallGraph, modCallGraph, inputPath)
-	dyDiffs := callgraph.Compare(dyRawCallGraph, dyModCallGraph, inputPath)
-	if len(dyDiffs) > 0 {
-		diffs = append(diffs, dyDiffs...)
+// staticAnal 第一次调用时加载并分析整个项目, 之后直接从中切出testPath的调用图
+func staticAnal(program **callgraph.Program, inputPath, testPath string) (callgraph.EdgeMap, error) {
+	if *program == nil {
+		p, err := callgraph.LoadProgram(inputPath)
+		if err != nil {
+			return nil, err
+		}
+		*program = p
 	}
-	// /Users/misery/GolandProjects/rpc_demo/tttt/aaas MyT RunClient1
-	// /Users/misery/GolandProjects/rpc_demo/tttt/aaas MyT RunClient1$1
-	log.Printf("[leo] INFO 共有%v个diff", len(diffs))
-	log.Printf("[leo] INFO 调用图比对完成")
-	return diffs, nil
+	return (*program).Anal(testPath)
+}
+
+// mergeSkipped 合并跳过的包, 同一构建配置下的同一个包只保留一次
+func mergeSkipped(a, b []*callgraph.SkippedPackage) []*callgraph.SkippedPackage {
+	seen := make(map[string]bool, len(a))
+	for _, s := range a {
+		seen[s.Path+"@"+s.Config] = true
+	}
+	for _, s := range b {
+		if !seen[s.Path+"@"+s.Config] {
+			seen[s.Path+"@"+s.Config] = true
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+// reportSkipped 列出-tolerant模式下加载项目时跳过的包, 并导出到-exportdir
+func reportSkipped(kind string, skipped []*callgraph.SkippedPackage) {
+	if len(skipped) == 0 {
+		return
+	}
+	log.Printf("[leo] WARN %v项目中有%v个包因为错误没有分析:", kind, len(skipped))
+	for _, s := range skipped {
+		if s.Cause != "" {
+			log.Printf("[leo] WARN   %v %v: 依赖的%v有错误", s.Path, s.Config, s.Cause)
+		} else {
+			log.Printf("[leo] WARN   %v %v: %v", s.Path, s.Config, strings.Join(s.Errors, "; "))
+		}
+	}
+	if err := callgraph.ExportSkipped(kind, skipped); err != nil {
+		log.Printf("[leo] WARN 跳过的包导出失败, err: %v", err)
+	}
+}
+
+// graphName 用测试文件夹相对于项目的路径命名输出的调用图
+func graphName(inputPath, testPath string) string {
+	rel := strings.Trim(strings.TrimPrefix(testPath, inputPath), constant.Separator)
+	if rel == "" {
+		return "root"
+	}
+	return strings.ReplaceAll(rel, constant.Separator, "_")
 }
 
 func InsertCollector(inputPath, outputPath string, num int) error {
@@ -238,7 +558,7 @@ func InsertCollector(inputPath, outputPath string, num int) error {
 		if strings.HasSuffix(k, "_test.go") {
 			continue
 		}
-		code := caller.StartCollect(file.File, num)
+		code := caller.StartCollect(file.File, file.Fset, num)
 		err = util.CreateFile(util.CompareAndExchange(k, outputPath, inputPath), code)
 		if err != nil {
 			return err
@@ -248,7 +568,7 @@ func InsertCollector(inputPath, outputPath string, num int) error {
 }
 
 // fixCallGraph 因为文件名变了，需要修正
-func fixCallGraph(graph map[string]map[string]string, bf, af string) map[string]map[string]string {
+func fixCallGraph(graph callgraph.EdgeMap, bf, af string) callgraph.EdgeMap {
 	for n, m := range graph {
 		delete(graph, n)
 		exchange := util.CompareAndExchange(n, bf, af)
@@ -266,7 +586,7 @@ func fillPackage(files map[string]*_ast.File, notGoFiles []string, outputPath, i
 	for k, v := range files {
 		if !v.Logged {
 			err := util.CreateFile(util.CompareAndExchange(k, outputPath, inputPath),
-				util.GetFileCode(v.File))
+				util.GetFileCode(v.Fset, v.File))
 			if err != nil {
 				return err
 			}
@@ -4,6 +4,9 @@ import (
 	"github.com/dataznGao/leo/pkg/callgraph"
 	_ast "github.com/dataznGao/leo/pkg/log/ast"
 	"github.com/dataznGao/leo/util"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -26,6 +29,7 @@ func TestInjureLog(t *testing.T) {
 	}
 	diffs := make([]*callgraph.Diff, 0)
 	diffs = append(diffs, &callgraph.Diff{
+		Kind: callgraph.EdgeRemovedDiff,
 		NodeA: &callgraph.Node{
 			Caller: &callgraph.Func{
 				FilePath:   "/Users/misery/GolandProjects/rpc_demo/tttt/aaas",
@@ -43,6 +47,7 @@ func TestInjureLog(t *testing.T) {
 		},
 	})
 	diffs = append(diffs, &callgraph.Diff{
+		Kind: callgraph.EdgeRemovedDiff,
 		NodeA: &callgraph.Node{
 			Caller: &callgraph.Func{
 				FilePath:   "/Users/misery/GolandProjects/rpc_demo/tttt/aaas",
@@ -75,10 +80,86 @@ func dedupDiff(diff []*callgraph.Diff) []*callgraph.Diff {
 	res := make([]*callgraph.Diff, 0)
 	deMap := make(map[string]*callgraph.Diff, 0)
 	for _, di := range diff {
-		deMap[di.ToString()] = di
+		deMap[di.Key()] = di
 	}
 	for _, d := range deMap {
 		res = append(res, d)
 	}
 	return res
 }
+
+func TestMergeSkipped(t *testing.T) {
+	a := []*callgraph.SkippedPackage{{Path: "example.com/a"}}
+	b := []*callgraph.SkippedPackage{{Path: "example.com/a"}, {Path: "example.com/a", Config: "windows/amd64"}, {Path: "example.com/b"}}
+	if got := mergeSkipped(a, b); len(got) != 3 {
+		t.Errorf("got %v skipped packages, want 3", len(got))
+	}
+}
+
+func TestGenerateDiffRecovers(t *testing.T) {
+	// 没有路径分隔符的inputPath会让generateGraphs越界panic
+	res, err := generateDiff("leo", "leo", "out")
+	if err == nil || res != nil {
+		t.Errorf("a panic should be reported as an error, got %v, %v", res, err)
+	}
+}
+
+func TestDiffLogTyped(t *testing.T) {
+	skipIfLoaderBroken(t)
+	dir := writeFiles(t, map[string]string{
+		"go.mod": "module example.com/typed\n\ngo 1.18\n",
+		"demo/demo.go": `package demo
+
+type file struct{}
+
+func (f *file) Close() error { return nil }
+
+type conn struct{}
+
+func (c conn) Close() error { return nil }
+
+func Run(f *file, c conn) {
+	c.Close()
+	f.Close()
+}
+`,
+	})
+	outputPath := filepath.Join(t.TempDir(), "out")
+	demo := filepath.Join(dir, "demo")
+	diff := &callgraph.Diff{
+		Kind: callgraph.EdgeRemovedDiff,
+		NodeA: &callgraph.Node{
+			Caller: &callgraph.Func{FilePath: demo, FuncName: "Run"},
+			Callee: &callgraph.Func{FilePath: demo, StructName: "file", FuncName: "Close", IsPointer: true},
+		},
+	}
+	if err := DiffLog(dir, outputPath, []*callgraph.Diff{diff}, nil); err != nil {
+		t.Fatal(err)
+	}
+	code, err := os.ReadFile(filepath.Join(outputPath, "demo", "demo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按名字匹配会把日志注入到先出现的c.Close()之后, 有类型信息时只匹配(*file).Close
+	if !strings.Contains(string(code), "f.Close()\n\tlog.Print") || strings.Contains(string(code), "c.Close()\n\tlog.Print") {
+		t.Errorf("the log is not injected after f.Close():\n%s", code)
+	}
+}
+
+func TestNewFaultyProjects(t *testing.T) {
+	projects := newFaultyProjects("/work")
+	if len(projects) != len(faultTypes) {
+		t.Fatalf("got %v projects, want one per fault type", len(projects))
+	}
+	seen := make(map[string]bool)
+	for i, p := range projects {
+		// 0是原始调用图的编号
+		if p.num != i+1 || seen[p.dir] || seen[p.enhanced] {
+			t.Errorf("project %v reuses a number or directory: %+v", p.fault.name, p)
+		}
+		seen[p.dir], seen[p.enhanced] = true, true
+	}
+	if !strings.Contains(faultsKey(), "exception-unhandled") {
+		t.Errorf("faultsKey() = %q misses a fault type", faultsKey())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"go/token"
 	"io"
 	"os"
+	"reflect"
 )
 
 func GetIfCode(node *ast.IfStmt) (string, error) {
@@ -34,16 +35,96 @@ func GetAssignCode(node *ast.AssignStmt) string {
 	return buffer.String()
 }
 
-func GetFileCode(node *ast.File) []byte {
+// GetFileCode 用解析文件时的fset打印文件, 保留注释、空行和编译指令, 只有注入的语句是新增的行。
+// fset为nil时按没有位置信息的语法树重新排版
+func GetFileCode(fset *token.FileSet, node *ast.File) []byte {
+	if fset == nil {
+		fset = token.NewFileSet()
+	} else {
+		placeInserted(fset, node)
+	}
 	var output []byte
 	buffer := bytes.NewBuffer(output)
-	err := format.Node(buffer, token.NewFileSet(), node)
+	err := format.Node(buffer, fset, node)
 	if err != nil {
 		panic(err)
 	}
 	return buffer.Bytes()
 }
 
+// placeInserted 给注入的、没有位置的语句补上位置, 否则打印时注释会按位置穿插到注入的语句中间。
+// 插入的语句放在前一条语句(或者块的左括号)所在行的行尾: 前一条语句行尾的注释留在它后面,
+// 下一条语句之前的注释和空行仍然跟着下一条语句。补上的else分支放在if分支的右括号处
+func placeInserted(fset *token.FileSet, file *ast.File) {
+	lineEnd := func(pos token.Pos) token.Pos {
+		f := fset.File(pos)
+		if f == nil {
+			return pos
+		}
+		if line := f.Line(pos); line < f.LineCount() {
+			return f.LineStart(line+1) - 1
+		}
+		return token.Pos(f.Base() + f.Size())
+	}
+	placeList := func(list []ast.Stmt, start token.Pos) {
+		if !start.IsValid() {
+			return
+		}
+		anchor := lineEnd(start)
+		for _, stmt := range list {
+			if !stmt.Pos().IsValid() {
+				fillPos(stmt, anchor)
+			} else {
+				anchor = lineEnd(stmt.End())
+			}
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.BlockStmt:
+			placeList(x.List, x.Lbrace)
+		case *ast.CaseClause:
+			placeList(x.Body, x.Colon)
+		case *ast.CommClause:
+			placeList(x.Body, x.Colon)
+		case *ast.IfStmt:
+			if x.Else != nil && !x.Else.Pos().IsValid() && x.Body.Rbrace.IsValid() {
+				fillPos(x.Else, x.Body.Rbrace)
+			}
+		}
+		return true
+	})
+}
+
+// optionalPos 是否有效本身有含义的位置: 调用是否展开变参, 是否是类型别名, 声明是否带括号
+var optionalPos = map[string]bool{
+	"CallExpr.Ellipsis": true,
+	"TypeSpec.Assign":   true,
+	"GenDecl.Lparen":    true,
+	"GenDecl.Rparen":    true,
+}
+
+// fillPos 把node中所有没有设置的位置设为pos
+func fillPos(node ast.Node, pos token.Pos) {
+	posType := reflect.TypeOf(token.NoPos)
+	ast.Inspect(node, func(n ast.Node) bool {
+		v := reflect.ValueOf(n)
+		if n == nil || v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return n != nil
+		}
+		v = v.Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if optionalPos[v.Type().Name()+"."+v.Type().Field(i).Name] {
+				continue
+			}
+			if f := v.Field(i); f.Type() == posType && f.Int() == int64(token.NoPos) {
+				f.SetInt(int64(pos))
+			}
+		}
+		return true
+	})
+}
+
 func Copy(src, dst string) (int64, error) {
 	sourceFileStat, err := os.Stat(src)
 	if err != nil {
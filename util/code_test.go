@@ -0,0 +1,98 @@
+package util
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const commentedSrc = `//go:build linux
+// +build linux
+
+//go:generate stringer -type=Mode
+
+// Package demo 演示
+package demo
+
+import "os"
+
+// Load 读取文件
+func Load(name string) error {
+	// 打开文件
+	f, err := os.Open(name) // 可能失败
+	// 检查错误
+	if err != nil {
+		return err
+	}
+
+	/* 关闭 */
+	defer f.Close()
+	switch name {
+	case "a":
+		// a
+		return nil
+	}
+	return nil
+}
+`
+
+func mark() ast.Stmt {
+	return &ast.ExprStmt{X: &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: ast.NewIdent("log"), Sel: ast.NewIdent("Print")},
+		Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: `"mark"`}},
+	}}
+}
+
+func TestGetFileCodeKeepsComments(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "demo.go", commentedSrc, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := f.Decls[1].(*ast.FuncDecl).Body
+	ifStmt := body.List[1].(*ast.IfStmt)
+	ifStmt.Body.List = append([]ast.Stmt{mark()}, ifStmt.Body.List...)
+	ifStmt.Else = &ast.BlockStmt{List: []ast.Stmt{mark()}}
+	clause := body.List[3].(*ast.SwitchStmt).Body.List[0].(*ast.CaseClause)
+	clause.Body = append([]ast.Stmt{mark()}, clause.Body...)
+	check := &ast.IfStmt{
+		Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+		Body: &ast.BlockStmt{List: []ast.Stmt{mark()}},
+	}
+	body.List = append([]ast.Stmt{mark(), body.List[0], check}, body.List[1:]...)
+
+	got := string(GetFileCode(fset, f))
+	want := strings.Replace(commentedSrc, `func Load(name string) error {
+	// 打开文件
+	f, err := os.Open(name) // 可能失败
+	// 检查错误
+	if err != nil {
+		return err
+	}
+`, `func Load(name string) error {
+	log.Print("mark")
+	// 打开文件
+	f, err := os.Open(name) // 可能失败
+	if err != nil {
+		log.Print("mark")
+	}
+	// 检查错误
+	if err != nil {
+		log.Print("mark")
+		return err
+	} else {
+		log.Print("mark")
+	}
+`, 1)
+	want = strings.Replace(want, `	case "a":
+		// a
+`, `	case "a":
+		log.Print("mark")
+		// a
+`, 1)
+	if got != want {
+		t.Errorf("only the inserted lines should change, got:\n%s", got)
+	}
+}
@@ -177,40 +177,15 @@ func CreateFile(path string, code []byte) error {
 	return err
 }
 
+// GetPackageName 返回inputPath下go.mod声明的module路径, 没有go.mod时返回空字符串。
+// 多module的项目请使用LoadWorkspace
 func GetPackageName(inputPath string) string {
-	file, err := ioutil.ReadFile(inputPath + "/go.mod")
+	mod, err := readModule(inputPath)
 	if err != nil {
-		panic(err)
+		log.Printf("[leo] WARN 读取module路径失败, inputPath: %v, err: %v", inputPath, err)
+		return ""
 	}
-	res := ""
-	fileStr := string(file)
-	for i := range fileStr {
-		if i+6 == len(fileStr) {
-			log.Fatalf("[GetPackageName] can`t get package name, please check your inputPath: %v", inputPath)
-		}
-		if fileStr[i:i+6] == "module" {
-			start := i + 6
-			for start < len(fileStr) {
-				if fileStr[start] == ' ' {
-					start++
-				} else {
-					break
-				}
-			}
-			end := start
-			for end < len(fileStr) {
-				if fileStr[end] != '\n' {
-					end++
-				} else {
-					break
-				}
-			}
-			res = fileStr[start:end]
-			break
-		}
-	}
-
-	return strings.TrimSpace(res)
+	return mod.Path
 }
 
 func Dedup(strs []string) []string {
@@ -0,0 +1,286 @@
+package util
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Module 项目中的一个go module
+type Module struct {
+	Path string
+	Dir  string
+	Go   string
+}
+
+// Workspace 项目根目录下的所有module, 这些module中的包都视为项目自身的包
+type Workspace struct {
+	Root    string
+	Modules []*Module
+	// GoWork 根目录下的go.work, 没有时为空
+	GoWork string
+
+	mu sync.Mutex
+	// tests go/packages识别出的外部测试包和测试main包, 值为被测试的包
+	tests map[string]string
+}
+
+// LoadWorkspace 找出root下的所有module: 有go.work时取其中use的module,
+// 否则取root下(跳过隐藏目录、vendor和testdata)所有go.mod所在的module
+func LoadWorkspace(root string) (*Workspace, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	ws := &Workspace{Root: root}
+	dirs := make([]string, 0)
+	if work := filepath.Join(root, "go.work"); FileExists(work) {
+		// 由go命令解析go.work, 新版本的go.work语法x/mod未必能识别
+		cmd := exec.Command("go", "list", "-m", "-f", "{{.Dir}}")
+		cmd.Dir = root
+		cmd.Env = append(os.Environ(), "GOWORK="+work, "GOFLAGS=")
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("go list -m in %s: %v", root, err)
+		}
+		ws.GoWork = work
+		for _, dir := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				dirs = append(dirs, filepath.Clean(dir))
+			}
+		}
+	} else {
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && path != root {
+				name := d.Name()
+				if strings.HasPrefix(name, ".") || name == "vendor" || name == "testdata" {
+					return filepath.SkipDir
+				}
+			}
+			if !d.IsDir() && d.Name() == "go.mod" {
+				dirs = append(dirs, filepath.Dir(path))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range dirs {
+		mod, err := readModule(dir)
+		if err != nil {
+			return nil, err
+		}
+		ws.Modules = append(ws.Modules, mod)
+	}
+	if len(ws.Modules) == 0 {
+		return nil, fmt.Errorf("no go.mod or go.work found in %s", root)
+	}
+	// 越长的module路径越靠前, 保证按前缀查找时命中最内层的module
+	sort.Slice(ws.Modules, func(i, j int) bool {
+		return len(ws.Modules[i].Path) > len(ws.Modules[j].Path)
+	})
+	return ws, nil
+}
+
+func readModule(dir string) (*Module, error) {
+	gomod := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return nil, err
+	}
+	file, err := modfile.ParseLax(gomod, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if file.Module == nil {
+		return nil, fmt.Errorf("%s has no module directive", gomod)
+	}
+	mod := &Module{Path: file.Module.Mod.Path, Dir: dir}
+	if file.Go != nil && len(file.Go.Syntax.Token) > 1 {
+		// ParseLax会把1.21.3截成1.21, 这里保留原始版本
+		mod.Go = file.Go.Syntax.Token[1]
+	}
+	return mod, nil
+}
+
+// FileExists path是否存在且不是目录
+func FileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// hasPathPrefix path是否等于prefix或以prefix/开头
+func hasPathPrefix(path, prefix, sep string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+sep)
+}
+
+// Paths 所有module的路径
+func (ws *Workspace) Paths() []string {
+	res := make([]string, 0, len(ws.Modules))
+	for _, mod := range ws.Modules {
+		res = append(res, mod.Path)
+	}
+	return res
+}
+
+// Contains 导入路径是否属于工作区中的某个module, 登记过的测试包(xxx_test、xxx.test)也算
+func (ws *Workspace) Contains(importPath string) bool {
+	return ws.ModuleOf(importPath) != nil
+}
+
+// AddTestPackage 登记外部测试包或测试main包importPath, forTest为它测试的包。
+// 只有登记过的包才会去掉_test、.test后缀, 名字以_test结尾的普通包不受影响
+func (ws *Workspace) AddTestPackage(importPath, forTest string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.tests == nil {
+		ws.tests = make(map[string]string)
+	}
+	ws.tests[importPath] = forTest
+}
+
+// TestPackages 已登记的测试包及其测试的包
+func (ws *Workspace) TestPackages() map[string]string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	res := make(map[string]string, len(ws.tests))
+	for k, v := range ws.tests {
+		res[k] = v
+	}
+	return res
+}
+
+// trimTestSuffix 登记过的测试包换成它测试的包, 其他导入路径原样返回
+func (ws *Workspace) trimTestSuffix(importPath string) string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if forTest, ok := ws.tests[importPath]; ok {
+		return forTest
+	}
+	return importPath
+}
+
+// ModuleOf 返回导入路径所属的module, 不属于工作区时返回nil
+func (ws *Workspace) ModuleOf(importPath string) *Module {
+	importPath = ws.trimTestSuffix(importPath)
+	for _, mod := range ws.Modules {
+		if hasPathPrefix(importPath, mod.Path, "/") {
+			return mod
+		}
+	}
+	return nil
+}
+
+// ImportPath 目录对应的导入路径, 目录不在任何module中时返回空字符串
+func (ws *Workspace) ImportPath(dir string) string {
+	dir = filepath.Clean(dir)
+	var best *Module
+	for _, mod := range ws.Modules {
+		if hasPathPrefix(dir, mod.Dir, string(filepath.Separator)) && (best == nil || len(mod.Dir) > len(best.Dir)) {
+			best = mod
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	rel, _ := filepath.Rel(best.Dir, dir)
+	if rel == "." {
+		return best.Path
+	}
+	return best.Path + "/" + filepath.ToSlash(rel)
+}
+
+// Dir 导入路径对应的目录, 不属于工作区时返回空字符串
+func (ws *Workspace) Dir(importPath string) string {
+	importPath = ws.trimTestSuffix(importPath)
+	mod := ws.ModuleOf(importPath)
+	if mod == nil {
+		return ""
+	}
+	return filepath.Join(mod.Dir, filepath.FromSlash(strings.TrimPrefix(importPath, mod.Path)))
+}
+
+// Patterns 在根目录下加载所有module中的包的模式, 如./...、./sub/...
+func (ws *Workspace) Patterns() []string {
+	res := make([]string, 0, len(ws.Modules))
+	for _, mod := range ws.Modules {
+		rel, _ := filepath.Rel(ws.Root, mod.Dir)
+		if rel == "." {
+			res = append(res, "./...")
+		} else {
+			res = append(res, "./"+filepath.ToSlash(rel)+"/...")
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// GoWorkEnv 返回在根目录下加载所有module时需要的环境变量: 已有go.work时使用它,
+// 多个module且没有go.work时在tmpDir中生成一个use所有module的go.work; 只有一个module时返回nil。
+// 工作区模式不允许-mod=mod/vendor, 会从GOFLAGS中去掉
+func (ws *Workspace) GoWorkEnv(tmpDir string) ([]string, error) {
+	if ws.GoWork == "" && len(ws.Modules) < 2 {
+		return nil, nil
+	}
+	flags := make([]string, 0)
+	for _, f := range strings.Fields(os.Getenv("GOFLAGS")) {
+		if !strings.HasPrefix(f, "-mod=") {
+			flags = append(flags, f)
+		}
+	}
+	env := []string{"GOFLAGS=" + strings.Join(flags, " ")}
+	if ws.GoWork != "" {
+		return append(env, "GOWORK="+ws.GoWork), nil
+	}
+	goVersion := "1.18"
+	for _, mod := range ws.Modules {
+		if mod.Go != "" && versionLess(goVersion, mod.Go) {
+			goVersion = mod.Go
+		}
+	}
+	dirs := make([]string, 0, len(ws.Modules))
+	for _, mod := range ws.Modules {
+		dirs = append(dirs, mod.Dir)
+	}
+	sort.Strings(dirs)
+	var work strings.Builder
+	fmt.Fprintf(&work, "go %s\n\nuse (\n", goVersion)
+	for _, dir := range dirs {
+		fmt.Fprintf(&work, "\t%s\n", modfile.AutoQuote(dir))
+	}
+	work.WriteString(")\n")
+	path := filepath.Join(tmpDir, "go.work")
+	if err := os.WriteFile(path, []byte(work.String()), 0666); err != nil {
+		return nil, err
+	}
+	return append(env, "GOWORK="+path), nil
+}
+
+// versionLess 比较1.18、1.21.3这样的go版本
+func versionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			fmt.Sscan(as[i], &x)
+		}
+		if i < len(bs) {
+			fmt.Sscan(bs[i], &y)
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
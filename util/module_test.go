@@ -0,0 +1,83 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	root := t.TempDir()
+	for name, code := range map[string]string{
+		"go.mod":          "module example.com/root\n\ngo 1.18\n",
+		"tools/go.mod":    "module example.com/root/tools\n\ngo 1.20\n",
+		"svc/x/go.mod":    "module example.com/svc\n\ngo 1.19\n",
+		"vendor/v/go.mod": "module example.com/vendored\n",
+		".git/go.mod":     "module example.com/hidden\n",
+	} {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err := os.WriteFile(path, []byte(code), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := LoadWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ws.Paths(), []string{"example.com/root/tools", "example.com/root", "example.com/svc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Paths() = %v, want %v", got, want)
+	}
+	check := func(name, got, want string) {
+		if got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	check("ImportPath(tools/cmd)", ws.ImportPath(filepath.Join(root, "tools", "cmd")), "example.com/root/tools/cmd")
+	check("ImportPath(svc/x)", ws.ImportPath(filepath.Join(root, "svc", "x")), "example.com/svc")
+	check("ImportPath(outside)", ws.ImportPath(t.TempDir()), "")
+	ws.AddTestPackage("example.com/root/tools/cmd.test", "example.com/root/tools/cmd")
+	ws.AddTestPackage("example.com/svc/api_test", "example.com/svc/api")
+	check("Dir(tools)", ws.Dir("example.com/root/tools/cmd.test"), filepath.Join(root, "tools", "cmd"))
+	check("Dir(svc)", ws.Dir("example.com/svc/api_test"), filepath.Join(root, "svc", "x", "api"))
+	// 名字以_test结尾但没有登记为外部测试包的普通包
+	check("Dir(plain)", ws.Dir("example.com/svc/load_test"), filepath.Join(root, "svc", "x", "load_test"))
+	check("Dir(other)", ws.Dir("example.com/rootless"), "")
+	if ws.Contains("example.com/vendored") || !ws.Contains("example.com/svc/api") {
+		t.Errorf("Contains mismatches the modules %v", ws.Paths())
+	}
+	if got, want := ws.Patterns(), []string{"./...", "./svc/x/...", "./tools/..."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Patterns() = %v, want %v", got, want)
+	}
+
+	env, err := ws.GoWorkEnv(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var work string
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GOFLAGS=") && strings.Contains(kv, "-mod=") {
+			t.Errorf("GOFLAGS keeps -mod, which workspace mode rejects: %v", kv)
+		}
+		if strings.HasPrefix(kv, "GOWORK=") {
+			data, err := os.ReadFile(strings.TrimPrefix(kv, "GOWORK="))
+			if err != nil {
+				t.Fatal(err)
+			}
+			work = string(data)
+		}
+	}
+	if !strings.HasPrefix(work, "go 1.20\n") || strings.Count(work, root) != 3 {
+		t.Errorf("generated go.work does not use all modules:\n%s", work)
+	}
+
+	single, err := LoadWorkspace(filepath.Join(root, "tools"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env, _ := single.GoWorkEnv(t.TempDir()); env != nil {
+		t.Errorf("a single module without go.work needs no extra env, got %v", env)
+	}
+}